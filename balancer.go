@@ -0,0 +1,330 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ErrNoEndpoint is returned by a Balancer with neither an endpoint matching
+// the tags of a call nor a default endpoint.
+var ErrNoEndpoint = errors.New("tracker: no endpoint for call")
+
+// ErrBalancerClosed is returned by calls on a closed Balancer.
+var ErrBalancerClosed = errors.New("tracker: balancer closed")
+
+// Endpoint is a server address with the tags of the instances behind it,
+// e.g. env=canary or lane=blue.
+type Endpoint struct {
+	Addr string
+	Tags map[string]string
+}
+
+// ParseEndpoints reads one endpoint per line, an address followed by its
+// tags, e.g.
+//
+//	10.0.0.9:9090
+//	10.0.0.10:9090 env=canary
+//	10.0.0.11:9090 lane=blue
+//
+// Blank lines and lines starting with # are skipped.
+func ParseEndpoints(r io.Reader) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		e := Endpoint{Addr: fields[0], Tags: make(map[string]string, len(fields)-1)}
+		for _, tag := range fields[1:] {
+			i := strings.IndexByte(tag, '=')
+			if i <= 0 {
+				return nil, fmt.Errorf("tracker: endpoints line %d: %q is not key=value", n, tag)
+			}
+			e.Tags[tag[:i]] = tag[i+1:]
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, scanner.Err()
+}
+
+// LoadEndpoints reads the endpoints of a file, see ParseEndpoints.
+func LoadEndpoints(filename string) ([]Endpoint, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseEndpoints(f)
+}
+
+// Balancer spreads calls over endpoints by the request meta of their
+// context. Only the meta keys it routes on count: a call goes to the
+// endpoints whose tags for these keys are those of the call, and falls back
+// to the default endpoints, tagged with none of the keys, when there are
+// none. Calls are spread round robin among the chosen endpoints.
+//
+// Meta travels downstream with the context, so a call routed into a lane
+// keeps to that lane at every hop whose clients are balanced on the same
+// keys. See NewLaneTracker for calls entering a lane without its tags.
+type Balancer struct {
+	keys   []string
+	dial   func(addr string) (*MuxClient, error)
+	next   uint32
+	mu     sync.Mutex
+	all    []Endpoint
+	conns  map[string]*balancerConn
+	closed bool
+}
+
+// balancerConn is the connection to an endpoint, dialed by the first call
+// needing it while concurrent calls wait for it.
+type balancerConn struct {
+	c      *MuxClient
+	err    error
+	dialed chan struct{} // closed under the lock of the balancer
+}
+
+// NewBalancer returns a balancer over endpoints routing on keys. dial
+// connects to an endpoint, typically with a MuxClient over a new TSocket,
+// connections are made on first use and shared by concurrent calls.
+func NewBalancer(endpoints []Endpoint, keys []string, dial func(addr string) (*MuxClient, error)) *Balancer {
+	return &Balancer{
+		keys:  keys,
+		dial:  dial,
+		all:   endpoints,
+		conns: make(map[string]*balancerConn),
+	}
+}
+
+// SetEndpoints replaces the endpoints, e.g. after reloading their file.
+// Connections to endpoints no longer listed are closed.
+func (b *Balancer) SetEndpoints(endpoints []Endpoint) {
+	listed := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		listed[e.Addr] = true
+	}
+	b.mu.Lock()
+	b.all = endpoints
+	var stale []*MuxClient
+	for addr, conn := range b.conns {
+		if !listed[addr] {
+			stale = append(stale, b.drop(addr, conn))
+		}
+	}
+	b.mu.Unlock()
+	closeAll(stale)
+}
+
+// Pick returns the endpoint for a call with ctx.
+func (b *Balancer) Pick(ctx context.Context) (Endpoint, error) {
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	b.mu.Lock()
+	all := b.all
+	b.mu.Unlock()
+	var matched, defaults []Endpoint
+	for _, e := range all {
+		match, tagged := true, false
+		for _, key := range b.keys {
+			v, ok := e.Tags[key]
+			tagged = tagged || ok
+			if v != meta[key] {
+				match = false
+			}
+		}
+		if match {
+			matched = append(matched, e)
+		}
+		if !tagged {
+			defaults = append(defaults, e)
+		}
+	}
+	if len(matched) == 0 {
+		matched = defaults
+	}
+	if len(matched) == 0 {
+		return Endpoint{}, ErrNoEndpoint
+	}
+	return matched[int(atomic.AddUint32(&b.next, 1)-1)%len(matched)], nil
+}
+
+// Call sends a call like MuxClient.Call to the endpoint picked for ctx.
+func (b *Balancer) Call(ctx context.Context, method string, args, result thrift.TStruct) error {
+	conn, addr, err := b.conn(ctx)
+	if err != nil {
+		return err
+	}
+	err = conn.c.Call(ctx, method, args, result)
+	b.check(addr, conn, err)
+	return err
+}
+
+// Oneway sends a call like MuxClient.Oneway to the endpoint picked for ctx.
+func (b *Balancer) Oneway(ctx context.Context, method string, args thrift.TStruct) error {
+	conn, addr, err := b.conn(ctx)
+	if err != nil {
+		return err
+	}
+	err = conn.c.Oneway(ctx, method, args)
+	b.check(addr, conn, err)
+	return err
+}
+
+// conn returns the connection to the endpoint picked for ctx, dialing it
+// outside the lock so that calls to other endpoints are not held up.
+func (b *Balancer) conn(ctx context.Context) (*balancerConn, string, error) {
+	e, err := b.Pick(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, "", ErrBalancerClosed
+	}
+	conn, ok := b.conns[e.Addr]
+	if !ok {
+		conn = &balancerConn{dialed: make(chan struct{})}
+		b.conns[e.Addr] = conn
+	}
+	b.mu.Unlock()
+	if !ok {
+		b.dialConn(e.Addr, conn)
+	}
+	select {
+	case <-conn.dialed:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	if conn.err != nil {
+		return nil, "", conn.err
+	}
+	return conn, e.Addr, nil
+}
+
+func (b *Balancer) dialConn(addr string, conn *balancerConn) {
+	c, err := b.dial(addr)
+	var stale *MuxClient
+	b.mu.Lock()
+	switch {
+	case b.conns[addr] != conn:
+		// dropped by SetEndpoints or Close while dialing
+		stale, c, err = c, nil, ErrNoEndpoint
+		if b.closed {
+			err = ErrBalancerClosed
+		}
+	case err != nil:
+		// the next call dials again
+		delete(b.conns, addr)
+	}
+	conn.c, conn.err = c, err
+	close(conn.dialed)
+	b.mu.Unlock()
+	if stale != nil {
+		stale.Close()
+	}
+}
+
+// drop removes the connection to addr, and returns its client to close once
+// the lock is released. A connection being dialed is closed by its dialer.
+func (b *Balancer) drop(addr string, conn *balancerConn) *MuxClient {
+	delete(b.conns, addr)
+	select {
+	case <-conn.dialed:
+		return conn.c
+	default:
+		return nil
+	}
+}
+
+// check drops the connection of a call failed by something else than the
+// server or the caller, the next call to addr dials again.
+func (b *Balancer) check(addr string, conn *balancerConn, err error) {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	if _, ok := err.(thrift.TApplicationException); ok {
+		return
+	}
+	var c *MuxClient
+	b.mu.Lock()
+	if b.conns[addr] == conn {
+		c = b.drop(addr, conn)
+	}
+	b.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+}
+
+// Close closes the connections to all endpoints, later calls fail with
+// ErrBalancerClosed.
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	var conns []*MuxClient
+	for addr, conn := range b.conns {
+		conns = append(conns, b.drop(addr, conn))
+	}
+	b.mu.Unlock()
+	closeAll(conns)
+	return nil
+}
+
+func closeAll(clients []*MuxClient) {
+	for _, c := range clients {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+type laneTracker struct {
+	Tracker
+	tags map[string]string
+}
+
+// NewLaneTracker wraps the server tracker t so that requests arriving without
+// the tags of the instance, e.g. lane=blue on an instance of the blue lane,
+// get them in their meta. The request thereby entered the lane, and balanced
+// downstream calls made with its context prefer the lane.
+func NewLaneTracker(t Tracker, tags map[string]string) Tracker {
+	return &laneTracker{Tracker: t, tags: tags}
+}
+
+func (t *laneTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	ctx, err := t.Tracker.TryReadRequestHeader(iprot)
+	if err != nil {
+		return ctx, err
+	}
+	origin, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	var meta map[string]string
+	for key, value := range t.tags {
+		if _, ok := origin[key]; ok {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string, len(origin)+len(t.tags))
+			for k, v := range origin {
+				meta[k] = v
+			}
+		}
+		meta[key] = value
+	}
+	if meta == nil {
+		return ctx, nil
+	}
+	if tracking, ok := TrackingFromCtx(ctx); ok {
+		return WithTracking(ctx, &Tracking{RequestID: tracking.RequestID, Seq: tracking.Seq, Meta: meta}), nil
+	}
+	return context.WithValue(ctx, CtxKeyRequestMeta, meta), nil
+}
@@ -0,0 +1,337 @@
+package tracker_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := tracker.ParseEndpoints(strings.NewReader(`
+# endpoints
+10.0.0.9:9090
+
+10.0.0.10:9090 env=canary
+  10.0.0.11:9090	lane=blue env=
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tracker.Endpoint{
+		{Addr: "10.0.0.9:9090", Tags: map[string]string{}},
+		{Addr: "10.0.0.10:9090", Tags: map[string]string{"env": "canary"}},
+		{Addr: "10.0.0.11:9090", Tags: map[string]string{"lane": "blue", "env": ""}},
+	}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Fatalf("got %+v, want %+v", endpoints, want)
+	}
+
+	for _, text := range []string{"10.0.0.9:9090 canary", "10.0.0.9:9090\n10.0.0.10:9090 =canary"} {
+		if _, err := tracker.ParseEndpoints(strings.NewReader(text)); err == nil {
+			t.Errorf("%q: got no error", text)
+		}
+	}
+}
+
+func laneCtx(kv ...string) context.Context {
+	ctx := context.Background()
+	for i := 0; i < len(kv); i += 2 {
+		ctx = tracker.WithRequestMeta(ctx, kv[i], kv[i+1])
+	}
+	return ctx
+}
+
+var laneEndpoints = []tracker.Endpoint{
+	{Addr: "default-1"},
+	{Addr: "default-2", Tags: map[string]string{"zone": "east"}},
+	{Addr: "canary", Tags: map[string]string{"env": "canary"}},
+	{Addr: "blue", Tags: map[string]string{"lane": "blue"}},
+	{Addr: "blue-canary", Tags: map[string]string{"lane": "blue", "env": "canary"}},
+}
+
+func TestBalancerPick(t *testing.T) {
+	b := tracker.NewBalancer(laneEndpoints, []string{"env", "lane"}, nil)
+	for _, c := range []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"no meta", context.Background(), []string{"default-1", "default-2"}},
+		{"lane", laneCtx("lane", "blue"), []string{"blue"}},
+		{"lane and env", laneCtx("lane", "blue", "env", "canary"), []string{"blue-canary"}},
+		{"env", laneCtx("env", "canary"), []string{"canary"}},
+		{"unknown lane", laneCtx("lane", "green"), []string{"default-1", "default-2"}},
+		{"other keys", laneCtx("zone", "west"), []string{"default-1", "default-2"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := make(map[string]int)
+			for i := 0; i < 2*len(c.want); i++ {
+				e, err := b.Pick(c.ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[e.Addr]++
+			}
+			// round robin over the chosen endpoints
+			for _, addr := range c.want {
+				if got[addr] != 2 {
+					t.Fatalf("picked %v, want %v twice each", got, c.want)
+				}
+			}
+		})
+	}
+
+	b = tracker.NewBalancer(laneEndpoints[2:], []string{"env", "lane"}, nil)
+	if _, err := b.Pick(laneCtx("lane", "green")); err != tracker.ErrNoEndpoint {
+		t.Fatalf("got %v, want ErrNoEndpoint without default endpoints", err)
+	}
+}
+
+// dialer serves the calculator at every address dialed, over pipes.
+type dialer struct {
+	mu       sync.Mutex
+	dials    map[string]int
+	clients  map[string]*tracker.MuxClient
+	handlers map[string]*methodHandler
+	block    chan struct{} // when set, dials wait for it to be closed
+	err      error
+}
+
+func newDialer() *dialer {
+	return &dialer{
+		dials:    make(map[string]int),
+		clients:  make(map[string]*tracker.MuxClient),
+		handlers: make(map[string]*methodHandler),
+	}
+}
+
+func (d *dialer) dial(t *testing.T) func(addr string) (*tracker.MuxClient, error) {
+	return func(addr string) (*tracker.MuxClient, error) {
+		d.mu.Lock()
+		d.dials[addr]++
+		block, err := d.block, d.err
+		handler := d.handlers[addr]
+		if handler == nil {
+			handler = &methodHandler{}
+			d.handlers[addr] = handler
+		}
+		d.mu.Unlock()
+		if block != nil && addr != "unblocked" {
+			<-block
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, handler)
+		})
+		t.Cleanup(func() { h.Close() })
+		c, err := tracker.NewMuxClient(h.ClientTracker, h.Transport, h.ProtocolFactory)
+		if err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		d.clients[addr] = c
+		d.mu.Unlock()
+		return c, nil
+	}
+}
+
+func (d *dialer) count(addr string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials[addr]
+}
+
+func (d *dialer) client(addr string) *tracker.MuxClient {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.clients[addr]
+}
+
+func balancedAdd(b *tracker.Balancer, ctx context.Context) error {
+	result := &calculator.CalculatorServiceAddResult{}
+	if err := b.Call(ctx, "add", &calculator.CalculatorServiceAddArgs{Num1: 1, Num2: 2}, result); err != nil {
+		return err
+	}
+	if result.GetSuccess() != 3 {
+		return errors.New("wrong sum")
+	}
+	return nil
+}
+
+func TestBalancerCall(t *testing.T) {
+	d := newDialer()
+	b := tracker.NewBalancer(laneEndpoints, []string{"env", "lane"}, d.dial(t))
+	defer b.Close()
+	for i := 0; i < 4; i++ {
+		if err := balancedAdd(b, laneCtx("lane", "blue")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Oneway(laneCtx("lane", "blue"), "log", &calculator.CalculatorServiceLogArgs{Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := balancedAdd(b, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d.count("blue") != 1 || len(d.handlers["blue"].Methods()) != 5 {
+		t.Fatalf("blue dialed %d times and called for %v, want one connection for 5 calls",
+			d.count("blue"), d.handlers["blue"].Methods())
+	}
+	if d.count("default-1")+d.count("default-2") != 1 || d.count("canary") != 0 {
+		t.Fatalf("got dials %v", d.dials)
+	}
+}
+
+func TestBalancerConcurrentDial(t *testing.T) {
+	d := newDialer()
+	d.block = make(chan struct{})
+	b := tracker.NewBalancer([]tracker.Endpoint{
+		{Addr: "slow"},
+		{Addr: "unblocked", Tags: map[string]string{"lane": "blue"}},
+	}, []string{"lane"}, d.dial(t))
+	defer b.Close()
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() { errs <- balancedAdd(b, context.Background()) }()
+	}
+	// a slow dial holds up neither other endpoints nor the balancer
+	if err := balancedAdd(b, laneCtx("lane", "blue")); err != nil {
+		t.Fatal(err)
+	}
+	close(d.block)
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := d.count("slow"); n != 1 {
+		t.Fatalf("dialed %d times, want concurrent first calls to share one dial", n)
+	}
+}
+
+func TestBalancerDialError(t *testing.T) {
+	d := newDialer()
+	d.err = errors.New("connection refused")
+	b := tracker.NewBalancer(laneEndpoints[:1], nil, d.dial(t))
+	defer b.Close()
+	if err := balancedAdd(b, context.Background()); err != d.err {
+		t.Fatalf("got %v, want the dial error", err)
+	}
+	d.mu.Lock()
+	d.err = nil
+	d.mu.Unlock()
+	if err := balancedAdd(b, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.count("default-1"); n != 2 {
+		t.Fatalf("dialed %d times, want a failed dial retried", n)
+	}
+}
+
+func TestBalancerSetEndpoints(t *testing.T) {
+	d := newDialer()
+	b := tracker.NewBalancer(laneEndpoints[:1], nil, d.dial(t))
+	defer b.Close()
+	if err := balancedAdd(b, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	old := d.client("default-1")
+
+	b.SetEndpoints([]tracker.Endpoint{{Addr: "default-3"}})
+	if err := balancedAdd(b, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d.count("default-3") != 1 || len(d.handlers["default-1"].Methods()) != 1 {
+		t.Fatalf("got dials %v, want the call on the new endpoint", d.dials)
+	}
+	if err := old.Call(context.Background(), "ping", &calculator.CalculatorServicePingArgs{},
+		&calculator.CalculatorServicePingResult{}); err != tracker.ErrClientClosed {
+		t.Fatalf("got %v, want the connection to the unlisted endpoint closed", err)
+	}
+}
+
+func TestBalancerClose(t *testing.T) {
+	d := newDialer()
+	b := tracker.NewBalancer(laneEndpoints[:1], nil, d.dial(t))
+	if err := balancedAdd(b, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := balancedAdd(b, context.Background()); err != tracker.ErrBalancerClosed {
+		t.Fatalf("got %v, want ErrBalancerClosed", err)
+	}
+	if err := d.client("default-1").Oneway(context.Background(), "log", &calculator.CalculatorServiceLogArgs{}); err != tracker.ErrClientClosed {
+		t.Fatalf("got %v, want the connection closed", err)
+	}
+	if n := d.count("default-1"); n != 1 {
+		t.Fatalf("dialed %d times after Close", n)
+	}
+}
+
+func TestBalancerCloseWhileDialing(t *testing.T) {
+	d := newDialer()
+	d.block = make(chan struct{})
+	b := tracker.NewBalancer(laneEndpoints[:1], nil, d.dial(t))
+	errs := make(chan error, 1)
+	go func() { errs <- balancedAdd(b, context.Background()) }()
+	for d.count("default-1") == 0 {
+		runtime.Gosched()
+	}
+	b.Close()
+	close(d.block)
+	if err := <-errs; err != tracker.ErrBalancerClosed {
+		t.Fatalf("got %v, want ErrBalancerClosed", err)
+	}
+	if err := d.client("default-1").Oneway(context.Background(), "log", &calculator.CalculatorServiceLogArgs{}); err != tracker.ErrClientClosed {
+		t.Fatalf("got %v, want the connection dialed meanwhile closed", err)
+	}
+}
+
+func TestLaneTracker(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		ctx  context.Context
+		opts []trackertest.Option
+		want map[string]string
+	}{
+		{"enters the lane", laneCtx("user", "u1"), nil, map[string]string{"user": "u1", "lane": "blue"}},
+		{"keeps its lane", laneCtx("lane", "green"), nil, map[string]string{"lane": "green"}},
+		{"untracked client", context.Background(), []trackertest.Option{trackertest.WithOldClient()}, map[string]string{"lane": "blue"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			handler := &ctxHandler{}
+			h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+				return calculator.NewCalculatorServiceProcessor(tracker.NewLaneTracker(t, map[string]string{"lane": "blue"}), handler)
+			}, c.opts...)
+			defer h.Close()
+			client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(c.ctx, tracker.CtxKeyRequestID, "req-1")
+			if _, err := client.Add(ctx, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if meta, _ := handler.ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string); !reflect.DeepEqual(meta, c.want) {
+				t.Fatalf("got meta %v, want %v", meta, c.want)
+			}
+			if len(c.opts) == 0 && handler.ctx.Value(tracker.CtxKeyRequestID) != "req-1" {
+				t.Fatal("request id lost")
+			}
+		})
+	}
+}
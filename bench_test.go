@@ -0,0 +1,80 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+func newUpgradedTracker() *SimpleTracker {
+	t := NewSimpleTracker("bench").(*SimpleTracker)
+	t.upgradeProtocol()
+	return t
+}
+
+func encodedHeader(b *testing.B) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	header := tracking.NewRequestHeader()
+	header.RequestID = "b7d4f5c2-6b8e-4d7b-9a63-2f0f4f3d1e8a"
+	header.Seq = "1.1"
+	header.Meta = map[string]string{"clientA": "ping", "lane": "canary"}
+	if err := header.Write(thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkTryReadRequestHeader(b *testing.B) {
+	data := encodedHeader(b)
+	t := newUpgradedTracker()
+	buf := thrift.NewTMemoryBuffer()
+	iprot := thrift.NewTBinaryProtocolTransport(buf)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		buf.Write(data)
+		if _, err := t.TryReadRequestHeader(iprot); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTryWriteRequestHeader propagates the context of an incoming
+// request, the hot path of a server calling downstream.
+func BenchmarkTryWriteRequestHeader(b *testing.B) {
+	data := encodedHeader(b)
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(data)
+	ctx, err := newUpgradedTracker().TryReadRequestHeader(thrift.NewTBinaryProtocolTransport(buf))
+	if err != nil {
+		b.Fatal(err)
+	}
+	t := newUpgradedTracker()
+	oprot := thrift.NewTBinaryProtocolTransport(buf)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := t.TryWriteRequestHeader(ctx, oprot); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTryWriteRequestHeaderNoID(b *testing.B) {
+	t := newUpgradedTracker()
+	buf := thrift.NewTMemoryBuffer()
+	oprot := thrift.NewTBinaryProtocolTransport(buf)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := t.TryWriteRequestHeader(ctx, oprot); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+type cacheEntry struct {
+	supported bool
+	expireAt  time.Time
+}
+
+// NegotiationCache remembers, per endpoint address, whether the peer supports
+// tracking, so that later connections can avoid the handshake round trip.
+type NegotiationCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+func NewNegotiationCache(ttl time.Duration) *NegotiationCache {
+	return &NegotiationCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Get returns whether addr supports tracking, ok is false when there is no
+// live entry for addr.
+func (c *NegotiationCache) Get(addr string) (supported, ok bool) {
+	c.mu.RLock()
+	e, ok := c.entries[addr]
+	c.mu.RUnlock()
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(e.expireAt) {
+		c.expire(addr)
+		return false, false
+	}
+	return e.supported, true
+}
+
+// expire drops the entry of addr unless a Set refreshed it meanwhile.
+func (c *NegotiationCache) expire(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[addr]; ok && time.Now().After(e.expireAt) {
+		delete(c.entries, addr)
+	}
+}
+
+func (c *NegotiationCache) Set(addr string, supported bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[addr] = cacheEntry{
+		supported: supported,
+		expireAt:  time.Now().Add(c.ttl),
+	}
+}
+
+// Invalidate drops the entry of addr, callers should invoke it when a call
+// against addr fails with a protocol error.
+func (c *NegotiationCache) Invalidate(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, addr)
+}
+
+type cachedTracker struct {
+	Tracker
+	cache *NegotiationCache
+	addr  string
+}
+
+// NewCachedTracker wraps t so that its negotiation against addr consults
+// cache: peers known to be untracked are not negotiated with at all, peers
+// known to be tracked get a pipelined upgrade when possible (see
+// NewPipelinedTracker), and every real negotiation refreshes the entry of addr.
+func NewCachedTracker(cache *NegotiationCache, addr string, t Tracker) Tracker {
+	return &cachedTracker{
+		Tracker: t,
+		cache:   cache,
+		addr:    addr,
+	}
+}
+
+func (t *cachedTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	if supported, ok := t.cache.Get(t.addr); ok {
+		if !supported {
+			return nil // peer does not support tracker, skip the round trip
+		}
+		// the deferred upgrade reply refreshes or drops the entry
+		recvd := func(err error) {
+			switch {
+			case err == nil:
+				t.cache.Set(t.addr, true)
+			case errors.Is(err, ErrPeerUnsupported):
+				t.cache.Set(t.addr, false)
+			default:
+				t.cache.Invalidate(t.addr)
+			}
+		}
+		if ok, err := pipelineNegotiation(t.Tracker, curSeqID, iprot, oprot, recvd); ok {
+			if err != nil {
+				t.cache.Invalidate(t.addr)
+			}
+			return err
+		}
+	}
+	if err := t.Tracker.Negotiation(curSeqID, iprot, oprot); err != nil {
+		t.cache.Invalidate(t.addr)
+		return err
+	}
+	t.cache.Set(t.addr, t.Tracker.RequestHeaderSupported())
+	return nil
+}
@@ -0,0 +1,160 @@
+package tracker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+func TestNegotiationCache(t *testing.T) {
+	c := NewNegotiationCache(time.Hour)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("entry in empty cache")
+	}
+	c.Set("a", true)
+	c.Set("b", false)
+	if supported, ok := c.Get("a"); !ok || !supported {
+		t.Fatalf("a: got (%v, %v), want (true, true)", supported, ok)
+	}
+	if supported, ok := c.Get("b"); !ok || supported {
+		t.Fatalf("b: got (%v, %v), want (false, true)", supported, ok)
+	}
+	c.Invalidate("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a still cached after Invalidate")
+	}
+
+	expired := NewNegotiationCache(-time.Second)
+	expired.Set("a", true)
+	if _, ok := expired.Get("a"); ok {
+		t.Fatal("expired entry returned")
+	}
+	if len(expired.entries) != 0 {
+		t.Fatal("expired entry kept")
+	}
+}
+
+func TestNegotiationCacheExpireRefreshed(t *testing.T) {
+	c := NewNegotiationCache(time.Hour)
+	c.entries["a"] = cacheEntry{supported: true, expireAt: time.Now().Add(-time.Second)}
+	c.mu.RLock()
+	stale := c.entries["a"]
+	c.mu.RUnlock()
+	// a Set between the read of Get and its expiry wins
+	c.Set("a", false)
+	if time.Now().After(stale.expireAt) {
+		c.expire("a")
+	}
+	if supported, ok := c.Get("a"); !ok || supported {
+		t.Fatalf("got (%v, %v), want the refreshed entry", supported, ok)
+	}
+}
+
+func TestNegotiationCacheConcurrent(t *testing.T) {
+	c := NewNegotiationCache(time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Set("a", j%2 == 0)
+				c.Get("a")
+				if j%10 == 0 {
+					c.Invalidate("a")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func writeUpgradeReply(oprot thrift.TProtocol, seqID int32) {
+	oprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID)
+	tracking.NewUpgradeReply().Write(oprot)
+	oprot.WriteMessageEnd()
+}
+
+func writeUnknownMethod(oprot thrift.TProtocol, seqID int32) {
+	oprot.WriteMessageBegin(TrackingAPIName, thrift.EXCEPTION, seqID)
+	thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+TrackingAPIName).Write(oprot)
+	oprot.WriteMessageEnd()
+}
+
+func TestCachedTrackerUntracked(t *testing.T) {
+	cache := NewNegotiationCache(time.Hour)
+	in, out := thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+	writeUnknownMethod(thrift.NewTBinaryProtocolTransport(in), 1)
+	iprot, oprot := thrift.NewTBinaryProtocolTransport(in), thrift.NewTBinaryProtocolTransport(out)
+	if err := NewCachedTracker(cache, "peer", NewSimpleTracker("client")).Negotiation(1, iprot, oprot); err != nil {
+		t.Fatal(err)
+	}
+	if supported, ok := cache.Get("peer"); !ok || supported {
+		t.Fatalf("got (%v, %v), want untracked peer cached", supported, ok)
+	}
+
+	// the next connection skips the round trip
+	out.Reset()
+	ct := NewCachedTracker(cache, "peer", NewSimpleTracker("client"))
+	if err := ct.Negotiation(1, iprot, oprot); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 || ct.RequestHeaderSupported() {
+		t.Fatalf("negotiated with known untracked peer, wrote %d bytes", out.Len())
+	}
+}
+
+func TestCachedTrackerPipelined(t *testing.T) {
+	cases := []struct {
+		name  string
+		reply func(oprot thrift.TProtocol, seqID int32)
+		err   error
+	}{
+		{"supported", writeUpgradeReply, nil},
+		{"unsupported", writeUnknownMethod, ErrPeerUnsupported},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cache := NewNegotiationCache(time.Hour)
+			aging := time.Now().Add(time.Minute)
+			cache.entries["peer"] = cacheEntry{supported: true, expireAt: aging}
+			in, out := thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+			iprot := NewPipelinedProtocol(thrift.NewTBinaryProtocolTransport(in))
+			oprot := thrift.NewTBinaryProtocolTransport(out)
+
+			ct := NewCachedTracker(cache, "peer", NewSimpleTracker("client"))
+			if err := ct.Negotiation(1, iprot, oprot); err != nil {
+				t.Fatal(err)
+			}
+			if out.Len() == 0 {
+				t.Fatal("upgrade call not written")
+			}
+			if !ct.RequestHeaderSupported() {
+				t.Fatal("not upgraded optimistically")
+			}
+
+			c.reply(thrift.NewTBinaryProtocolTransport(in), 1)
+			wprot := thrift.NewTBinaryProtocolTransport(in)
+			wprot.WriteMessageBegin("ping", thrift.REPLY, 2)
+			_, _, seqID, err := iprot.ReadMessageBegin()
+			if !errors.Is(err, c.err) {
+				t.Fatalf("got %v, want %v", err, c.err)
+			}
+			if err == nil {
+				if seqID != 2 {
+					t.Fatalf("got seqid %d, want 2", seqID)
+				}
+				e := cache.entries["peer"]
+				if !e.supported || !e.expireAt.After(aging) {
+					t.Fatal("entry not refreshed by the upgrade reply")
+				}
+			} else if supported, ok := cache.Get("peer"); !ok || supported {
+				t.Fatalf("got (%v, %v), want untracked peer cached", supported, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Command thrift-tracker-dump prints the transcript of a captured byte stream
+// of a tracked thrift connection, one direction at a time: the upgrade
+// handshake, and every message with its request header, e.g.
+//
+//	$ thrift-tracker-dump -framed -idl calculator.thrift client.bin
+//	0 upgrade call seqid=1 {app_id: "client"}
+//	46 add call seqid=2 request_id="7a51..." seq="1" meta={"lane": "blue"} {num1: 1, num2: 2}
+//
+// With -json it prints one JSON object per message instead. Args and results
+// are decoded by field id, or by name with -idl.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eleme/thrift-tracker/wire"
+)
+
+func main() {
+	framed := flag.Bool("framed", false, "the stream is framed rather than buffered")
+	protocol := flag.String("protocol", "binary", "binary or compact")
+	jsonOut := flag.Bool("json", false, "print JSON objects")
+	idlFile := flag.String("idl", "", ".thrift file to decode args and results with")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: thrift-tracker-dump [flags] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || (*protocol != "binary" && *protocol != "compact") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts := wire.Options{Framed: *framed, Compact: *protocol == "compact"}
+	if *idlFile != "" {
+		idl, err := wire.LoadIDL(*idlFile)
+		if err != nil {
+			fatal(err)
+		}
+		opts.IDL = idl
+	}
+	in := os.Stdin
+	if name := flag.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	out := bufio.NewWriter(os.Stdout)
+	err := dump(out, wire.NewDecoder(in, opts), *jsonOut)
+	out.Flush()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "thrift-tracker-dump:", err)
+	os.Exit(1)
+}
+
+type record struct {
+	Offset  int64  `json:"offset"`
+	Upgrade bool   `json:"upgrade,omitempty"`
+	Method  string `json:"method"`
+	Type    string `json:"type"`
+	SeqID   int32  `json:"seqid"`
+	*wire.Header
+	Body *wire.Struct `json:"body"`
+}
+
+func dump(w io.Writer, d *wire.Decoder, jsonOut bool) error {
+	enc := json.NewEncoder(w)
+	for {
+		m, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if jsonOut {
+			err = enc.Encode(&record{
+				Offset:  m.Offset,
+				Upgrade: m.Upgrade(),
+				Method:  m.Name,
+				Type:    wire.TypeName(m.Type),
+				SeqID:   m.SeqID,
+				Header:  m.Header,
+				Body:    m.Body,
+			})
+		} else {
+			_, err = fmt.Fprintln(w, formatMessage(m))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func formatMessage(m *wire.Message) string {
+	name := m.Name
+	if m.Upgrade() {
+		name = "upgrade"
+	}
+	parts := []string{
+		strconv.FormatInt(m.Offset, 10),
+		name,
+		wire.TypeName(m.Type),
+		"seqid=" + strconv.Itoa(int(m.SeqID)),
+	}
+	if h := m.Header; h != nil {
+		if h.RequestID != "" || h.Seq != "" {
+			parts = append(parts, "request_id="+strconv.Quote(h.RequestID), "seq="+strconv.Quote(h.Seq))
+		}
+		if len(h.Meta) > 0 {
+			parts = append(parts, "meta="+formatMeta(h.Meta))
+		}
+	}
+	return strings.Join(append(parts, wire.Format(m.Body)), " ")
+}
+
+func formatMeta(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = strconv.Quote(k) + ": " + strconv.Quote(meta[k])
+	}
+	return "{" + strings.Join(keys, ", ") + "}"
+}
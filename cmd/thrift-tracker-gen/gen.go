@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+type genData struct {
+	Package string // of the generated file
+	Import  string // import path of the stock package
+	Stock   *stockPackage
+}
+
+type importSpec struct {
+	Name, Path string
+}
+
+func (d *genData) Imports() []importSpec {
+	var imports []importSpec
+	for name, path := range d.Stock.Imports {
+		switch name {
+		case "context", "thrift", "tracker", d.Stock.Name:
+			continue
+		}
+		imports = append(imports, importSpec{name, path})
+	}
+	sort.Slice(imports, func(i, j int) bool { return imports[i].Path < imports[j].Path })
+	return imports
+}
+
+// exports are the exported names of the stock package the generated file
+// declares again, so that it stands in for the output of the fork.
+type exports struct {
+	Types, Consts, Funcs []string
+}
+
+func (d *genData) Exports() exports {
+	generated := map[string]bool{"CtxTProcessorFunction": true}
+	for _, s := range d.Stock.Services {
+		for _, name := range []string{s.Name, s.Name + "Client", s.Name + "Processor", "New" + s.Name + "Processor"} {
+			generated[name] = true
+		}
+	}
+	isExported := func(name string) bool {
+		if generated[name] || !ast.IsExported(name) {
+			return false
+		}
+		// client constructors, which take a tracker in the generated file
+		for _, s := range d.Stock.Services {
+			if strings.HasPrefix(name, "New"+s.Name+"Client") {
+				return false
+			}
+		}
+		return true
+	}
+	var e exports
+	for name := range d.Stock.types {
+		if isExported(name) {
+			e.Types = append(e.Types, name)
+		}
+	}
+	for _, name := range d.Stock.consts {
+		if isExported(name) {
+			e.Consts = append(e.Consts, name)
+		}
+	}
+	for name := range d.Stock.funcs {
+		if isExported(name) {
+			e.Funcs = append(e.Funcs, name)
+		}
+	}
+	sort.Strings(e.Types)
+	sort.Strings(e.Consts)
+	sort.Strings(e.Funcs)
+	return e
+}
+
+var funcs = template.FuncMap{
+	"lower": func(s string) string { return strings.ToLower(s[:1]) + s[1:] },
+}
+
+// Modelled on the output of the tracker fork of the thrift compiler, see
+// example/calculator.
+var fileTemplate = template.Must(template.New("file").Funcs(funcs).Parse(`
+{{- define "params"}}ctx context.Context{{range .Params}}, {{.Name}} {{.Type}}{{end}}{{end}}
+{{- define "results"}}({{if .ResultType}}r {{.ResultType}}, {{end}}err error){{end}}
+
+// Code generated by thrift-tracker-gen from {{.Import}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	{{.Stock.Name}} "{{.Import}}"
+{{- range .Imports}}
+	{{.Name}} "{{.Path}}"
+{{- end}}
+)
+
+type CtxTProcessorFunction = tracker.CtxTProcessorFunction
+{{- with .Exports}}
+{{- if .Types}}
+
+type (
+{{- range .Types}}
+	{{.}} = {{$.Stock.Name}}.{{.}}
+{{- end}}
+)
+{{- end}}
+{{- if .Consts}}
+
+const (
+{{- range .Consts}}
+	{{.}} = {{$.Stock.Name}}.{{.}}
+{{- end}}
+)
+{{- end}}
+{{- if .Funcs}}
+
+var (
+{{- range .Funcs}}
+	{{.}} = {{$.Stock.Name}}.{{.}}
+{{- end}}
+)
+{{- end}}
+{{- end}}
+{{range $s := .Stock.Services}}
+type {{$s.Name}} interface {
+{{- range .Methods}}
+	{{.Name}}({{template "params" .}}) {{template "results" .}}
+{{- end}}
+}
+
+type {{$s.Name}}Client struct {
+	Tracker         tracker.Tracker
+	Transport       thrift.TTransport
+	ProtocolFactory thrift.TProtocolFactory
+	InputProtocol   thrift.TProtocol
+	OutputProtocol  thrift.TProtocol
+	SeqId           int32
+}
+
+func New{{$s.Name}}ClientFactory(ttracker tracker.Tracker, t thrift.TTransport, f thrift.TProtocolFactory) (*{{$s.Name}}Client, error) {
+	iprot := f.GetProtocol(t)
+	oprot := f.GetProtocol(t)
+	client := &{{$s.Name}}Client{
+		Tracker:         ttracker,
+		Transport:       t,
+		ProtocolFactory: f,
+		InputProtocol:   iprot,
+		OutputProtocol:  oprot,
+	}
+	client.SeqId++
+	if err := ttracker.Negotiation(client.SeqId, iprot, oprot); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func New{{$s.Name}}ClientProtocol(ttracker tracker.Tracker, t thrift.TTransport, iprot thrift.TProtocol, oprot thrift.TProtocol) (*{{$s.Name}}Client, error) {
+	client := &{{$s.Name}}Client{
+		Tracker:        ttracker,
+		Transport:      t,
+		InputProtocol:  iprot,
+		OutputProtocol: oprot,
+	}
+	client.SeqId++
+	if err := ttracker.Negotiation(client.SeqId, iprot, oprot); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+{{range $m := .Methods}}
+func (p *{{$s.Name}}Client) {{.Name}}({{template "params" .}}) {{template "results" .}} {
+	if err = p.send{{.Name}}(ctx{{range .Params}}, {{.Name}}{{end}}); err != nil {
+		return
+	}
+{{- if .Oneway}}
+	return
+{{- else}}
+	return p.recv{{.Name}}()
+{{- end}}
+}
+
+func (p *{{$s.Name}}Client) send{{.Name}}({{template "params" .}}) (err error) {
+	oprot := p.OutputProtocol
+	if oprot == nil {
+		oprot = p.ProtocolFactory.GetProtocol(p.Transport)
+		p.OutputProtocol = oprot
+	}
+	if err = p.Tracker.TryWriteRequestHeader(ctx, oprot); err != nil {
+		return
+	}
+	p.SeqId++
+	if err = oprot.WriteMessageBegin("{{.WireName}}", thrift.{{if .Oneway}}ONEWAY{{else}}CALL{{end}}, p.SeqId); err != nil {
+		return
+	}
+	args := {{$.Stock.Name}}.{{$s.Name}}{{.Name}}Args{
+{{- range $i, $f := .ArgFields}}
+		{{$f}}: {{(index $m.Params $i).Name}},
+{{- end}}
+	}
+	if err = args.Write(oprot); err != nil {
+		return
+	}
+	if err = oprot.WriteMessageEnd(); err != nil {
+		return
+	}
+	return oprot.Flush()
+}
+{{- if not .Oneway}}
+
+func (p *{{$s.Name}}Client) recv{{.Name}}() ({{if .ResultType}}value {{.ResultType}}, {{end}}err error) {
+	iprot := p.InputProtocol
+	if iprot == nil {
+		iprot = p.ProtocolFactory.GetProtocol(p.Transport)
+		p.InputProtocol = iprot
+	}
+	method, mTypeId, seqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return
+	}
+	if method != "{{.WireName}}" {
+		err = thrift.NewTApplicationException(thrift.WRONG_METHOD_NAME, "{{.WireName}} failed: wrong method name")
+		return
+	}
+	if p.SeqId != seqId {
+		err = thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID, "{{.WireName}} failed: out of sequence response")
+		return
+	}
+	if mTypeId == thrift.EXCEPTION {
+		x := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "Unknown Exception")
+		var x1 error
+		x1, err = x.Read(iprot)
+		if err != nil {
+			return
+		}
+		if err = iprot.ReadMessageEnd(); err != nil {
+			return
+		}
+		err = x1
+		return
+	}
+	if mTypeId != thrift.REPLY {
+		err = thrift.NewTApplicationException(thrift.INVALID_MESSAGE_TYPE_EXCEPTION, "{{.WireName}} failed: invalid message type")
+		return
+	}
+	result := {{$.Stock.Name}}.{{$s.Name}}{{.Name}}Result{}
+	if err = result.Read(iprot); err != nil {
+		return
+	}
+	if err = iprot.ReadMessageEnd(); err != nil {
+		return
+	}
+{{- range .Exceptions}}
+	if result.{{.Name}} != nil {
+		err = result.{{.Name}}
+		return
+	}
+{{- end}}
+{{- if .ResultType}}
+	value = result.GetSuccess()
+{{- end}}
+	return
+}
+{{- end}}
+{{end}}
+type {{$s.Name}}Processor struct {
+	tracker      tracker.Tracker
+	processorMap map[string]CtxTProcessorFunction
+	handler      {{$s.Name}}
+}
+
+func (p *{{$s.Name}}Processor) AddToProcessorMap(key string, processor CtxTProcessorFunction) {
+	p.processorMap[key] = processor
+}
+
+func (p *{{$s.Name}}Processor) GetProcessorFunction(key string) (processor CtxTProcessorFunction, ok bool) {
+	processor, ok = p.processorMap[key]
+	return processor, ok
+}
+
+func (p *{{$s.Name}}Processor) ProcessorMap() map[string]CtxTProcessorFunction {
+	return p.processorMap
+}
+
+func New{{$s.Name}}Processor(ttracker tracker.Tracker, handler {{$s.Name}}) *{{$s.Name}}Processor {
+	p := &{{$s.Name}}Processor{tracker: ttracker, handler: handler, processorMap: make(map[string]CtxTProcessorFunction)}
+{{- range .Methods}}
+	p.processorMap["{{.WireName}}"] = &{{lower $s.Name}}Processor{{.Name}}{tracker: ttracker, handler: handler}
+{{- end}}
+	return p
+}
+
+func (p *{{$s.Name}}Processor) Process(iprot, oprot thrift.TProtocol) (success bool, err thrift.TException) {
+	ctx, err := p.tracker.TryReadRequestHeader(iprot)
+	if err != nil {
+		return
+	}
+	name, _, seqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	if name == tracker.TrackingAPIName {
+		return p.tracker.TryUpgrade(seqId, iprot, oprot)
+	}
+	if processor, ok := p.GetProcessorFunction(name); ok {
+		return processor.Process(ctx, seqId, iprot, oprot)
+	}
+	iprot.Skip(thrift.STRUCT)
+	iprot.ReadMessageEnd()
+	x := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+name)
+	oprot.WriteMessageBegin(name, thrift.EXCEPTION, seqId)
+	x.Write(oprot)
+	oprot.WriteMessageEnd()
+	oprot.Flush()
+	return false, x
+}
+{{range $m := .Methods}}
+type {{lower $s.Name}}Processor{{.Name}} struct {
+	tracker tracker.Tracker
+	handler {{$s.Name}}
+}
+
+func (p *{{lower $s.Name}}Processor{{.Name}}) Process(ctx context.Context, seqId int32, iprot, oprot thrift.TProtocol) (success bool, err thrift.TException) {
+	args := {{$.Stock.Name}}.{{$s.Name}}{{.Name}}Args{}
+	if err = args.Read(iprot); err != nil {
+		iprot.ReadMessageEnd()
+{{- if not .Oneway}}
+		x := thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error())
+		oprot.WriteMessageBegin("{{.WireName}}", thrift.EXCEPTION, seqId)
+		x.Write(oprot)
+		oprot.WriteMessageEnd()
+		oprot.Flush()
+{{- end}}
+		return false, err
+	}
+	iprot.ReadMessageEnd()
+{{- if .Oneway}}
+	if err2 := p.handler.{{.Name}}(ctx{{range .ArgFields}}, args.{{.}}{{end}}); err2 != nil {
+		return true, err2
+	}
+	return true, nil
+{{- else}}
+	result := {{$.Stock.Name}}.{{$s.Name}}{{.Name}}Result{}
+{{- if .ResultType}}
+	var retval {{.ResultType}}
+{{- end}}
+	var err2 error
+	if {{if .ResultType}}retval, {{end}}err2 = p.handler.{{.Name}}(ctx{{range .ArgFields}}, args.{{.}}{{end}}); err2 != nil {
+{{- if .Exceptions}}
+		switch v := err2.(type) {
+{{- range .Exceptions}}
+		case {{.Type}}:
+			result.{{.Name}} = v
+{{- end}}
+		default:
+			x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "Internal error processing {{.WireName}}: "+err2.Error())
+			oprot.WriteMessageBegin("{{.WireName}}", thrift.EXCEPTION, seqId)
+			x.Write(oprot)
+			oprot.WriteMessageEnd()
+			oprot.Flush()
+			return true, err2
+		}
+{{- else}}
+		x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "Internal error processing {{.WireName}}: "+err2.Error())
+		oprot.WriteMessageBegin("{{.WireName}}", thrift.EXCEPTION, seqId)
+		x.Write(oprot)
+		oprot.WriteMessageEnd()
+		oprot.Flush()
+		return true, err2
+{{- end}}
+	}
+{{- if .ResultType}} else {
+		result.Success = {{if .SuccessPtr}}&{{end}}retval
+	}
+{{- end}}
+	if err2 = oprot.WriteMessageBegin("{{.WireName}}", thrift.REPLY, seqId); err2 != nil {
+		err = err2
+	}
+	if err2 = result.Write(oprot); err == nil && err2 != nil {
+		err = err2
+	}
+	if err2 = oprot.WriteMessageEnd(); err == nil && err2 != nil {
+		err = err2
+	}
+	if err2 = oprot.Flush(); err == nil && err2 != nil {
+		err = err2
+	}
+	if err != nil {
+		return
+	}
+	return true, err
+{{- end}}
+}
+{{end}}
+{{- end}}`))
+
+func generate(d *genData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
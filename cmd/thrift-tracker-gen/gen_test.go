@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+const echoImport = "github.com/eleme/thrift-tracker/cmd/thrift-tracker-gen/testdata/echo"
+
+func generateEcho(t *testing.T) []byte {
+	stock, err := loadStockPackage(filepath.Join("testdata", "echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(&genData{Package: "trackedecho", Import: echoImport, Stock: stock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestLoadStockPackage(t *testing.T) {
+	stock, err := loadStockPackage(filepath.Join("testdata", "echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stock.Services) != 1 || len(stock.Services[0].Methods) != 2 {
+		t.Fatalf("got services %+v", stock.Services)
+	}
+	echo, note := stock.Services[0].Methods[0], stock.Services[0].Methods[1]
+	if echo.WireName != "echo" || echo.Oneway || echo.ResultType != "string" || !echo.SuccessPtr {
+		t.Fatalf("got echo %+v", echo)
+	}
+	if len(echo.Exceptions) != 1 || echo.Exceptions[0] != (field{"Err", "*echo.EchoError"}) {
+		t.Fatalf("got echo exceptions %+v", echo.Exceptions)
+	}
+	if note.WireName != "note" || !note.Oneway || note.ResultType != "" {
+		t.Fatalf("got note %+v", note)
+	}
+}
+
+func TestGenerateGolden(t *testing.T) {
+	src := generateEcho(t)
+	golden := filepath.Join("testdata", "trackedecho.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Fatalf("output differs from %s, rerun with -update if intended:\n%s", golden, src)
+	}
+}
+
+// TestExampleUpToDate checks that example/calculator is the output of the
+// generator for example/gen-go/calculator, see the example makefile.
+func TestExampleUpToDate(t *testing.T) {
+	example := filepath.Join("..", "..", "example")
+	stock, err := loadStockPackage(filepath.Join(example, "gen-go", "calculator"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(&genData{
+		Package: "calculator",
+		Import:  "github.com/eleme/thrift-tracker/example/gen-go/calculator",
+		Stock:   stock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join(example, "calculator", "calculator.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Fatal("example/calculator/calculator.go is out of date, run make generate in example")
+	}
+}
+
+// TestGenerateCompiles type checks the output against the stock package and
+// the tracker package.
+func TestGenerateCompiles(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "echo.go", generateEcho(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("trackedecho", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+// Command thrift-tracker-gen generates tracked clients and processors on top of
+// the Go package of a stock thrift compiler, so that the tracker fork of the
+// compiler is not needed. For a service Foo it writes NewFooClientFactory,
+// taking a tracker like the fork does, NewFooProcessor, reading the request
+// header before each call, and the Foo handler interface with contexts. The
+// other exported names of the stock package are declared again as aliases,
+// so the output stands in for that of the fork.
+//
+// The output goes to its own package, as the stock package already has
+// clients and processors of the same names, e.g.
+//
+//	//go:generate thrift --gen go -out gen-go calculator.thrift
+//	//go:generate thrift-tracker-gen -in gen-go/calculator -import example.com/gen-go/calculator -out calculator/calculator.go
+//
+// Services extending another service are not supported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	in := flag.String("in", "", "directory of the stock generated package")
+	importPath := flag.String("import", "", "import path of the stock generated package")
+	out := flag.String("out", "", "file to write")
+	pkg := flag.String("package", "", "package of the written file (default: its directory name)")
+	flag.Parse()
+	if *in == "" || *importPath == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*in, *importPath, *out, *pkg); err != nil {
+		fmt.Fprintln(os.Stderr, "thrift-tracker-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, importPath, out, pkg string) error {
+	if pkg == "" {
+		abs, err := filepath.Abs(out)
+		if err != nil {
+			return err
+		}
+		pkg = filepath.Base(filepath.Dir(abs))
+	}
+	stock, err := loadStockPackage(in)
+	if err != nil {
+		return err
+	}
+	src, err := generate(&genData{Package: pkg, Import: importPath, Stock: stock})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, src, 0644)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type service struct {
+	Name    string // e.g. CalculatorService
+	Methods []*method
+}
+
+type method struct {
+	Name       string // Go name, e.g. Add
+	WireName   string // thrift name, e.g. add
+	Params     []field
+	ArgFields  []string // Args struct fields, in Params order
+	Oneway     bool
+	ResultType string // empty for void methods
+	SuccessPtr bool   // the Result struct holds a pointer to ResultType
+	Exceptions []field
+}
+
+type field struct {
+	Name string
+	Type string
+}
+
+// stockPackage is a package generated by a stock thrift compiler.
+type stockPackage struct {
+	Name     string
+	Services []*service
+	Imports  map[string]string // packages of included files by name, as used
+
+	imports map[string]string
+	types   map[string]*ast.TypeSpec
+	structs map[string]*ast.StructType
+	funcs   map[string]*ast.FuncDecl
+	consts  []string
+}
+
+func loadStockPackage(dir string) (*stockPackage, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s: want one package, found %d", dir, len(pkgs))
+	}
+	p := &stockPackage{
+		Imports: make(map[string]string),
+		imports: make(map[string]string),
+		types:   make(map[string]*ast.TypeSpec),
+		structs: make(map[string]*ast.StructType),
+		funcs:   make(map[string]*ast.FuncDecl),
+	}
+	for name, pkg := range pkgs {
+		p.Name = name
+		for _, f := range pkg.Files {
+			p.collect(f)
+		}
+	}
+
+	var names []string
+	for name, spec := range p.types {
+		if _, ok := spec.Type.(*ast.InterfaceType); ok && p.funcs["New"+name+"Processor"] != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no service found", dir)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s, err := p.loadService(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		p.Services = append(p.Services, s)
+	}
+	return p, nil
+}
+
+func (p *stockPackage) collect(f *ast.File) {
+	for _, spec := range f.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		p.imports[name] = importPath
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					p.types[s.Name.Name] = s
+					if st, ok := s.Type.(*ast.StructType); ok {
+						p.structs[s.Name.Name] = st
+					}
+				case *ast.ValueSpec:
+					if d.Tok != token.CONST {
+						continue
+					}
+					for _, n := range s.Names {
+						p.consts = append(p.consts, n.Name)
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				p.funcs[d.Name.Name] = d
+			}
+		}
+	}
+}
+
+func (p *stockPackage) loadService(name string) (*service, error) {
+	iface := p.types[name].Type.(*ast.InterfaceType)
+	sigs := make(map[string]*ast.FuncType)
+	for _, m := range iface.Methods.List {
+		if len(m.Names) == 0 {
+			return nil, fmt.Errorf("extends is not supported")
+		}
+		sigs[m.Names[0].Name] = m.Type.(*ast.FuncType)
+	}
+
+	s := &service{Name: name}
+	prefix := strings.ToLower(name[:1]) + name[1:] + "Processor"
+	var err error
+	// self.processorMap["add"] = &calculatorServiceProcessorAdd{handler:handler}
+	ast.Inspect(p.funcs["New"+name+"Processor"].Body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || err != nil || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			return true
+		}
+		index, ok := assign.Lhs[0].(*ast.IndexExpr)
+		if !ok {
+			return true
+		}
+		lit, ok := index.Index.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		unary, ok := assign.Rhs[0].(*ast.UnaryExpr)
+		if !ok {
+			return true
+		}
+		comp, ok := unary.X.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		typ, ok := comp.Type.(*ast.Ident)
+		if !ok || !strings.HasPrefix(typ.Name, prefix) {
+			return true
+		}
+		m := &method{Name: strings.TrimPrefix(typ.Name, prefix)}
+		m.WireName, _ = strconv.Unquote(lit.Value)
+		sig, ok := sigs[m.Name]
+		if !ok {
+			err = fmt.Errorf("method %s not in interface", m.Name)
+			return false
+		}
+		if err = p.loadMethod(s, m, sig); err == nil {
+			s.Methods = append(s.Methods, m)
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Methods) != len(sigs) {
+		return nil, fmt.Errorf("found %d of %d methods in New%sProcessor", len(s.Methods), len(sigs), name)
+	}
+	return s, nil
+}
+
+func (p *stockPackage) loadMethod(s *service, m *method, sig *ast.FuncType) error {
+	for _, param := range sig.Params.List {
+		typ, err := p.typeString(param.Type)
+		if err != nil {
+			return err
+		}
+		for _, n := range param.Names {
+			m.Params = append(m.Params, field{Name: n.Name, Type: typ})
+		}
+	}
+	args, ok := p.structs[s.Name+m.Name+"Args"]
+	if !ok {
+		return fmt.Errorf("%s%sArgs not found", s.Name, m.Name)
+	}
+	for _, f := range args.Fields.List {
+		for _, n := range f.Names {
+			m.ArgFields = append(m.ArgFields, n.Name)
+		}
+	}
+	if len(m.ArgFields) != len(m.Params) {
+		return fmt.Errorf("%s%sArgs does not match the parameters of %s", s.Name, m.Name, m.Name)
+	}
+
+	// (r T, err error) or (err error)
+	results := sig.Results.List
+	if len(results) == 2 {
+		typ, err := p.typeString(results[0].Type)
+		if err != nil {
+			return err
+		}
+		m.ResultType = typ
+	}
+
+	result, ok := p.structs[s.Name+m.Name+"Result"]
+	if !ok {
+		// oneway methods have no result
+		m.Oneway = true
+		return nil
+	}
+	for _, f := range result.Fields.List {
+		typ, err := p.typeString(f.Type)
+		if err != nil {
+			return err
+		}
+		for _, n := range f.Names {
+			if n.Name == "Success" {
+				m.SuccessPtr = typ == "*"+m.ResultType
+				continue
+			}
+			m.Exceptions = append(m.Exceptions, field{Name: n.Name, Type: typ})
+		}
+	}
+	return nil
+}
+
+// typeString prints a type, qualifying those declared by the stock package.
+func (p *stockPackage) typeString(e ast.Expr) (string, error) {
+	switch t := e.(type) {
+	case *ast.Ident:
+		if _, ok := p.types[t.Name]; ok {
+			return p.Name + "." + t.Name, nil
+		}
+		return t.Name, nil
+	case *ast.StarExpr:
+		s, err := p.typeString(t.X)
+		return "*" + s, err
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return "", fmt.Errorf("unsupported array type")
+		}
+		s, err := p.typeString(t.Elt)
+		return "[]" + s, err
+	case *ast.MapType:
+		k, err := p.typeString(t.Key)
+		if err != nil {
+			return "", err
+		}
+		v, err := p.typeString(t.Value)
+		return "map[" + k + "]" + v, err
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok {
+			importPath, ok := p.imports[x.Name]
+			if !ok {
+				return "", fmt.Errorf("unknown package %s", x.Name)
+			}
+			p.Imports[x.Name] = importPath
+			return x.Name + "." + t.Sel.Name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %T", e)
+}
@@ -0,0 +1,8 @@
+exception EchoError {
+  1: string message
+}
+
+service Echo {
+  string echo(1: string msg) throws (1: EchoError err)
+  oneway void note(1: string msg)
+}
@@ -0,0 +1,334 @@
+// Autogenerated by Thrift Compiler (0.10.0)
+// DO NOT EDIT UNLESS YOU ARE SURE THAT YOU KNOW WHAT YOU ARE DOING
+
+// Condensed output of a stock compiler for echo.thrift, without the client,
+// which thrift-tracker-gen does not read.
+package echo
+
+import (
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Attributes:
+//  - Message
+type EchoError struct {
+	Message string `thrift:"message,1" db:"message" json:"message"`
+}
+
+func NewEchoError() *EchoError {
+	return &EchoError{}
+}
+
+func (p *EchoError) GetMessage() string {
+	return p.Message
+}
+
+func (p *EchoError) Read(iprot thrift.TProtocol) error {
+	if _, err := iprot.ReadStructBegin(); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T read error: ", p), err)
+	}
+	for {
+		_, fieldTypeId, fieldId, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T field %d read error: ", p, fieldId), err)
+		}
+		if fieldTypeId == thrift.STOP {
+			break
+		}
+		if fieldId == 1 && fieldTypeId == thrift.STRING {
+			if p.Message, err = iprot.ReadString(); err != nil {
+				return thrift.PrependError("error reading field 1: ", err)
+			}
+		} else if err := iprot.Skip(fieldTypeId); err != nil {
+			return err
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := iprot.ReadStructEnd(); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T read struct end error: ", p), err)
+	}
+	return nil
+}
+
+func (p *EchoError) Write(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("EchoError"); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T write struct begin error: ", p), err)
+	}
+	if err := writeString(oprot, "message", 1, p.Message); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return thrift.PrependError("write field stop error: ", err)
+	}
+	return oprot.WriteStructEnd()
+}
+
+func (p *EchoError) String() string {
+	if p == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("EchoError(%+v)", *p)
+}
+
+func (p *EchoError) Error() string {
+	return p.String()
+}
+
+type Echo interface {
+	// Parameters:
+	//  - Msg
+	Echo(msg string) (r string, err error)
+	// Parameters:
+	//  - Msg
+	Note(msg string) (err error)
+}
+
+type EchoProcessor struct {
+	processorMap map[string]thrift.TProcessorFunction
+	handler      Echo
+}
+
+func NewEchoProcessor(handler Echo) *EchoProcessor {
+
+	self0 := &EchoProcessor{handler: handler, processorMap: make(map[string]thrift.TProcessorFunction)}
+	self0.processorMap["echo"] = &echoProcessorEcho{handler: handler}
+	self0.processorMap["note"] = &echoProcessorNote{handler: handler}
+	return self0
+}
+
+type echoProcessorEcho struct {
+	handler Echo
+}
+
+func (p *echoProcessorEcho) Process(seqId int32, iprot, oprot thrift.TProtocol) (success bool, err thrift.TException) {
+	args := EchoEchoArgs{}
+	if err = args.Read(iprot); err != nil {
+		iprot.ReadMessageEnd()
+		x := thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error())
+		oprot.WriteMessageBegin("echo", thrift.EXCEPTION, seqId)
+		x.Write(oprot)
+		oprot.WriteMessageEnd()
+		oprot.Flush()
+		return false, err
+	}
+	iprot.ReadMessageEnd()
+	result := EchoEchoResult{}
+	retval, err2 := p.handler.Echo(args.Msg)
+	if err2 != nil {
+		switch v := err2.(type) {
+		case *EchoError:
+			result.Err = v
+		default:
+			x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "Internal error processing echo: "+err2.Error())
+			oprot.WriteMessageBegin("echo", thrift.EXCEPTION, seqId)
+			x.Write(oprot)
+			oprot.WriteMessageEnd()
+			oprot.Flush()
+			return true, err2
+		}
+	} else {
+		result.Success = &retval
+	}
+	if err2 = oprot.WriteMessageBegin("echo", thrift.REPLY, seqId); err2 != nil {
+		err = err2
+	}
+	if err2 = result.Write(oprot); err == nil && err2 != nil {
+		err = err2
+	}
+	if err2 = oprot.WriteMessageEnd(); err == nil && err2 != nil {
+		err = err2
+	}
+	if err2 = oprot.Flush(); err == nil && err2 != nil {
+		err = err2
+	}
+	if err != nil {
+		return
+	}
+	return true, err
+}
+
+type echoProcessorNote struct {
+	handler Echo
+}
+
+func (p *echoProcessorNote) Process(seqId int32, iprot, oprot thrift.TProtocol) (success bool, err thrift.TException) {
+	args := EchoNoteArgs{}
+	if err = args.Read(iprot); err != nil {
+		iprot.ReadMessageEnd()
+		return false, err
+	}
+	iprot.ReadMessageEnd()
+	if err2 := p.handler.Note(args.Msg); err2 != nil {
+		return true, err2
+	}
+	return true, nil
+}
+
+// HELPER FUNCTIONS AND STRUCTURES
+
+// Attributes:
+//  - Msg
+type EchoEchoArgs struct {
+	Msg string `thrift:"msg,1" db:"msg" json:"msg"`
+}
+
+func (p *EchoEchoArgs) Read(iprot thrift.TProtocol) error {
+	return readMsg(iprot, &p.Msg)
+}
+
+func (p *EchoEchoArgs) Write(oprot thrift.TProtocol) error {
+	return writeMsg(oprot, "echo_args", p.Msg)
+}
+
+// Attributes:
+//  - Success
+//  - Err
+type EchoEchoResult struct {
+	Success *string    `thrift:"success,0" db:"success" json:"success,omitempty"`
+	Err     *EchoError `thrift:"err,1" db:"err" json:"err,omitempty"`
+}
+
+var EchoEchoResult_Success_DEFAULT string
+
+func (p *EchoEchoResult) GetSuccess() string {
+	if !p.IsSetSuccess() {
+		return EchoEchoResult_Success_DEFAULT
+	}
+	return *p.Success
+}
+
+func (p *EchoEchoResult) IsSetSuccess() bool {
+	return p.Success != nil
+}
+
+func (p *EchoEchoResult) Read(iprot thrift.TProtocol) error {
+	if _, err := iprot.ReadStructBegin(); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T read error: ", p), err)
+	}
+	for {
+		_, fieldTypeId, fieldId, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T field %d read error: ", p, fieldId), err)
+		}
+		if fieldTypeId == thrift.STOP {
+			break
+		}
+		switch {
+		case fieldId == 0 && fieldTypeId == thrift.STRING:
+			v, err := iprot.ReadString()
+			if err != nil {
+				return thrift.PrependError("error reading field 0: ", err)
+			}
+			p.Success = &v
+		case fieldId == 1 && fieldTypeId == thrift.STRUCT:
+			p.Err = NewEchoError()
+			if err := p.Err.Read(iprot); err != nil {
+				return thrift.PrependError(fmt.Sprintf("%T error reading struct: ", p.Err), err)
+			}
+		default:
+			if err := iprot.Skip(fieldTypeId); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := iprot.ReadStructEnd(); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T read struct end error: ", p), err)
+	}
+	return nil
+}
+
+func (p *EchoEchoResult) Write(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("echo_result"); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%T write struct begin error: ", p), err)
+	}
+	if p.Success != nil {
+		if err := writeString(oprot, "success", 0, *p.Success); err != nil {
+			return err
+		}
+	}
+	if p.Err != nil {
+		if err := oprot.WriteFieldBegin("err", thrift.STRUCT, 1); err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T write field begin error 1:err: ", p), err)
+		}
+		if err := p.Err.Write(oprot); err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T error writing struct: ", p.Err), err)
+		}
+		if err := oprot.WriteFieldEnd(); err != nil {
+			return thrift.PrependError(fmt.Sprintf("%T write field end error 1:err: ", p), err)
+		}
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return thrift.PrependError("write field stop error: ", err)
+	}
+	return oprot.WriteStructEnd()
+}
+
+// Attributes:
+//  - Msg
+type EchoNoteArgs struct {
+	Msg string `thrift:"msg,1" db:"msg" json:"msg"`
+}
+
+func (p *EchoNoteArgs) Read(iprot thrift.TProtocol) error {
+	return readMsg(iprot, &p.Msg)
+}
+
+func (p *EchoNoteArgs) Write(oprot thrift.TProtocol) error {
+	return writeMsg(oprot, "note_args", p.Msg)
+}
+
+func readMsg(iprot thrift.TProtocol, msg *string) error {
+	if _, err := iprot.ReadStructBegin(); err != nil {
+		return err
+	}
+	for {
+		_, fieldTypeId, fieldId, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if fieldTypeId == thrift.STOP {
+			break
+		}
+		if fieldId == 1 && fieldTypeId == thrift.STRING {
+			if *msg, err = iprot.ReadString(); err != nil {
+				return thrift.PrependError("error reading field 1: ", err)
+			}
+		} else if err := iprot.Skip(fieldTypeId); err != nil {
+			return err
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return iprot.ReadStructEnd()
+}
+
+func writeMsg(oprot thrift.TProtocol, name, msg string) error {
+	if err := oprot.WriteStructBegin(name); err != nil {
+		return err
+	}
+	if err := writeString(oprot, "msg", 1, msg); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return thrift.PrependError("write field stop error: ", err)
+	}
+	return oprot.WriteStructEnd()
+}
+
+func writeString(oprot thrift.TProtocol, name string, id int16, v string) error {
+	if err := oprot.WriteFieldBegin(name, thrift.STRING, id); err != nil {
+		return thrift.PrependError(fmt.Sprintf("write field begin error %d:%s: ", id, name), err)
+	}
+	if err := oprot.WriteString(v); err != nil {
+		return thrift.PrependError(fmt.Sprintf("%d:%s: ", id, name), err)
+	}
+	return oprot.WriteFieldEnd()
+}
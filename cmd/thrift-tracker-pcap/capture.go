@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// packet is a captured link layer frame.
+type packet struct {
+	ts       time.Time
+	linkType uint32
+	data     []byte
+}
+
+type captureReader interface {
+	next() (*packet, error) // io.EOF at the end
+}
+
+// newCaptureReader tells pcap from pcapng by the magic number.
+func newCaptureReader(r io.Reader) (captureReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("not a capture file: %v", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(magic) == 0x0a0d0d0a:
+		return &pcapngReader{r: br}, nil
+	case isPcapMagic(binary.LittleEndian.Uint32(magic)), isPcapMagic(binary.BigEndian.Uint32(magic)):
+		return newPcapReader(br)
+	}
+	return nil, errors.New("not a pcap or pcapng file")
+}
+
+func isPcapMagic(magic uint32) bool {
+	return magic == 0xa1b2c3d4 || magic == 0xa1b23c4d
+}
+
+// maxPacketSize bounds the allocation for a captured packet.
+const maxPacketSize = 1 << 18
+
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("pcap header: %v", err)
+	}
+	p := &pcapReader{r: r, order: binary.LittleEndian}
+	if !isPcapMagic(p.order.Uint32(hdr[0:])) {
+		p.order = binary.BigEndian
+	}
+	p.nanos = p.order.Uint32(hdr[0:]) == 0xa1b23c4d
+	p.linkType = p.order.Uint32(hdr[20:]) & 0xffff
+	return p, nil
+}
+
+func (p *pcapReader) next() (*packet, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("pcap record header truncated")
+		}
+		return nil, err
+	}
+	sec, frac := p.order.Uint32(hdr[0:]), p.order.Uint32(hdr[4:])
+	caplen := p.order.Uint32(hdr[8:])
+	if caplen > maxPacketSize {
+		return nil, fmt.Errorf("pcap record of %d bytes is too large", caplen)
+	}
+	data := make([]byte, caplen)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, errors.New("pcap record truncated")
+	}
+	if !p.nanos {
+		frac *= 1000
+	}
+	return &packet{ts: time.Unix(int64(sec), int64(frac)), linkType: p.linkType, data: data}, nil
+}
+
+type pcapngInterface struct {
+	linkType uint32
+	tsPerSec uint64
+}
+
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+const (
+	blockSectionHeader   = 0x0a0d0d0a
+	blockInterface       = 1
+	blockPacketObsolete  = 2
+	blockSimplePacket    = 3
+	blockEnhancedPacket  = 6
+	optionEnd            = 0
+	optionIfTsresol      = 9
+	pcapngByteOrderMagic = 0x1a2b3c4d
+)
+
+func (p *pcapngReader) next() (*packet, error) {
+	for {
+		typ, body, err := p.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case blockSectionHeader:
+			// interfaces are numbered per section
+			p.interfaces = p.interfaces[:0]
+		case blockInterface:
+			if len(body) < 8 {
+				return nil, errors.New("pcapng interface block truncated")
+			}
+			iface := pcapngInterface{linkType: uint32(p.order.Uint16(body[0:])), tsPerSec: 1e6}
+			p.readOptions(body[8:], func(code uint16, value []byte) {
+				if code == optionIfTsresol && len(value) >= 1 {
+					if v := value[0]; v&0x80 == 0 && v <= 19 {
+						iface.tsPerSec = uint64(math.Pow10(int(v)))
+					} else if v&0x80 != 0 && v&0x7f <= 63 {
+						iface.tsPerSec = 1 << (v & 0x7f)
+					}
+				}
+			})
+			p.interfaces = append(p.interfaces, iface)
+		case blockEnhancedPacket, blockPacketObsolete:
+			if len(body) < 20 {
+				return nil, errors.New("pcapng packet block truncated")
+			}
+			var id uint32
+			if typ == blockEnhancedPacket {
+				id = p.order.Uint32(body[0:])
+			} else {
+				id = uint32(p.order.Uint16(body[0:]))
+			}
+			if int(id) >= len(p.interfaces) {
+				return nil, fmt.Errorf("pcapng packet of unknown interface %d", id)
+			}
+			iface := p.interfaces[id]
+			ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			caplen := p.order.Uint32(body[12:])
+			if uint64(caplen) > uint64(len(body)-20) {
+				return nil, errors.New("pcapng packet block truncated")
+			}
+			return &packet{ts: tsTime(ts, iface.tsPerSec), linkType: iface.linkType, data: body[20 : 20+caplen]}, nil
+		case blockSimplePacket:
+			if len(p.interfaces) == 0 || len(body) < 4 {
+				return nil, errors.New("pcapng simple packet block without interface")
+			}
+			n := p.order.Uint32(body[0:])
+			if uint64(n) > uint64(len(body)-4) {
+				n = uint32(len(body) - 4) // snapped
+			}
+			return &packet{linkType: p.interfaces[0].linkType, data: body[4 : 4+n]}, nil
+		}
+		// other blocks, e.g. statistics, are skipped
+	}
+}
+
+func tsTime(ts, perSec uint64) time.Time {
+	frac := float64(ts%perSec) / float64(perSec)
+	return time.Unix(int64(ts/perSec), int64(frac*1e9))
+}
+
+// readBlock returns the type and the body of the next block.
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.New("pcapng block header truncated")
+		}
+		return 0, nil, err
+	}
+	typ := binary.LittleEndian.Uint32(hdr[0:]) // palindromic for section headers
+	if typ == blockSectionHeader {
+		var magic [4]byte
+		if _, err := io.ReadFull(p.r, magic[:]); err != nil {
+			return 0, nil, errors.New("pcapng section header truncated")
+		}
+		p.order = binary.LittleEndian
+		if p.order.Uint32(magic[:]) != pcapngByteOrderMagic {
+			p.order = binary.BigEndian
+			if p.order.Uint32(magic[:]) != pcapngByteOrderMagic {
+				return 0, nil, errors.New("pcapng bad byte order magic")
+			}
+		}
+		length := p.order.Uint32(hdr[4:])
+		if length < 16 || length > maxPacketSize || length%4 != 0 {
+			return 0, nil, fmt.Errorf("pcapng bad section header length %d", length)
+		}
+		rest := make([]byte, length-12)
+		if _, err := io.ReadFull(p.r, rest); err != nil {
+			return 0, nil, errors.New("pcapng section header truncated")
+		}
+		return typ, rest[:len(rest)-4], nil
+	}
+	if p.order == nil {
+		return 0, nil, errors.New("pcapng block before section header")
+	}
+	typ = p.order.Uint32(hdr[0:])
+	length := p.order.Uint32(hdr[4:])
+	if length < 12 || length > maxPacketSize || length%4 != 0 {
+		return 0, nil, fmt.Errorf("pcapng bad block length %d", length)
+	}
+	rest := make([]byte, length-8)
+	if _, err := io.ReadFull(p.r, rest); err != nil {
+		return 0, nil, errors.New("pcapng block truncated")
+	}
+	return typ, rest[:len(rest)-4], nil
+}
+
+func (p *pcapngReader) readOptions(b []byte, f func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code, n := p.order.Uint16(b[0:]), int(p.order.Uint16(b[2:]))
+		if code == optionEnd || 4+n > len(b) {
+			return
+		}
+		f(code, b[4:4+n])
+		if n = 4 + (n+3)&^3; n > len(b) {
+			return
+		}
+		b = b[n:]
+	}
+}
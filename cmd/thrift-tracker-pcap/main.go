@@ -0,0 +1,379 @@
+// Command thrift-tracker-pcap reads a pcap or pcapng capture, reassembles the
+// TCP connections in it and prints one line per thrift request: its request
+// id and seq when the connection was upgraded, method, latency from request
+// to reply and outcome, e.g.
+//
+//	$ thrift-tracker-pcap -port 9090 -idl calculator.thrift capture.pcapng
+//	TIME             CLIENT           SERVER          TRACKED  METHOD  SEQID  REQUEST_ID  SEQ  LATENCY  OUTCOME
+//	10:04:05.120413  10.0.0.7:51342   10.0.0.9:9090   yes      add     2      7a51...     1    412µs    ok
+//
+// TRACKED is yes when the upgrade call was accepted or requests carry
+// headers, rejected when the server does not support tracker and no
+// otherwise. Streams are decoded up to the first packet missing from the
+// capture.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/eleme/thrift-tracker/wire"
+)
+
+type config struct {
+	opts wire.Options
+	port int
+}
+
+func main() {
+	framed := flag.Bool("framed", false, "connections are framed rather than buffered")
+	protocol := flag.String("protocol", "binary", "binary or compact")
+	jsonOut := flag.Bool("json", false, "print JSON objects")
+	idlFile := flag.String("idl", "", ".thrift file to name exceptions with")
+	port := flag.Int("port", 0, "only connections to this server port")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: thrift-tracker-pcap [flags] file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*protocol != "binary" && *protocol != "compact") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c := &config{opts: wire.Options{Framed: *framed, Compact: *protocol == "compact"}, port: *port}
+	if *idlFile != "" {
+		idl, err := wire.LoadIDL(*idlFile)
+		if err != nil {
+			fatal(err)
+		}
+		c.opts.IDL = idl
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	conns, err := readConns(f)
+	if err != nil {
+		fatal(err)
+	}
+	var requests []*request
+	for _, conn := range conns {
+		requests = append(requests, c.analyze(conn)...)
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+	if *jsonOut {
+		err = printJSON(os.Stdout, requests)
+	} else {
+		err = printTable(os.Stdout, requests)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "thrift-tracker-pcap:", err)
+	os.Exit(1)
+}
+
+func warn(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "thrift-tracker-pcap: "+format+"\n", args...)
+}
+
+// conn is a TCP connection, in the order of its first packet.
+type conn struct {
+	streams [2]*stream
+}
+
+func readConns(r io.Reader) ([]*conn, error) {
+	capture, err := newCaptureReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var conns []*conn
+	streams := make(map[flow]*stream)
+	byFlow := make(map[flow]*conn)
+	for {
+		p, err := capture.next()
+		if err == io.EOF {
+			return conns, nil
+		}
+		if err != nil {
+			// keep what was read, captures are often cut short
+			warn("%v", err)
+			return conns, nil
+		}
+		seg, ok := parseTCP(p.linkType, p.data)
+		if !ok {
+			continue
+		}
+		s := streams[seg.flow]
+		if s == nil {
+			s = newStream(seg.flow)
+			streams[seg.flow] = s
+			if c := byFlow[seg.flow.reverse()]; c != nil {
+				c.streams[1] = s
+			} else {
+				c = &conn{streams: [2]*stream{s}}
+				byFlow[seg.flow] = c
+				conns = append(conns, c)
+			}
+		}
+		s.add(seg, p.ts)
+	}
+}
+
+type request struct {
+	Time      time.Time     `json:"time"`
+	Client    string        `json:"client"`
+	Server    string        `json:"server"`
+	Tracked   string        `json:"tracked"`
+	Method    string        `json:"method"`
+	SeqID     int32         `json:"seqid"`
+	RequestID string        `json:"request_id,omitempty"`
+	Seq       string        `json:"seq,omitempty"`
+	Latency   time.Duration `json:"latency_ns,omitempty"`
+	Outcome   string        `json:"outcome"`
+}
+
+// timedMessage is a decoded message and the capture time of its first byte.
+type timedMessage struct {
+	*wire.Message
+	ts time.Time
+}
+
+// decoder decodes one direction of a connection up to its first gap.
+type decoder struct {
+	*wire.Decoder
+	s      *stream
+	chunks []chunk
+}
+
+func (c *config) newDecoder(s *stream) *decoder {
+	data, chunks, gap := s.reassemble()
+	if gap {
+		warn("%s -> %s: packets missing after %d bytes", s.flow.src, s.flow.dst, len(data))
+	}
+	return &decoder{wire.NewDecoder(bytes.NewReader(data), c.opts), s, chunks}
+}
+
+// next returns the next message, false at the end of what can be decoded.
+func (d *decoder) next() (timedMessage, bool) {
+	m, err := d.Next()
+	if err != nil {
+		if err != io.EOF {
+			warn("%s -> %s: %v", d.s.flow.src, d.s.flow.dst, err)
+		}
+		return timedMessage{}, false
+	}
+	return timedMessage{m, timeAt(d.chunks, m.Offset)}, true
+}
+
+// decodeReplies decodes the replies of server, which trackers send without
+// a header.
+func (c *config) decodeReplies(server *stream) []timedMessage {
+	if server == nil {
+		return nil
+	}
+	d := c.newDecoder(server)
+	d.SetHeaderMode(wire.HeaderNever)
+	var messages []timedMessage
+	for {
+		m, ok := d.next()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, m)
+	}
+}
+
+// decodeCalls decodes the calls of client following the upgrade state of the
+// connection, as told by the replies sent before each call: headers precede
+// calls once the upgrade call was accepted, and never before. Until an
+// upgrade reply, a capture started mid-connection tells headers by their
+// first byte.
+func (c *config) decodeCalls(client *stream, replies []timedMessage) []timedMessage {
+	d := c.newDecoder(client)
+	if client.syn {
+		d.SetHeaderMode(wire.HeaderNever)
+	}
+	var calls []timedMessage
+	for {
+		ts := timeAt(d.chunks, d.Offset())
+		for ; len(replies) > 0 && !replies[0].ts.After(ts); replies = replies[1:] {
+			if !replies[0].Upgrade() {
+				continue
+			}
+			if replies[0].Type == thrift.REPLY {
+				d.SetHeaderMode(wire.HeaderAlways)
+			} else {
+				d.SetHeaderMode(wire.HeaderNever)
+			}
+		}
+		m, ok := d.next()
+		if !ok {
+			return calls
+		}
+		calls = append(calls, m)
+	}
+}
+
+// firstIsCall reports whether the first message of s is a call, false when
+// it can not be decoded.
+func (c *config) firstIsCall(s *stream) bool {
+	data, _, _ := s.reassemble()
+	m, err := wire.NewDecoder(bytes.NewReader(data), c.opts).Next()
+	return err == nil && isCall(m.Type)
+}
+
+func isCall(t thrift.TMessageType) bool {
+	return t == thrift.CALL || t == thrift.ONEWAY
+}
+
+func (c *config) analyze(cn *conn) []*request {
+	client, server := cn.streams[0], cn.streams[1]
+	if server != nil && server.opener {
+		client, server = server, client
+	}
+	if c.port != 0 && int(client.flow.dst.port) != c.port && int(client.flow.src.port) != c.port {
+		return nil
+	}
+	if server != nil && !client.opener && !c.firstIsCall(client) && c.firstIsCall(server) {
+		// no SYN captured, and the first stream seen carries replies
+		client, server = server, client
+	}
+	if c.port != 0 && int(client.flow.dst.port) != c.port {
+		return nil
+	}
+	replies := c.decodeReplies(server)
+	calls := c.decodeCalls(client, replies)
+	if len(calls) == 0 {
+		return nil
+	}
+	requests, tracked := match(calls, replies)
+	for _, r := range requests {
+		r.Client, r.Server, r.Tracked = client.flow.src.String(), client.flow.dst.String(), tracked
+	}
+	return requests
+}
+
+// match pairs calls with replies in the order of capture. A reply answers
+// the first call of its seqid and method still waiting, as thriftpy clients
+// send every call with seqid 0.
+func match(calls, replies []timedMessage) ([]*request, string) {
+	tracked := "no"
+	pending := make(map[int32][]*request)
+	var requests []*request
+	for len(calls) > 0 || len(replies) > 0 {
+		// a call and a reply captured at once, the call was sent first
+		if len(replies) == 0 || (len(calls) > 0 && !calls[0].ts.After(replies[0].ts)) {
+			m := calls[0]
+			calls = calls[1:]
+			if m.Upgrade() {
+				continue
+			}
+			r := &request{
+				Time:    m.ts,
+				Method:  m.Name,
+				SeqID:   m.SeqID,
+				Outcome: "no reply",
+			}
+			if m.Header != nil {
+				// the upgrade call may predate the capture
+				tracked = "yes"
+				r.RequestID, r.Seq = m.Header.RequestID, m.Header.Seq
+			}
+			if m.Type == thrift.ONEWAY {
+				r.Outcome = "oneway"
+			} else {
+				pending[m.SeqID] = append(pending[m.SeqID], r)
+			}
+			requests = append(requests, r)
+			continue
+		}
+		m := replies[0]
+		replies = replies[1:]
+		if m.Upgrade() {
+			if m.Type == thrift.REPLY {
+				tracked = "yes"
+			} else {
+				tracked = "rejected"
+			}
+			continue
+		}
+		waiting := pending[m.SeqID]
+		for i, r := range waiting {
+			if r.Method != m.Name {
+				continue
+			}
+			pending[m.SeqID] = append(waiting[:i:i], waiting[i+1:]...)
+			r.Latency = m.ts.Sub(r.Time)
+			r.Outcome = outcome(m.Message)
+			break
+		}
+	}
+	return requests, tracked
+}
+
+// outcome describes a reply: ok, the exception declared by the result, or
+// the type of a TApplicationException.
+func outcome(m *wire.Message) string {
+	if m.Type == thrift.EXCEPTION {
+		if t := m.Body.Field(2); t != nil {
+			return "error " + wire.Format(t)
+		}
+		return "error"
+	}
+	for _, f := range m.Body.Fields {
+		if f.ID == 0 {
+			return "ok"
+		}
+		if f.Name != "" {
+			return "exception " + f.Name
+		}
+		return "exception " + strconv.Itoa(int(f.ID))
+	}
+	return "ok" // void
+}
+
+func printTable(w io.Writer, requests []*request) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tCLIENT\tSERVER\tTRACKED\tMETHOD\tSEQID\tREQUEST_ID\tSEQ\tLATENCY\tOUTCOME")
+	for _, r := range requests {
+		latency := "-"
+		if r.Latency > 0 {
+			latency = r.Latency.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			r.Time.Format("15:04:05.000000"), r.Client, r.Server, r.Tracked, r.Method, r.SeqID,
+			orDash(r.RequestID), orDash(r.Seq), latency, r.Outcome)
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printJSON(w io.Writer, requests []*request) error {
+	enc := json.NewEncoder(w)
+	for _, r := range requests {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
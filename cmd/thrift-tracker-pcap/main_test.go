@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/tracking"
+	"github.com/eleme/thrift-tracker/wire"
+)
+
+// testPacket is a message sent at capture time at(i) of its index.
+type testPacket struct {
+	fromClient bool
+	header     *tracking.RequestHeader
+	name       string
+	typ        thrift.TMessageType
+	seqID      int32
+	body       interface{ Write(thrift.TProtocol) error }
+}
+
+func call(name string, seqID int32, body interface{ Write(thrift.TProtocol) error }) testPacket {
+	return testPacket{true, nil, name, thrift.CALL, seqID, body}
+}
+
+func trackedCall(requestID, name string, seqID int32, body interface{ Write(thrift.TProtocol) error }) testPacket {
+	return testPacket{true, &tracking.RequestHeader{RequestID: requestID, Seq: "1"}, name, thrift.CALL, seqID, body}
+}
+
+func reply(name string, seqID int32, body interface{ Write(thrift.TProtocol) error }) testPacket {
+	return testPacket{false, nil, name, thrift.REPLY, seqID, body}
+}
+
+func addArgs(num1, num2 int32) *calculator.CalculatorServiceAddArgs {
+	return &calculator.CalculatorServiceAddArgs{Num1: num1, Num2: num2}
+}
+
+func addResult(sum int32) *calculator.CalculatorServiceAddResult {
+	return &calculator.CalculatorServiceAddResult{Success: &sum}
+}
+
+var (
+	upgradeCall   = call(tracker.TrackingAPIName, 1, &tracking.UpgradeArgs_{AppID: "client"})
+	upgradeReply  = reply(tracker.TrackingAPIName, 1, &tracking.UpgradeReply{})
+	upgradeRefuse = testPacket{false, nil, tracker.TrackingAPIName, thrift.EXCEPTION, 1,
+		thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function")}
+)
+
+// newConn captures packets, one segment each, with the SYNs of both sides
+// when syn is set. strict is false for messages of old binary protocols.
+func newConn(t *testing.T, syn, strict bool, packets ...testPacket) *conn {
+	client, server := newStream(testFlow), newStream(testFlow.reverse())
+	seqs := map[*stream]uint32{client: 1000, server: 7000}
+	if syn {
+		client.add(&segment{flow: client.flow, seq: 1000, flags: tcpSYN}, t0.Add(-time.Second))
+		server.add(&segment{flow: server.flow, seq: 7000, flags: tcpSYN | tcpACK}, t0.Add(-time.Second))
+	}
+	for i, p := range packets {
+		buf := thrift.NewTMemoryBuffer()
+		prot := thrift.NewTBinaryProtocol(buf, false, strict)
+		if p.header != nil {
+			p.header.Write(prot)
+		}
+		prot.WriteMessageBegin(p.name, p.typ, p.seqID)
+		if err := p.body.Write(prot); err != nil {
+			t.Fatal(err)
+		}
+		prot.WriteMessageEnd()
+		s := server
+		if p.fromClient {
+			s = client
+		}
+		s.add(&segment{flow: s.flow, seq: seqs[s] + 1, flags: tcpACK, payload: buf.Bytes()}, at(i))
+		seqs[s] += uint32(buf.Len())
+	}
+	if !syn && len(server.segments) == 0 {
+		return &conn{streams: [2]*stream{client}}
+	}
+	return &conn{streams: [2]*stream{client, server}}
+}
+
+type wantRequest struct {
+	method    string
+	requestID string
+	latency   time.Duration
+	outcome   string
+}
+
+func checkRequests(t *testing.T, got []*request, tracked string, want ...wantRequest) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		w := want[i]
+		if r.Method != w.method || r.RequestID != w.requestID || r.Latency != w.latency || r.Outcome != w.outcome || r.Tracked != tracked {
+			t.Errorf("request %d: got %+v, want %+v tracked %s", i, r, w, tracked)
+		}
+	}
+}
+
+func TestAnalyzeSeqIDZero(t *testing.T) {
+	// thriftpy sends every call with seqid 0
+	requests := (&config{}).analyze(newConn(t, true, true,
+		call("add", 0, addArgs(1, 1)),
+		reply("add", 0, addResult(2)),
+		call("add", 0, addArgs(2, 2)),
+		call("ping", 0, &calculator.CalculatorServicePingArgs{}),
+		reply("add", 0, addResult(4)),
+		reply("ping", 0, &calculator.CalculatorServicePingResult{
+			UserException: &calculator.CalculatorUserException{ErrorName: "busy"},
+		}),
+	))
+	checkRequests(t, requests, "no",
+		wantRequest{"add", "", time.Millisecond, "ok"},
+		wantRequest{"add", "", 2 * time.Millisecond, "ok"},
+		wantRequest{"ping", "", 2 * time.Millisecond, "exception 1"},
+	)
+}
+
+func TestAnalyzePipelined(t *testing.T) {
+	requests := (&config{}).analyze(newConn(t, true, true,
+		call("add", 0, addArgs(1, 1)),
+		call("add", 0, addArgs(2, 2)),
+		reply("add", 0, addResult(2)),
+		reply("add", 0, addResult(4)),
+		call("add", 0, addArgs(3, 3)),
+	))
+	checkRequests(t, requests, "no",
+		wantRequest{"add", "", 2 * time.Millisecond, "ok"},
+		wantRequest{"add", "", 2 * time.Millisecond, "ok"},
+		wantRequest{"add", "", 0, "no reply"},
+	)
+}
+
+func TestAnalyzeUpgrade(t *testing.T) {
+	requests := (&config{}).analyze(newConn(t, true, true,
+		upgradeCall,
+		upgradeReply,
+		trackedCall("req-1", "add", 2, addArgs(1, 2)),
+		reply("add", 2, addResult(3)),
+		testPacket{true, &tracking.RequestHeader{RequestID: "req-2"}, "log", thrift.ONEWAY, 3,
+			&calculator.CalculatorServiceLogArgs{Message: "hi"}},
+	))
+	checkRequests(t, requests, "yes",
+		wantRequest{"add", "req-1", time.Millisecond, "ok"},
+		wantRequest{"log", "req-2", 0, "oneway"},
+	)
+}
+
+func TestAnalyzeUpgradeRefused(t *testing.T) {
+	// non-strict messages start as a header would, the upgrade state tells
+	// them apart
+	requests := (&config{}).analyze(newConn(t, true, false,
+		upgradeCall,
+		upgradeRefuse,
+		call("add", 2, addArgs(1, 2)),
+		reply("add", 2, addResult(3)),
+	))
+	checkRequests(t, requests, "rejected",
+		wantRequest{"add", "", time.Millisecond, "ok"},
+	)
+}
+
+func TestAnalyzeMidConnection(t *testing.T) {
+	// no SYN nor upgrade captured, headers are told by their first byte
+	requests := (&config{}).analyze(newConn(t, false, true,
+		reply("add", 1, addResult(0)),
+		trackedCall("req-1", "add", 2, addArgs(1, 2)),
+		reply("add", 2, addResult(3)),
+	))
+	checkRequests(t, requests, "yes",
+		wantRequest{"add", "req-1", time.Millisecond, "ok"},
+	)
+}
+
+func TestMatchWrongMethod(t *testing.T) {
+	calls := []timedMessage{{&wire.Message{Name: "add", Type: thrift.CALL}, at(0)}}
+	replies := []timedMessage{{&wire.Message{Name: "ping", Type: thrift.REPLY, Body: &wire.Struct{}}, at(1)}}
+	requests, tracked := match(calls, replies)
+	if len(requests) != 1 || requests[0].Outcome != "no reply" || tracked != "no" {
+		t.Fatalf("got %+v, tracked %s", requests[0], tracked)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// Link types of pcap and pcapng, see https://www.tcpdump.org/linktypes.html
+const (
+	linkNull      = 0
+	linkEthernet  = 1
+	linkRaw       = 101
+	linkLoop      = 108
+	linkLinuxSLL  = 113
+	linkLinuxSLL2 = 276
+	linkRawAlt    = 12 // LINKTYPE_RAW on OpenBSD
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// endpoint is an IP address and port, usable as a map key.
+type endpoint struct {
+	ip   [16]byte
+	port uint16
+}
+
+func (e endpoint) String() string {
+	ip := net.IP(e.ip[:])
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(e.port)))
+}
+
+// flow is one direction of a TCP connection.
+type flow struct {
+	src, dst endpoint
+}
+
+func (f flow) reverse() flow {
+	return flow{f.dst, f.src}
+}
+
+type segment struct {
+	flow    flow
+	seq     uint32
+	flags   uint8
+	payload []byte
+}
+
+// parseTCP returns the TCP segment of a packet, or false for anything else,
+// including IP fragments.
+func parseTCP(linkType uint32, b []byte) (*segment, bool) {
+	var etherType uint16
+	switch linkType {
+	case linkEthernet:
+		if len(b) < 14 {
+			return nil, false
+		}
+		etherType, b = binary.BigEndian.Uint16(b[12:]), b[14:]
+		for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(b) >= 4 {
+			etherType, b = binary.BigEndian.Uint16(b[2:]), b[4:]
+		}
+	case linkNull, linkLoop:
+		if len(b) < 4 {
+			return nil, false
+		}
+		// the address family, in host order for DLT_NULL
+		family := binary.LittleEndian.Uint32(b)
+		if linkType == linkLoop || family > 0xffff {
+			family = binary.BigEndian.Uint32(b)
+		}
+		switch family {
+		case 2:
+			etherType = etherTypeIPv4
+		case 10, 24, 28, 30:
+			etherType = etherTypeIPv6
+		}
+		b = b[4:]
+	case linkRaw, linkRawAlt:
+		if len(b) < 1 {
+			return nil, false
+		}
+		switch b[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	case linkLinuxSLL:
+		if len(b) < 16 {
+			return nil, false
+		}
+		etherType, b = binary.BigEndian.Uint16(b[14:]), b[16:]
+	case linkLinuxSLL2:
+		if len(b) < 20 {
+			return nil, false
+		}
+		etherType, b = binary.BigEndian.Uint16(b[0:]), b[20:]
+	default:
+		return nil, false
+	}
+
+	s := &segment{}
+	switch etherType {
+	case etherTypeIPv4:
+		if len(b) < 20 || b[0]>>4 != 4 {
+			return nil, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:]))
+		if ihl < 20 || total < ihl || total > len(b) {
+			return nil, false
+		}
+		if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 || b[9] != 6 { // fragment, or not TCP
+			return nil, false
+		}
+		copy(s.flow.src.ip[:], net.IP(b[12:16]).To16())
+		copy(s.flow.dst.ip[:], net.IP(b[16:20]).To16())
+		b = b[ihl:total] // without link layer padding
+	case etherTypeIPv6:
+		if len(b) < 40 || b[0]>>4 != 6 {
+			return nil, false
+		}
+		payload := int(binary.BigEndian.Uint16(b[4:]))
+		next := b[6]
+		copy(s.flow.src.ip[:], b[8:24])
+		copy(s.flow.dst.ip[:], b[24:40])
+		if 40+payload > len(b) {
+			return nil, false
+		}
+		b = b[40 : 40+payload]
+		// skip hop-by-hop, routing and destination options headers
+		for next == 0 || next == 43 || next == 60 {
+			if len(b) < 8 {
+				return nil, false
+			}
+			n := (int(b[1]) + 1) * 8
+			if n > len(b) {
+				return nil, false
+			}
+			next, b = b[0], b[n:]
+		}
+		if next != 6 {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	if len(b) < 20 {
+		return nil, false
+	}
+	offset := int(b[12]>>4) * 4
+	if offset < 20 || offset > len(b) {
+		return nil, false
+	}
+	s.flow.src.port = binary.BigEndian.Uint16(b[0:])
+	s.flow.dst.port = binary.BigEndian.Uint16(b[2:])
+	s.seq = binary.BigEndian.Uint32(b[4:])
+	s.flags = b[13]
+	s.payload = b[offset:]
+	return s, true
+}
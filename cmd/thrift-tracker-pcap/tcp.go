@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// stream reassembles one direction of a TCP connection.
+type stream struct {
+	flow     flow
+	syn      bool   // the SYN was captured, isn is known
+	opener   bool   // sent a SYN without ACK, the client
+	isn      uint32 // or the first sequence number seen
+	segments []timedSegment
+}
+
+type timedSegment struct {
+	offset  int64 // relative to isn+1
+	ts      time.Time
+	payload []byte
+}
+
+// chunk maps stream offsets from offset on to the capture time of their
+// segment.
+type chunk struct {
+	offset int64
+	ts     time.Time
+}
+
+func newStream(f flow) *stream {
+	return &stream{flow: f}
+}
+
+func (s *stream) add(seg *segment, ts time.Time) {
+	if seg.flags&tcpSYN != 0 {
+		s.opener = seg.flags&tcpACK == 0
+		if !s.syn {
+			if len(s.segments) > 0 {
+				// segments seen before a retransmitted SYN, rebase them
+				delta := int64(int32(s.isn - seg.seq))
+				for i := range s.segments {
+					s.segments[i].offset += delta
+				}
+			}
+			s.syn, s.isn = true, seg.seq
+		}
+		return
+	}
+	if len(seg.payload) == 0 {
+		return
+	}
+	if !s.syn && len(s.segments) == 0 {
+		s.isn = seg.seq - 1
+	}
+	s.segments = append(s.segments, timedSegment{
+		offset:  int64(int32(seg.seq-s.isn)) - 1, // wraps around with the sequence number
+		ts:      ts,
+		payload: seg.payload,
+	})
+}
+
+// reassemble returns the bytes of the stream up to its first gap, and the
+// capture times of them. Retransmitted bytes are kept once, as first
+// captured.
+func (s *stream) reassemble() (data []byte, chunks []chunk, gap bool) {
+	segments := make([]timedSegment, len(s.segments))
+	copy(segments, s.segments)
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].offset < segments[j].offset })
+	var pos int64
+	if !s.syn && len(segments) > 0 {
+		pos = segments[0].offset // capture started mid-connection
+	}
+	start := pos
+	for _, seg := range segments {
+		end := seg.offset + int64(len(seg.payload))
+		if end <= pos {
+			continue
+		}
+		if seg.offset > pos {
+			return data, chunks, true
+		}
+		chunks = append(chunks, chunk{offset: pos - start, ts: seg.ts})
+		data = append(data, seg.payload[pos-seg.offset:]...)
+		pos = end
+	}
+	return data, chunks, false
+}
+
+// timeAt returns the capture time of the byte at offset.
+func timeAt(chunks []chunk, offset int64) time.Time {
+	i := sort.Search(len(chunks), func(i int) bool { return chunks[i].offset > offset })
+	if i == 0 {
+		return time.Time{}
+	}
+	return chunks[i-1].ts
+}
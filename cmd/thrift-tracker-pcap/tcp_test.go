@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newEndpoint(ip string, port uint16) endpoint {
+	e := endpoint{port: port}
+	copy(e.ip[:], net.ParseIP(ip).To16())
+	return e
+}
+
+var testFlow = flow{newEndpoint("10.0.0.7", 51342), newEndpoint("10.0.0.9", 9090)}
+
+var t0 = time.Date(2017, 3, 1, 10, 4, 5, 0, time.UTC)
+
+// at returns the capture time of packet i.
+func at(i int) time.Time {
+	return t0.Add(time.Duration(i) * time.Millisecond)
+}
+
+type testSegment struct {
+	seq     uint32
+	flags   uint8
+	payload string
+}
+
+func TestStreamReassemble(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		segments []testSegment
+		data     string
+		gap      bool
+		times    []chunk // in ms after t0
+	}{
+		{
+			name:     "in order",
+			segments: []testSegment{{1000, tcpSYN, ""}, {1001, tcpACK, "abc"}, {1004, tcpACK, "def"}},
+			data:     "abcdef",
+			times:    []chunk{{0, at(1)}, {3, at(2)}},
+		},
+		{
+			name:     "out of order",
+			segments: []testSegment{{1000, tcpSYN, ""}, {1004, tcpACK, "def"}, {1001, tcpACK, "abc"}},
+			data:     "abcdef",
+			times:    []chunk{{0, at(2)}, {3, at(1)}},
+		},
+		{
+			name: "retransmission",
+			segments: []testSegment{
+				{1000, tcpSYN, ""}, {1001, tcpACK, "abc"}, {1001, tcpACK, "abc"}, {1002, tcpACK, "bcde"}, {1006, tcpACK, "f"},
+			},
+			data:  "abcdef",
+			times: []chunk{{0, at(1)}, {3, at(3)}, {5, at(4)}},
+		},
+		{
+			name:     "gap",
+			segments: []testSegment{{1000, tcpSYN, ""}, {1001, tcpACK, "abc"}, {1007, tcpACK, "ghi"}},
+			data:     "abc",
+			gap:      true,
+			times:    []chunk{{0, at(1)}},
+		},
+		{
+			name:     "mid-stream start",
+			segments: []testSegment{{5000, tcpACK, "xyz"}, {5003, tcpACK, "w"}},
+			data:     "xyzw",
+			times:    []chunk{{0, at(0)}, {3, at(1)}},
+		},
+		{
+			name:     "mid-stream start out of order",
+			segments: []testSegment{{5003, tcpACK, "w"}, {5000, tcpACK, "xyz"}},
+			data:     "xyzw",
+			times:    []chunk{{0, at(1)}, {3, at(0)}},
+		},
+		{
+			name:     "retransmitted SYN",
+			segments: []testSegment{{1001, tcpACK, "abc"}, {1000, tcpSYN, ""}, {1004, tcpACK, "d"}},
+			data:     "abcd",
+			times:    []chunk{{0, at(0)}, {3, at(2)}},
+		},
+		{
+			name:     "sequence wrap",
+			segments: []testSegment{{0xfffffffe, tcpSYN, ""}, {0xffffffff, tcpACK, "ab"}, {1, tcpACK, "cd"}},
+			data:     "abcd",
+			times:    []chunk{{0, at(1)}, {2, at(2)}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s := newStream(testFlow)
+			for i, seg := range c.segments {
+				s.add(&segment{flow: testFlow, seq: seg.seq, flags: seg.flags, payload: []byte(seg.payload)}, at(i))
+			}
+			data, chunks, gap := s.reassemble()
+			if string(data) != c.data || gap != c.gap {
+				t.Fatalf("got %q, gap %v, want %q, gap %v", data, gap, c.data, c.gap)
+			}
+			if len(chunks) != len(c.times) {
+				t.Fatalf("got chunks %v, want %v", chunks, c.times)
+			}
+			for i := range chunks {
+				if chunks[i].offset != c.times[i].offset || !chunks[i].ts.Equal(c.times[i].ts) {
+					t.Fatalf("got chunks %v, want %v", chunks, c.times)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamOpener(t *testing.T) {
+	client, server := newStream(testFlow), newStream(testFlow.reverse())
+	client.add(&segment{flow: testFlow, seq: 1000, flags: tcpSYN}, at(0))
+	server.add(&segment{flow: testFlow.reverse(), seq: 7000, flags: tcpSYN | tcpACK}, at(1))
+	if !client.opener || server.opener || !client.syn || !server.syn {
+		t.Fatalf("got client %+v, server %+v", client, server)
+	}
+}
+
+func TestTimeAt(t *testing.T) {
+	chunks := []chunk{{0, at(0)}, {3, at(1)}, {10, at(2)}}
+	for _, c := range []struct {
+		offset int64
+		want   time.Time
+	}{
+		{0, at(0)}, {2, at(0)}, {3, at(1)}, {9, at(1)}, {10, at(2)}, {100, at(2)}, {-1, time.Time{}},
+	} {
+		if got := timeAt(chunks, c.offset); !got.Equal(c.want) {
+			t.Errorf("offset %d: got %v, want %v", c.offset, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Command thrift-tracker-proxy lets clients without tracker support show up in
+// traces. It accepts plain thrift connections and forwards them upstream over
+// tracked ones: it negotiates tracking on behalf of each client, writes a
+// request header with a new request id before every forwarded call and logs
+// every reply with its request id and latency, e.g.
+//
+//	$ thrift-tracker-proxy -listen :9090 -upstream 10.0.0.9:9090 -name legacy-billing
+//	2017/06/01 10:04:05 10.0.0.7:51342 add seqid=2 request_id=7a51... reply 412µs
+//
+// Clients which try to upgrade themselves are answered as by a server
+// without tracker support.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func main() {
+	listen := flag.String("listen", ":9090", "address to accept plain clients on")
+	upstream := flag.String("upstream", "", "address of the tracked server")
+	name := flag.String("name", "thrift-tracker-proxy", "app_id sent in the upgrade call")
+	framed := flag.Bool("framed", false, "use the framed transport rather than the buffered one")
+	protocol := flag.String("protocol", "binary", "binary or compact")
+	var meta metaFlag
+	flag.Var(&meta, "meta", "key=value added to the meta of every request header, repeatable")
+	flag.Parse()
+	if *upstream == "" || (*protocol != "binary" && *protocol != "compact") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	p := &proxy{
+		upstream:         *upstream,
+		name:             *name,
+		meta:             meta,
+		protocolFactory:  thrift.TProtocolFactory(thrift.NewTBinaryProtocolFactoryDefault()),
+		transportFactory: thrift.NewTBufferedTransportFactory(8192),
+	}
+	if *protocol == "compact" {
+		p.protocolFactory = thrift.NewTCompactProtocolFactory()
+	}
+	if *framed {
+		p.transportFactory = thrift.NewTFramedTransportFactory(thrift.NewTTransportFactory())
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("forwarding %s to %s", ln.Addr(), *upstream)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go p.serve(conn)
+	}
+}
+
+type metaFlag map[string]string
+
+func (m *metaFlag) String() string {
+	return fmt.Sprint(map[string]string(*m))
+}
+
+func (m *metaFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	if *m == nil {
+		*m = make(metaFlag)
+	}
+	(*m)[s[:i]] = s[i+1:]
+	return nil
+}
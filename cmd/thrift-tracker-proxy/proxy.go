@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/wire"
+	"github.com/google/uuid"
+)
+
+type proxy struct {
+	upstream         string
+	name             string
+	meta             map[string]string
+	protocolFactory  thrift.TProtocolFactory
+	transportFactory thrift.TTransportFactory
+}
+
+// session forwards one client connection.
+type session struct {
+	*proxy
+	client    string
+	conns     [2]*errConn // of the client, of upstream
+	closeOnce sync.Once
+	tracker   tracker.Tracker
+
+	downIn, downOut thrift.TProtocol // of the client
+	upIn, upOut     thrift.TProtocol
+
+	downMu  sync.Mutex // writes to the client
+	mu      sync.Mutex
+	pending map[int32]*pendingCall
+}
+
+type pendingCall struct {
+	method    string
+	requestID string
+	start     time.Time
+}
+
+func (p *proxy) serve(conn net.Conn) {
+	client := conn.RemoteAddr().String()
+	upConn, err := net.DialTimeout("tcp", p.upstream, 10*time.Second)
+	if err != nil {
+		log.Printf("%s: %v", client, err)
+		conn.Close()
+		return
+	}
+	s := &session{
+		proxy:   p,
+		client:  client,
+		conns:   [2]*errConn{{Conn: conn}, {Conn: upConn}},
+		tracker: tracker.NewSimpleTracker(p.name),
+		pending: make(map[int32]*pendingCall),
+	}
+	defer s.close()
+	down := p.transportFactory.GetTransport(thrift.NewTSocketFromConnTimeout(s.conns[0], 0))
+	up := p.transportFactory.GetTransport(thrift.NewTSocketFromConnTimeout(s.conns[1], 0))
+	s.downIn, s.downOut = p.protocolFactory.GetProtocol(down), p.protocolFactory.GetProtocol(down)
+	s.upIn, s.upOut = p.protocolFactory.GetProtocol(up), p.protocolFactory.GetProtocol(up)
+
+	// client seqids start at 1, 0 is free for the upgrade call
+	if err := s.tracker.Negotiation(0, s.upIn, s.upOut); err != nil {
+		log.Printf("%s: negotiation with %s: %v", client, p.upstream, err)
+		return
+	}
+	if !s.tracker.RequestHeaderSupported() {
+		log.Printf("%s: %s does not support tracker, forwarding without headers", client, p.upstream)
+	}
+	go s.forwardReplies()
+	s.forwardCalls()
+}
+
+// close closes both connections, which stops both directions.
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		s.conns[0].Close()
+		s.conns[1].Close()
+	})
+}
+
+func (s *session) forwardCalls() {
+	for {
+		name, typ, seqID, err := s.downIn.ReadMessageBegin()
+		if err != nil {
+			s.logError("client", err)
+			return
+		}
+		if name == tracker.TrackingAPIName {
+			if err := s.rejectUpgrade(seqID); err != nil {
+				s.logError("client", err)
+				return
+			}
+			continue
+		}
+		call := &pendingCall{method: name, requestID: uuid.New().String(), start: time.Now()}
+		if typ == thrift.CALL {
+			s.mu.Lock()
+			s.pending[seqID] = call
+			s.mu.Unlock()
+		}
+		ctx := tracker.WithTracking(context.Background(), &tracker.Tracking{
+			RequestID: call.requestID,
+			Seq:       "1",
+			Meta:      s.meta,
+		})
+		if err := s.forwardCall(ctx, name, typ, seqID); err != nil {
+			s.logError("upstream", err)
+			return
+		}
+		if typ == thrift.ONEWAY {
+			log.Printf("%s %s seqid=%d request_id=%s oneway", s.client, name, seqID, call.requestID)
+		}
+	}
+}
+
+func (s *session) forwardCall(ctx context.Context, name string, typ thrift.TMessageType, seqID int32) error {
+	if err := s.tracker.TryWriteRequestHeader(ctx, s.upOut); err != nil {
+		return err
+	}
+	if err := s.upOut.WriteMessageBegin(name, typ, seqID); err != nil {
+		return err
+	}
+	if err := wire.Copy(s.downIn, s.upOut, thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := s.downIn.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if err := s.upOut.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return s.upOut.Flush()
+}
+
+// rejectUpgrade answers an upgrade call of the client as a server without
+// tracker support, so that it does not write headers of its own.
+func (s *session) rejectUpgrade(seqID int32) error {
+	if err := s.downIn.Skip(thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := s.downIn.ReadMessageEnd(); err != nil {
+		return err
+	}
+	x := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+tracker.TrackingAPIName)
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+	if err := s.downOut.WriteMessageBegin(tracker.TrackingAPIName, thrift.EXCEPTION, seqID); err != nil {
+		return err
+	}
+	if err := x.Write(s.downOut); err != nil {
+		return err
+	}
+	if err := s.downOut.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return s.downOut.Flush()
+}
+
+func (s *session) forwardReplies() {
+	defer s.close()
+	for {
+		name, typ, seqID, err := s.upIn.ReadMessageBegin()
+		if err != nil {
+			s.logError("upstream", err)
+			return
+		}
+		if err := s.forwardReply(name, typ, seqID); err != nil {
+			s.logError("client", err)
+			return
+		}
+		s.mu.Lock()
+		call := s.pending[seqID]
+		delete(s.pending, seqID)
+		s.mu.Unlock()
+		if call == nil || call.method != name {
+			log.Printf("%s %s seqid=%d %s without call", s.client, name, seqID, wire.TypeName(typ))
+			continue
+		}
+		log.Printf("%s %s seqid=%d request_id=%s %s %v", s.client, name, seqID, call.requestID,
+			wire.TypeName(typ), time.Since(call.start))
+	}
+}
+
+func (s *session) forwardReply(name string, typ thrift.TMessageType, seqID int32) error {
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+	if err := s.downOut.WriteMessageBegin(name, typ, seqID); err != nil {
+		return err
+	}
+	if err := wire.Copy(s.upIn, s.downOut, thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := s.upIn.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if err := s.downOut.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return s.downOut.Flush()
+}
+
+// logError logs errors other than either side closing the connection.
+func (s *session) logError(side string, err error) {
+	if s.isClosed(err) {
+		return
+	}
+	log.Printf("%s: %s: %v", s.client, side, err)
+}
+
+// isClosed reports whether err comes from a side closing the connection, or
+// from close. Protocols keep only the message of transport errors, the
+// connections keep the errors themselves.
+func (s *session) isClosed(err error) bool {
+	if x, ok := err.(thrift.TTransportException); ok && x.Err() != nil {
+		err = x.Err()
+	}
+	return isClosed(err) || isClosed(s.conns[0].Err()) || isClosed(s.conns[1].Err())
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
+// errConn keeps the first error of a connection.
+type errConn struct {
+	net.Conn
+	mu  sync.Mutex
+	err error
+}
+
+func (c *errConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.keep(err)
+	return n, err
+}
+
+func (c *errConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.keep(err)
+	return n, err
+}
+
+func (c *errConn) keep(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+func (c *errConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+// handler keeps the contexts of calls.
+type handler struct {
+	mu   sync.Mutex
+	ctxs []context.Context
+	logs chan string
+}
+
+func (h *handler) keep(ctx context.Context) {
+	h.mu.Lock()
+	h.ctxs = append(h.ctxs, ctx)
+	h.mu.Unlock()
+}
+
+func (h *handler) Ping(ctx context.Context) (bool, error) {
+	h.keep(ctx)
+	return true, nil
+}
+
+func (h *handler) Add(ctx context.Context, num1, num2 int32) (int32, error) {
+	h.keep(ctx)
+	return num1 + num2, nil
+}
+
+func (h *handler) Log(ctx context.Context, message string) error {
+	h.keep(ctx)
+	h.logs <- message
+	return nil
+}
+
+func listen(t *testing.T, serve func(net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// upstream serves the calculator with a tracker of newTracker for every
+// connection.
+func upstream(t *testing.T, newTracker func() tracker.Tracker, h *handler) string {
+	return listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		trans := thrift.NewTSocketFromConnTimeout(conn, 0)
+		prot := thrift.NewTBinaryProtocolTransport(trans)
+		processor := calculator.NewCalculatorServiceProcessor(newTracker(), h)
+		for {
+			ok, err := processor.Process(prot, prot)
+			// as thrift.TSimpleServer, unknown methods are answered only
+			if x, isApp := err.(thrift.TApplicationException); isApp && x.TypeId() == thrift.UNKNOWN_METHOD {
+				continue
+			}
+			if !ok || err != nil {
+				return
+			}
+		}
+	})
+}
+
+func startProxy(t *testing.T, upstream string) string {
+	p := &proxy{
+		upstream:         upstream,
+		name:             "legacy-billing",
+		meta:             map[string]string{"via": "proxy"},
+		protocolFactory:  thrift.NewTBinaryProtocolFactoryDefault(),
+		transportFactory: thrift.NewTTransportFactory(),
+	}
+	return listen(t, p.serve)
+}
+
+func dial(t *testing.T, addr string, client tracker.Tracker) *calculator.CalculatorServiceClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c, err := calculator.NewCalculatorServiceClientFactory(client, thrift.NewTSocketFromConnTimeout(conn, 0),
+		thrift.NewTBinaryProtocolFactoryDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestProxy(t *testing.T) {
+	var mu sync.Mutex
+	var servers []*trackertest.RecordingTracker
+	h := &handler{logs: make(chan string, 1)}
+	addr := startProxy(t, upstream(t, func() tracker.Tracker {
+		server := trackertest.NewRecordingTracker("calculator")
+		mu.Lock()
+		servers = append(servers, server)
+		mu.Unlock()
+		return server
+	}, h))
+
+	for _, c := range []struct {
+		name   string
+		client tracker.Tracker
+	}{
+		{"plain client", trackertest.NewNoopTracker("legacy")},
+		// its upgrade call is refused by the proxy
+		{"tracked client", tracker.NewSimpleTracker("legacy")},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			client := dial(t, addr, c.client)
+			if c.client.RequestHeaderSupported() {
+				t.Fatal("the proxy accepted the upgrade of its client")
+			}
+			if sum, err := client.Add(context.Background(), 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want 3", sum, err)
+			}
+			if err := client.Log(context.Background(), "hello"); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-h.logs; got != "hello" {
+				t.Fatalf("logged %q", got)
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var reads []*tracking.RequestHeader
+	for _, server := range servers {
+		reads = append(reads, server.Reads()...)
+	}
+	if len(reads) != 4 {
+		t.Fatalf("server read %d headers, want 4", len(reads))
+	}
+	ids := make(map[string]bool)
+	for _, hd := range reads {
+		if hd.RequestID == "" || ids[hd.RequestID] || hd.Meta["via"] != "proxy" {
+			t.Fatalf("got headers %v, want unique request ids and the meta of the proxy", reads)
+		}
+		ids[hd.RequestID] = true
+	}
+}
+
+func TestProxyUntrackedUpstream(t *testing.T) {
+	h := &handler{logs: make(chan string, 1)}
+	addr := startProxy(t, upstream(t, func() tracker.Tracker {
+		return trackertest.NewNoopTracker("calculator")
+	}, h))
+	client := dial(t, addr, trackertest.NewNoopTracker("legacy"))
+	if sum, err := client.Add(context.Background(), 1, 2); err != nil || sum != 3 {
+		t.Fatalf("got (%d, %v), want 3", sum, err)
+	}
+	if _, ok := h.ctxs[0].Value(tracker.CtxKeyRequestID).(string); ok {
+		t.Fatal("request id reached an untracked server")
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	s := &session{conns: [2]*errConn{{}, {}}}
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{io.EOF, true},
+		{thrift.NewTTransportExceptionFromError(io.EOF), true},
+		{fmt.Errorf("read: %w", net.ErrClosed), true},
+		{thrift.NewTTransportExceptionFromError(&net.OpError{Op: "read", Err: net.ErrClosed}), true},
+		{thrift.NewTProtocolExceptionWithType(thrift.INVALID_DATA, io.ErrUnexpectedEOF), false},
+		{io.ErrUnexpectedEOF, false},
+	} {
+		if got := s.isClosed(c.err); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.err, got, c.want)
+		}
+	}
+
+	// protocols keep the message only, the connection keeps the error
+	s.conns[0].keep(io.EOF)
+	if !s.isClosed(thrift.NewTProtocolException(thrift.NewTTransportExceptionFromError(io.EOF))) {
+		t.Error("EOF of the client not seen through a protocol exception")
+	}
+}
@@ -0,0 +1,127 @@
+// Command thrift-tracker-replay replays calls recorded by record.NewProcessor
+// against a server, typically a new build, and compares its replies with the
+// recorded ones, e.g.
+//
+//	$ thrift-tracker-replay -file calls.jsonl -target 127.0.0.1:9090 -idl calculator.thrift
+//	add request_id=7a51... differs
+//	  recorded: reply {success: 3}
+//	  replayed: reply {success: 4}
+//	METHOD  CALLS  SAME  DIFF  ERRORS
+//	add     2      1     1     0
+//	ping    1      1     0     0
+//
+// Every replayed call gets a request id of its own, the request id of the
+// recorded call is kept in its meta under record.MetaKeyReplayOf. Calls are
+// replayed one at a time, in the order of the file. Oneway calls are sent
+// but not compared.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/eleme/thrift-tracker/record"
+	"github.com/eleme/thrift-tracker/wire"
+)
+
+func main() {
+	file := flag.String("file", "", "calls recorded by record.NewProcessor, - for stdin")
+	target := flag.String("target", "", "address of the server to replay against")
+	name := flag.String("name", "thrift-tracker-replay", "app_id sent in the upgrade call")
+	framed := flag.Bool("framed", false, "use the framed transport rather than the buffered one")
+	protocol := flag.String("protocol", "binary", "binary or compact")
+	idlFile := flag.String("idl", "", ".thrift file to print differing results with")
+	verbose := flag.Bool("v", false, "print every call, not only differing ones")
+	flag.Parse()
+	if *file == "" || *target == "" || (*protocol != "binary" && *protocol != "compact") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r := &replayer{name: *name, verbose: *verbose, out: bufio.NewWriter(os.Stdout)}
+	if *idlFile != "" {
+		idl, err := wire.LoadIDL(*idlFile)
+		if err != nil {
+			fatal(err)
+		}
+		r.idl = idl
+	}
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	trans, err := thrift.NewTSocket(*target)
+	if err != nil {
+		fatal(err)
+	}
+	var transport thrift.TTransport = thrift.NewTBufferedTransport(trans, 8192)
+	if *framed {
+		transport = thrift.NewTFramedTransport(trans)
+	}
+	if err := transport.Open(); err != nil {
+		fatal(err)
+	}
+	defer transport.Close()
+	var protocolFactory thrift.TProtocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
+	if *protocol == "compact" {
+		protocolFactory = thrift.NewTCompactProtocolFactory()
+	}
+	r.iprot, r.oprot = protocolFactory.GetProtocol(transport), protocolFactory.GetProtocol(transport)
+
+	err = r.replay(record.NewReader(in))
+	r.printSummary()
+	r.out.Flush()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "thrift-tracker-replay:", err)
+	os.Exit(1)
+}
+
+// stats counts the replayed calls of a method.
+type stats struct {
+	calls, same, diff, errors int
+}
+
+func (r *replayer) printSummary() {
+	methods := make([]string, 0, len(r.stats))
+	for method := range r.stats {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	tw := tabwriter.NewWriter(r.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tCALLS\tSAME\tDIFF\tERRORS")
+	for _, method := range methods {
+		s := r.stats[method]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", method, s.calls, s.same, s.diff, s.errors)
+	}
+	tw.Flush()
+}
+
+// describe decodes a message encoded with TBinaryProtocol to its type and
+// body, which leaves out the seqid.
+func (r *replayer) describe(b []byte) (string, error) {
+	m, err := wire.NewDecoder(bytes.NewReader(b), wire.Options{IDL: r.idl}).Next()
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return wire.TypeName(m.Type) + " " + wire.Format(m.Body), nil
+}
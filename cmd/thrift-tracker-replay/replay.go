@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/record"
+	"github.com/eleme/thrift-tracker/wire"
+	"github.com/google/uuid"
+)
+
+type replayer struct {
+	name    string
+	idl     *wire.IDL
+	verbose bool
+	out     *bufio.Writer
+
+	tracker      tracker.Tracker
+	iprot, oprot thrift.TProtocol
+	seqID        int32
+	stats        map[string]*stats
+}
+
+func (r *replayer) replay(entries *record.Reader) error {
+	r.tracker = tracker.NewSimpleTracker(r.name)
+	r.stats = make(map[string]*stats)
+	if err := r.tracker.Negotiation(r.seqID, r.iprot, r.oprot); err != nil {
+		return err
+	}
+	r.seqID++
+	if !r.tracker.RequestHeaderSupported() {
+		fmt.Fprintln(r.out, "target does not support tracker, replaying without headers")
+	}
+	for {
+		e, err := entries.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s := r.stats[e.Method]
+		if s == nil {
+			s = &stats{}
+			r.stats[e.Method] = s
+		}
+		s.calls++
+		if err := r.replayEntry(e, s); err != nil {
+			return err
+		}
+	}
+}
+
+// replayEntry replays e and compares the reply. Errors of the recording or
+// of the reply only count against the method, errors of the connection are
+// returned.
+func (r *replayer) replayEntry(e *record.Entry, s *stats) error {
+	request := thrift.NewTMemoryBuffer()
+	request.Write(e.Request)
+	in := thrift.NewTBinaryProtocolTransport(request)
+	name, typ, _, err := in.ReadMessageBegin()
+	if err != nil {
+		r.report(s, e, "bad recording: %v", err)
+		return nil
+	}
+	args := thrift.NewTMemoryBuffer()
+	if err := wire.Copy(in, thrift.NewTBinaryProtocolTransport(args), thrift.STRUCT); err != nil {
+		r.report(s, e, "bad recording: %v", err)
+		return nil
+	}
+
+	meta := make(map[string]string, len(e.Meta)+1)
+	for k, v := range e.Meta {
+		meta[k] = v
+	}
+	if e.RequestID != "" {
+		meta[record.MetaKeyReplayOf] = e.RequestID
+	}
+	requestID := uuid.New().String()
+	ctx := tracker.WithTracking(context.Background(), &tracker.Tracking{RequestID: requestID, Seq: "1", Meta: meta})
+	seqID := r.seqID
+	r.seqID++
+	if err := r.send(ctx, name, typ, seqID, args); err != nil {
+		return err
+	}
+	if typ == thrift.ONEWAY {
+		r.printf("%s request_id=%s oneway\n", e.Method, e.RequestID)
+		return nil
+	}
+
+	reply, err := r.receive(name, seqID)
+	if err != nil {
+		return err
+	}
+	if len(e.Response) == 0 {
+		r.report(s, e, "no recorded reply")
+		return nil
+	}
+	recorded, err := r.describe(e.Response)
+	if err != nil {
+		r.report(s, e, "bad recording: %v", err)
+		return nil
+	}
+	replayed, err := r.describe(reply)
+	if err != nil {
+		r.report(s, e, "bad reply: %v", err)
+		return nil
+	}
+	if recorded == replayed {
+		s.same++
+		r.printf("%s request_id=%s same\n", e.Method, e.RequestID)
+		return nil
+	}
+	s.diff++
+	fmt.Fprintf(r.out, "%s request_id=%s differs\n  recorded: %s\n  replayed: %s\n", e.Method, e.RequestID, recorded, replayed)
+	return nil
+}
+
+func (r *replayer) send(ctx context.Context, name string, typ thrift.TMessageType, seqID int32, args *thrift.TMemoryBuffer) error {
+	if err := r.tracker.TryWriteRequestHeader(ctx, r.oprot); err != nil {
+		return err
+	}
+	if err := r.oprot.WriteMessageBegin(name, typ, seqID); err != nil {
+		return err
+	}
+	if err := wire.Copy(thrift.NewTBinaryProtocolTransport(args), r.oprot, thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := r.oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return r.oprot.Flush()
+}
+
+// receive reads the reply of a call and returns it encoded with
+// TBinaryProtocol, like recorded replies.
+func (r *replayer) receive(method string, seqID int32) ([]byte, error) {
+	name, typ, replySeqID, err := r.iprot.ReadMessageBegin()
+	if err != nil {
+		return nil, err
+	}
+	if name != method || replySeqID != seqID {
+		return nil, fmt.Errorf("%s seqid=%d: reply of %s seqid=%d", method, seqID, name, replySeqID)
+	}
+	buf := thrift.NewTMemoryBuffer()
+	out := thrift.NewTBinaryProtocolTransport(buf)
+	if err := out.WriteMessageBegin(name, typ, replySeqID); err != nil {
+		return nil, err
+	}
+	if err := wire.Copy(r.iprot, out, thrift.STRUCT); err != nil {
+		return nil, err
+	}
+	if err := r.iprot.ReadMessageEnd(); err != nil {
+		return nil, err
+	}
+	if err := out.WriteMessageEnd(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *replayer) report(s *stats, e *record.Entry, format string, args ...interface{}) {
+	s.errors++
+	fmt.Fprintf(r.out, "%s request_id=%s %s\n", e.Method, e.RequestID, fmt.Sprintf(format, args...))
+}
+
+// printf prints with -v only.
+func (r *replayer) printf(format string, args ...interface{}) {
+	if r.verbose {
+		fmt.Fprintf(r.out, format, args...)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/record"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+// handler keeps the contexts of calls.
+type handler struct {
+	mu   sync.Mutex
+	ctxs []context.Context
+	logs chan string
+}
+
+func (h *handler) keep(ctx context.Context) {
+	h.mu.Lock()
+	h.ctxs = append(h.ctxs, ctx)
+	h.mu.Unlock()
+}
+
+func (h *handler) Ping(ctx context.Context) (bool, error) {
+	h.keep(ctx)
+	return true, nil
+}
+
+func (h *handler) Add(ctx context.Context, num1, num2 int32) (int32, error) {
+	h.keep(ctx)
+	return num1 + num2, nil
+}
+
+func (h *handler) Log(ctx context.Context, message string) error {
+	h.keep(ctx)
+	h.logs <- message
+	return nil
+}
+
+func message(t *testing.T, name string, typ thrift.TMessageType, body interface{ Write(thrift.TProtocol) error }) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	prot.WriteMessageBegin(name, typ, 7)
+	if err := body.Write(prot); err != nil {
+		t.Fatal(err)
+	}
+	prot.WriteMessageEnd()
+	return buf.Bytes()
+}
+
+func addEntry(t *testing.T, requestID string, num1, num2, sum int32) *record.Entry {
+	return &record.Entry{
+		RequestID: requestID,
+		Meta:      map[string]string{"k": "v"},
+		Method:    "add",
+		Request:   message(t, "add", thrift.CALL, &calculator.CalculatorServiceAddArgs{Num1: num1, Num2: num2}),
+		Response:  message(t, "add", thrift.REPLY, &calculator.CalculatorServiceAddResult{Success: &sum}),
+	}
+}
+
+// replay replays entries against a calculator served over a pipe and
+// returns what was printed.
+func replay(t *testing.T, h *handler, entries ...*record.Entry) (*replayer, string) {
+	client, server := trackertest.NewPipe()
+	go func() {
+		defer server.Close()
+		prot := thrift.NewTBinaryProtocolTransport(server)
+		processor := calculator.NewCalculatorServiceProcessor(tracker.NewSimpleTracker("calculator"), h)
+		for {
+			if ok, err := processor.Process(prot, prot); !ok || err != nil {
+				return
+			}
+		}
+	}()
+	defer client.Close()
+
+	var file bytes.Buffer
+	w := record.NewWriter(&file)
+	for _, e := range entries {
+		w.Write(e)
+	}
+	var out bytes.Buffer
+	r := &replayer{name: "replay", verbose: true, out: bufio.NewWriter(&out)}
+	r.iprot = thrift.NewTBinaryProtocolTransport(client)
+	r.oprot = r.iprot
+	if err := r.replay(record.NewReader(&file)); err != nil {
+		t.Fatal(err)
+	}
+	r.out.Flush()
+	return r, out.String()
+}
+
+func TestReplay(t *testing.T) {
+	h := &handler{logs: make(chan string, 1)}
+	pong := true
+	r, out := replay(t, h,
+		addEntry(t, "req-1", 1, 2, 3),
+		// recorded off a build with a bug
+		addEntry(t, "req-2", 2, 2, 5),
+		&record.Entry{
+			RequestID: "req-3",
+			Method:    "log",
+			Oneway:    true,
+			Request:   message(t, "log", thrift.ONEWAY, &calculator.CalculatorServiceLogArgs{Message: "hello"}),
+		},
+		// ping answers the log call is served
+		&record.Entry{
+			Method:   "ping",
+			Request:  message(t, "ping", thrift.CALL, &calculator.CalculatorServicePingArgs{}),
+			Response: message(t, "ping", thrift.REPLY, &calculator.CalculatorServicePingResult{Success: &pong}),
+		},
+	)
+	if got := <-h.logs; got != "hello" {
+		t.Fatalf("logged %q", got)
+	}
+	for _, want := range []string{
+		"add request_id=req-1 same\n",
+		"add request_id=req-2 differs\n  recorded: reply {0: 5}\n  replayed: reply {0: 4}\n",
+		"log request_id=req-3 oneway\n",
+		"ping request_id= same\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q lacks %q", out, want)
+		}
+	}
+	if s := r.stats["add"]; *s != (stats{calls: 2, same: 1, diff: 1}) {
+		t.Errorf("got add stats %+v", *s)
+	}
+
+	seen := make(map[string]bool)
+	for i, ctx := range h.ctxs[:3] {
+		meta, _ := ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string)
+		requestID, _ := ctx.Value(tracker.CtxKeyRequestID).(string)
+		if want := []string{"req-1", "req-2", "req-3"}[i]; meta[record.MetaKeyReplayOf] != want {
+			t.Errorf("call %d: got meta %v, want %s replayed", i, meta, want)
+		}
+		if requestID == "" || strings.HasPrefix(requestID, "req-") || seen[requestID] {
+			t.Errorf("call %d: got request id %q, want a new one", i, requestID)
+		}
+		seen[requestID] = true
+	}
+	if meta, _ := h.ctxs[0].Value(tracker.CtxKeyRequestMeta).(map[string]string); meta["k"] != "v" {
+		t.Errorf("got meta %v, want the recorded meta kept", meta)
+	}
+	if meta, _ := h.ctxs[3].Value(tracker.CtxKeyRequestMeta).(map[string]string); meta[record.MetaKeyReplayOf] != "" {
+		t.Errorf("got meta %v for a call recorded without request id", meta)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	noReply := addEntry(t, "req-1", 1, 2, 3)
+	noReply.Response = nil
+	badResponse := addEntry(t, "req-3", 1, 2, 3)
+	badResponse.Response = badResponse.Response[:10]
+	r, out := replay(t, &handler{},
+		noReply,
+		&record.Entry{RequestID: "req-2", Method: "add", Request: []byte{0x80}},
+		badResponse,
+	)
+	for _, want := range []string{
+		"add request_id=req-1 no recorded reply\n",
+		"add request_id=req-2 bad recording: ",
+		"add request_id=req-3 bad recording: ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q lacks %q", out, want)
+		}
+	}
+	if s := r.stats["add"]; *s != (stats{calls: 3, errors: 3}) {
+		t.Errorf("got add stats %+v", *s)
+	}
+}
+
+// mapReply is a reply of a map<string, i32> in the order of keys.
+func mapReply(keys ...string) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	prot.WriteMessageBegin("counts", thrift.REPLY, 1)
+	prot.WriteStructBegin("result")
+	prot.WriteFieldBegin("success", thrift.MAP, 0)
+	prot.WriteMapBegin(thrift.STRING, thrift.I32, len(keys))
+	for _, key := range keys {
+		prot.WriteString(key)
+		prot.WriteI32(int32(len(key)))
+	}
+	prot.WriteMapEnd()
+	prot.WriteFieldEnd()
+	prot.WriteFieldStop()
+	prot.WriteStructEnd()
+	prot.WriteMessageEnd()
+	return buf.Bytes()
+}
+
+func TestDescribeMapOrder(t *testing.T) {
+	r := &replayer{}
+	a, err := r.describe(mapReply("a", "bb", "ccc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.describe(mapReply("ccc", "a", "bb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || a != `reply {0: {"a": 1, "bb": 2, "ccc": 3}}` {
+		t.Fatalf("got %s and %s, want equal maps alike", a, b)
+	}
+	if c, _ := r.describe(mapReply("a", "bb")); c == a {
+		t.Fatalf("got %s for a smaller map", c)
+	}
+}
@@ -0,0 +1,159 @@
+package tracker_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+)
+
+// Golden fixtures of testdata/thriftpy, see capture.py there.
+const (
+	fixtureRequestID = "b7d4f5c2-6b8e-4d7b-9a63-2f0f4f3d1e8a"
+	fixtureSeq       = "1.1"
+)
+
+var fixtureMeta = map[string]string{"clientA": "ping"}
+
+func loadFixture(t *testing.T, name string) []byte {
+	f, err := os.Open(filepath.Join("testdata", "thriftpy", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var data []byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		b, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data = append(data, b...)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newMemProtocols(input []byte) (in, out *thrift.TMemoryBuffer, iprot, oprot thrift.TProtocol) {
+	in, out = thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+	in.Write(input)
+	f := thrift.NewTBinaryProtocolFactoryDefault()
+	return in, out, f.GetProtocol(in), f.GetProtocol(out)
+}
+
+func TestConformanceClient(t *testing.T) {
+	_, out, iprot, oprot := newMemProtocols(loadFixture(t, "upgrade_reply.hex"))
+	ttracker := tracker.NewSimpleTracker("client")
+	// thriftpy always uses seqid 0
+	if err := ttracker.Negotiation(0, iprot, oprot); err != nil {
+		t.Fatal(err)
+	}
+	if want := loadFixture(t, "upgrade_call.hex"); !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("upgrade call:\ngot  %x\nwant %x", out.Bytes(), want)
+	}
+	if !ttracker.RequestHeaderSupported() {
+		t.Fatal("not upgraded after upgrade reply")
+	}
+
+	out.Reset()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, tracker.CtxKeyRequestID, fixtureRequestID)
+	ctx = context.WithValue(ctx, tracker.CtxKeySequenceID, fixtureSeq)
+	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, fixtureMeta)
+	if err := ttracker.TryWriteRequestHeader(ctx, oprot); err != nil {
+		t.Fatal(err)
+	}
+	oprot.WriteMessageBegin("ping", thrift.CALL, 0)
+	calculator.NewCalculatorServicePingArgs().Write(oprot)
+	oprot.WriteMessageEnd()
+	if want := loadFixture(t, "header_call.hex"); !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("header and call:\ngot  %x\nwant %x", out.Bytes(), want)
+	}
+}
+
+func TestConformanceClientUntracked(t *testing.T) {
+	_, out, iprot, oprot := newMemProtocols(loadFixture(t, "unknown_method.hex"))
+	ttracker := tracker.NewSimpleTracker("client")
+	if err := ttracker.Negotiation(0, iprot, oprot); err != nil {
+		t.Fatal(err)
+	}
+	if ttracker.RequestHeaderSupported() {
+		t.Fatal("upgraded after UNKNOWN_METHOD")
+	}
+	out.Reset()
+	if err := ttracker.TryWriteRequestHeader(context.Background(), oprot); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("header written to untracked server: %x", out.Bytes())
+	}
+}
+
+type ctxHandler struct {
+	ctx context.Context
+}
+
+func (h *ctxHandler) Ping(ctx context.Context) (bool, error) {
+	h.ctx = ctx
+	return true, nil
+}
+
+func (h *ctxHandler) Add(ctx context.Context, num1, num2 int32) (int32, error) {
+	h.ctx = ctx
+	return num1 + num2, nil
+}
+
+func (h *ctxHandler) Log(ctx context.Context, message string) error {
+	h.ctx = ctx
+	return nil
+}
+
+func TestConformanceServer(t *testing.T) {
+	input := append(loadFixture(t, "upgrade_call.hex"), loadFixture(t, "header_call.hex")...)
+	in, out, iprot, oprot := newMemProtocols(input)
+	handler := &ctxHandler{}
+	processor := calculator.NewCalculatorServiceProcessor(tracker.NewSimpleTracker("server"), handler)
+
+	if ok, err := processor.Process(iprot, oprot); !ok || err != nil {
+		t.Fatalf("upgrade: got (%v, %v)", ok, err)
+	}
+	if want := loadFixture(t, "upgrade_reply.hex"); !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("upgrade reply:\ngot  %x\nwant %x", out.Bytes(), want)
+	}
+
+	out.Reset()
+	if ok, err := processor.Process(iprot, oprot); !ok || err != nil {
+		t.Fatalf("ping: got (%v, %v)", ok, err)
+	}
+	if in.Len() != 0 {
+		t.Fatalf("%d bytes left unread", in.Len())
+	}
+	if got := handler.ctx.Value(tracker.CtxKeyRequestID); got != fixtureRequestID {
+		t.Errorf("got request id %v, want %v", got, fixtureRequestID)
+	}
+	if got := handler.ctx.Value(tracker.CtxKeySequenceID); got != fixtureSeq {
+		t.Errorf("got seq %v, want %v", got, fixtureSeq)
+	}
+	if got := handler.ctx.Value(tracker.CtxKeyRequestMeta); !reflect.DeepEqual(got, fixtureMeta) {
+		t.Errorf("got meta %v, want %v", got, fixtureMeta)
+	}
+	name, mTypeID, seqID, err := thrift.NewTBinaryProtocolTransport(out).ReadMessageBegin()
+	if err != nil || name != "ping" || mTypeID != thrift.REPLY || seqID != 0 {
+		t.Fatalf("ping reply: got (%q, %v, %d, %v)", name, mTypeID, seqID, err)
+	}
+}
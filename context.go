@@ -0,0 +1,85 @@
+package tracker
+
+import (
+	"context"
+)
+
+// Tracking is what a request header carries. The Tracking of a context is
+// shared by everything derived from it and must not be modified, use
+// WithRequestMeta or WithTracking to change it for downstream calls.
+type Tracking struct {
+	RequestID string
+	Seq       string
+	Meta      map[string]string
+}
+
+// trackingCtx holds a Tracking as a single context value, it also answers
+// CtxKeyRequestID, CtxKeySequenceID and CtxKeyRequestMeta, and
+// CtxKeyPeerAppID on servers. The ids are boxed once here so that looking
+// them up does not allocate.
+type trackingCtx struct {
+	context.Context
+	tracking  Tracking
+	requestID interface{}
+	seq       interface{}
+	peerAppID interface{}
+}
+
+func newTrackingCtx(parent context.Context, requestID, seq string, meta map[string]string) *trackingCtx {
+	return &trackingCtx{
+		Context:   parent,
+		tracking:  Tracking{RequestID: requestID, Seq: seq, Meta: meta},
+		requestID: requestID,
+		seq:       seq,
+	}
+}
+
+func (c *trackingCtx) Value(key interface{}) interface{} {
+	switch key {
+	case ctxKeyTracking:
+		return &c.tracking
+	case CtxKeyRequestID:
+		return c.requestID
+	case CtxKeySequenceID:
+		return c.seq
+	case CtxKeyRequestMeta:
+		return c.tracking.Meta
+	case CtxKeyPeerAppID:
+		if c.peerAppID != nil {
+			return c.peerAppID
+		}
+	}
+	return c.Context.Value(key)
+}
+
+// WithTracking returns a copy of parent carrying t.
+func WithTracking(parent context.Context, t *Tracking) context.Context {
+	return newTrackingCtx(parent, t.RequestID, t.Seq, t.Meta)
+}
+
+// TrackingFromCtx returns the Tracking ctx got from a request header or from
+// WithTracking. Values set with the CtxKey* keys on top of it are not
+// reflected, RequestSeqIDFromCtx and CtxKeyRequestMeta lookups see them.
+func TrackingFromCtx(ctx context.Context) (*Tracking, bool) {
+	t, ok := ctx.Value(ctxKeyTracking).(*Tracking)
+	return t, ok
+}
+
+// WithRequestMeta returns a copy of parent whose request meta has key set to
+// value. The meta of parent is copied here, not when the header is written.
+func WithRequestMeta(parent context.Context, key, value string) context.Context {
+	origin, _ := parent.Value(CtxKeyRequestMeta).(map[string]string)
+	meta := make(map[string]string, len(origin)+1)
+	for k, v := range origin {
+		meta[k] = v
+	}
+	meta[key] = value
+	return context.WithValue(parent, CtxKeyRequestMeta, meta)
+}
+
+// PeerAppIDFromCtx returns the app_id the client of a served call sent in
+// its upgrade call, empty when the connection is not upgraded.
+func PeerAppIDFromCtx(ctx context.Context) string {
+	appID, _ := ctx.Value(CtxKeyPeerAppID).(string)
+	return appID
+}
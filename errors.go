@@ -0,0 +1,35 @@
+package tracker
+
+import (
+	"errors"
+)
+
+var (
+	ErrPeerUnsupported        = errors.New("tracker negotiation failed: peer does not support tracking")
+	ErrHandshakeOutOfSequence = errors.New("tracker negotiation failed: out of sequence response")
+	ErrHandshakeWrongMethod   = errors.New("tracker negotiation failed: wrong method name")
+	ErrHeaderDecode           = errors.New("tracker: request header decode failed")
+)
+
+// Error wraps the thrift error behind a tracker failure, errors.Is matches it
+// against its Kind, one of the Err* values above, and errors.As reaches the
+// thrift error.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -38,4 +38,6 @@ service CalculatorService {
         throws (1: CalculatorUserException user_exception,
                 2: CalculatorSystemException system_exception,
                 3: CalculatorUnknownException unknown_exception),
+
+    oneway void log(1:string message),
 }
@@ -1,4 +1,4 @@
-// Autogenerated by Thrift Compiler (1.0.0-dev)
+// Autogenerated by Thrift Compiler (0.10.0)
 // DO NOT EDIT UNLESS YOU ARE SURE THAT YOU KNOW WHAT YOU ARE DOING
 
 package calculator
@@ -6,18 +6,14 @@ package calculator
 import (
 	"bytes"
 	"reflect"
-	"context"
 	"fmt"
 	"github.com/apache/thrift/lib/go/thrift"
-	tracker "github.com/eleme/thrift-tracker"
 )
 
 // (needed to ensure safety because of naive import list construction.)
 var _ = thrift.ZERO
 var _ = fmt.Printf
 var _ = reflect.DeepEqual
-var _ = context.TODO()
-var _ = tracker.TrackingAPIName
 var _ = bytes.Equal
 
 
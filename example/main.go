@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
 	tracker "github.com/eleme/thrift-tracker"
@@ -25,39 +24,42 @@ func ppCtx(name string, ctx context.Context) {
 	fmt.Printf("  - Meta: %#+v\n", ctx.Value(tracker.CtxKeyRequestMeta))
 }
 
-// ServerB's handler
+// ServerB's handler, one per connection: a generated client serves one call
+// at a time, so every connection to server B gets its own client to server C.
 type handlerB struct {
 	client *calculator.CalculatorServiceClient
 }
 
+func newHandlerB() calculator.CalculatorService {
+	client, err := NewClient(ServerCAddr, ServerB)
+	must(err)
+	return &handlerB{client: client}
+}
+
 func (h *handlerB) Ping(ctx context.Context) (bool, error) {
 	ppCtx(ServerB, ctx)
-	meta := map[string]string{"clientB": "ping"}
-	if origin, ok := ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string); ok {
-		for k, v := range origin {
-			meta[k] = v
-		}
-	}
-	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, meta)
+	ctx = tracker.WithRequestMeta(ctx, "clientB", "ping")
 	h.client.Ping(ctx)
 	return true, nil
 }
 
 func (h *handlerB) Add(ctx context.Context, num1, num2 int32) (int32, error) {
 	ppCtx(ServerB, ctx)
-	meta := map[string]string{"clientB": "add"}
-	if origin, ok := ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string); ok {
-		for k, v := range origin {
-			meta[k] = v
-		}
-	}
-	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, meta)
+	ctx = tracker.WithRequestMeta(ctx, "clientB", "add")
 	h.client.Add(ctx, num1+1, num2+2)
 	return num1 + num2, nil
 }
 
+func (h *handlerB) Log(ctx context.Context, message string) error {
+	ppCtx(ServerB, ctx)
+	ctx = tracker.WithRequestMeta(ctx, "clientB", "log")
+	return h.client.Log(ctx, message)
+}
+
 // ServerC's handler
-type handlerC struct{}
+type handlerC struct {
+	logged chan string
+}
 
 func (h *handlerC) Ping(ctx context.Context) (bool, error) {
 	ppCtx(ServerC, ctx)
@@ -69,7 +71,13 @@ func (h *handlerC) Add(ctx context.Context, num1, num2 int32) (int32, error) {
 	return num1 + num2, nil
 }
 
-func NewClient(addr, name string) (*calculator.CalculatorServiceClient, error) {
+func (h *handlerC) Log(ctx context.Context, message string) error {
+	ppCtx(ServerC, ctx)
+	h.logged <- message
+	return nil
+}
+
+func openTransport(addr string) (thrift.TTransport, error) {
 	transportFactory := thrift.NewTBufferedTransportFactory(4096)
 	socket, err := thrift.NewTSocket(addr)
 	if err != nil {
@@ -78,7 +86,14 @@ func NewClient(addr, name string) (*calculator.CalculatorServiceClient, error) {
 	if err = socket.Open(); err != nil {
 		return nil, err
 	}
-	transport := transportFactory.GetTransport(socket)
+	return transportFactory.GetTransport(socket), nil
+}
+
+func NewClient(addr, name string) (*calculator.CalculatorServiceClient, error) {
+	transport, err := openTransport(addr)
+	if err != nil {
+		return nil, err
+	}
 	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
 
 	ttracker := tracker.NewSimpleTracker(name)
@@ -89,35 +104,52 @@ func NewClient(addr, name string) (*calculator.CalculatorServiceClient, error) {
 	return client, nil
 }
 
-func RunServer(addr, name string, handler calculator.CalculatorService) {
-	ttracker := tracker.NewSimpleTracker(name)
-	processor := calculator.NewCalculatorServiceProcessor(ttracker, handler)
+// NewPlainClient skips negotiation, acting like a client without tracker support.
+func NewPlainClient(addr, name string) (*calculator.CalculatorServiceClient, error) {
+	transport, err := openTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
+	return &calculator.CalculatorServiceClient{
+		Tracker:         tracker.NewSimpleTracker(name),
+		Transport:       transport,
+		ProtocolFactory: protocolFactory,
+		InputProtocol:   protocolFactory.GetProtocol(transport),
+		OutputProtocol:  protocolFactory.GetProtocol(transport),
+		SeqId:           1,
+	}, nil
+}
+
+// RunServer listens on addr and serves connections in the background, each
+// with a handler of its own from newHandler.
+func RunServer(addr, name string, newHandler func() calculator.CalculatorService) {
+	// one tracker per connection, upgraded and plain clients can share a server
+	processorFactory := tracker.NewProcessorFactory(
+		tracker.NewSimpleTrackerFactory(name),
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, newHandler())
+		},
+	)
 
 	transport, err := thrift.NewTServerSocket(addr)
 	must(err)
 	transportFactory := thrift.NewTBufferedTransportFactory(4096)
 	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
-	server := thrift.NewTSimpleServer4(
-		processor,
+	server := thrift.NewTSimpleServerFactory4(
+		processorFactory,
 		transport,
 		transportFactory,
 		protocolFactory,
 	)
-	must(server.Serve())
+	must(server.Listen())
+	go server.AcceptLoop()
 }
 
 func main() {
-	go func() {
-		RunServer(ServerCAddr, ServerC, &handlerC{})
-	}()
-	time.Sleep(10 * time.Millisecond) // wait server C started
-
-	clientB, err := NewClient(ServerCAddr, ServerB)
-	must(err)
-	go func() {
-		RunServer(ServerBAddr, ServerB, &handlerB{client: clientB})
-	}()
-	time.Sleep(10 * time.Millisecond) // wait server B started
+	handlerC := &handlerC{logged: make(chan string, 1)}
+	RunServer(ServerCAddr, ServerC, func() calculator.CalculatorService { return handlerC })
+	RunServer(ServerBAddr, ServerB, newHandlerB)
 
 	clientA, err := NewClient(ServerBAddr, ServerA)
 	must(err)
@@ -128,6 +160,21 @@ func main() {
 	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, map[string]string{"clientA": "add"})
 	_, err = clientA.Add(ctx, 1, 2)
 	must(err)
+
+	// a plain client on the same server as clientA
+	plainClient, err := NewPlainClient(ServerBAddr, "plain")
+	must(err)
+	_, err = plainClient.Ping(context.Background())
+	must(err)
+	_, err = clientA.Ping(ctx)
+	must(err)
+	_, err = plainClient.Add(context.Background(), 3, 4)
+	must(err)
+
+	// oneway calls carry the request header too, without a reply
+	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, map[string]string{"clientA": "log"})
+	must(clientA.Log(ctx, "audit"))
+	<-handlerC.logged // wait the log reaches server C
 }
 
 func must(err error) {
@@ -0,0 +1,183 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// MetaKeyFault carries a fault spec in the request meta, see ParseFault. The
+// meta propagates downstream with the context, so a fault set by the first
+// client of a call chain reaches every server of it.
+const MetaKeyFault = "x-fault"
+
+// Fault is a fault to inject into calls, parsed from specs such as
+//
+//	delay=200ms;target=server-C;method=add
+//	abort=INTERNAL_ERROR;pct=10
+//
+// delay is a time.Duration to wait before the handler runs, abort the type of
+// a TApplicationException to fail the call with, by name or number. target is
+// the tracker name of the server to inject into and method the method, both
+// match everything when left out. pct is the share of matching calls
+// injected into, 100 by default.
+type Fault struct {
+	Delay     time.Duration
+	Abort     bool
+	AbortType int32
+	Target    string
+	Method    string
+	Percent   float64
+}
+
+var applicationExceptionTypes = map[string]int32{
+	"UNKNOWN":              thrift.UNKNOWN_APPLICATION_EXCEPTION,
+	"UNKNOWN_METHOD":       thrift.UNKNOWN_METHOD,
+	"INVALID_MESSAGE_TYPE": thrift.INVALID_MESSAGE_TYPE_EXCEPTION,
+	"WRONG_METHOD_NAME":    thrift.WRONG_METHOD_NAME,
+	"BAD_SEQUENCE_ID":      thrift.BAD_SEQUENCE_ID,
+	"MISSING_RESULT":       thrift.MISSING_RESULT,
+	"INTERNAL_ERROR":       thrift.INTERNAL_ERROR,
+	"PROTOCOL_ERROR":       thrift.PROTOCOL_ERROR,
+}
+
+// ParseFault parses a fault spec, it needs a delay or an abort.
+func ParseFault(spec string) (*Fault, error) {
+	f := &Fault{Percent: 100}
+	for _, kv := range strings.Split(spec, ";") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("tracker: fault %q: %q is not key=value", spec, kv)
+		}
+		key, value := kv[:i], kv[i+1:]
+		var err error
+		switch key {
+		case "delay":
+			f.Delay, err = time.ParseDuration(value)
+			if err == nil && f.Delay < 0 {
+				err = fmt.Errorf("negative delay")
+			}
+		case "abort":
+			f.Abort = true
+			if t, ok := applicationExceptionTypes[value]; ok {
+				f.AbortType = t
+			} else {
+				var n int64
+				n, err = strconv.ParseInt(value, 10, 32)
+				f.AbortType = int32(n)
+			}
+		case "target":
+			f.Target = value
+		case "method":
+			f.Method = value
+		case "pct":
+			f.Percent, err = strconv.ParseFloat(value, 64)
+			if err == nil && (f.Percent < 0 || f.Percent > 100) {
+				err = fmt.Errorf("pct out of range")
+			}
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tracker: fault %q: %s: %v", spec, key, err)
+		}
+	}
+	if f.Delay == 0 && !f.Abort {
+		return nil, fmt.Errorf("tracker: fault %q: neither delay nor abort", spec)
+	}
+	return f, nil
+}
+
+// FaultPolicy controls the faults a fault processor injects.
+type FaultPolicy struct {
+	// Allow is asked for every call carrying a fault whether it may be
+	// injected, a nil Allow never allows it. Leave it nil in production, or back it
+	// by a switch flipped for chaos tests only.
+	Allow func() bool
+	// MaxDelay caps the delay of injected faults, so that a fault spec can
+	// not hold a connection for long. It defaults to DefaultMaxFaultDelay.
+	MaxDelay time.Duration
+	// OnInject is called with every fault injected, and with the error of
+	// every fault spec which does not parse.
+	OnInject func(method string, f *Fault, err error)
+}
+
+// DefaultMaxFaultDelay is the FaultPolicy.MaxDelay of a zero policy.
+const DefaultMaxFaultDelay = 10 * time.Second
+
+type faultProcessor struct {
+	tracker Tracker
+	policy  FaultPolicy
+}
+
+// NewFaultProcessor returns a processor injecting the faults set under
+// MetaKeyFault in request headers into calls of the processor newProcessor
+// binds, when allowed by policy and targeted at the name of t, the tracker
+// of the connection.
+func NewFaultProcessor(t Tracker, newProcessor NewProcessorFunc, policy FaultPolicy) thrift.TProcessor {
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultMaxFaultDelay
+	}
+	f := &faultProcessor{tracker: t, policy: policy}
+	p := newInterceptProcessor(t, newProcessor)
+	p.pass = func() bool { return !t.RequestHeaderSupported() || policy.Allow == nil }
+	p.check = f.inject
+	return p
+}
+
+// inject waits for the delay of the fault of a call and returns its abort,
+// if any.
+func (p *faultProcessor) inject(ctx context.Context, method string) *rejection {
+	f := p.fault(ctx, method)
+	if f == nil {
+		return nil
+	}
+	if delay := f.Delay; delay > 0 {
+		if delay > p.policy.MaxDelay {
+			delay = p.policy.MaxDelay
+		}
+		time.Sleep(delay)
+	}
+	if !f.Abort {
+		return nil
+	}
+	return &rejection{
+		x: thrift.NewTApplicationException(f.AbortType, "fault injected into "+method+" by "+p.tracker.Name()),
+	}
+}
+
+// fault returns the fault of ctx to inject into a call of method, if any.
+func (p *faultProcessor) fault(ctx context.Context, method string) *Fault {
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	spec, ok := meta[MetaKeyFault]
+	// Allow is asked once the call arrived, Process waits for it
+	if !ok || !p.policy.Allow() {
+		return nil
+	}
+	f, err := ParseFault(spec)
+	if err != nil {
+		if p.policy.OnInject != nil {
+			p.policy.OnInject(method, nil, err)
+		}
+		return nil
+	}
+	if (f.Target != "" && f.Target != p.tracker.Name()) || (f.Method != "" && f.Method != method) {
+		return nil
+	}
+	if f.Percent < 100 && rand.Float64()*100 >= f.Percent {
+		return nil
+	}
+	if p.policy.OnInject != nil {
+		p.policy.OnInject(method, f, nil)
+	}
+	return f
+}
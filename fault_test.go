@@ -0,0 +1,233 @@
+package tracker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+func TestParseFault(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want tracker.Fault
+	}{
+		{"delay=200ms;target=server-C;method=add", tracker.Fault{Delay: 200 * time.Millisecond, Target: "server-C", Method: "add", Percent: 100}},
+		{"abort=INTERNAL_ERROR;pct=10", tracker.Fault{Abort: true, AbortType: thrift.INTERNAL_ERROR, Percent: 10}},
+		{"abort=7", tracker.Fault{Abort: true, AbortType: 7, Percent: 100}},
+		{" delay=1s ; abort=UNKNOWN_METHOD ;", tracker.Fault{Delay: time.Second, Abort: true, AbortType: thrift.UNKNOWN_METHOD, Percent: 100}},
+		{"abort=0;pct=0", tracker.Fault{Abort: true, Percent: 0}},
+	} {
+		f, err := tracker.ParseFault(c.spec)
+		if err != nil {
+			t.Errorf("%q: %v", c.spec, err)
+			continue
+		}
+		if *f != c.want {
+			t.Errorf("%q: got %+v, want %+v", c.spec, *f, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"pct=10",
+		"target=server-C",
+		"delay",
+		"delay=fast",
+		"delay=-1s",
+		"abort=BROKEN",
+		"abort=4294967296",
+		"pct=101",
+		"pct=-1",
+		"delay=1s;color=red",
+	} {
+		if f, err := tracker.ParseFault(spec); err == nil {
+			t.Errorf("%q: got %+v, want an error", spec, *f)
+		}
+	}
+}
+
+// methodHandler keeps the methods called.
+type methodHandler struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (h *methodHandler) called(method string) {
+	h.mu.Lock()
+	h.methods = append(h.methods, method)
+	h.mu.Unlock()
+}
+
+func (h *methodHandler) Ping(ctx context.Context) (bool, error) {
+	h.called("ping")
+	return true, nil
+}
+
+func (h *methodHandler) Add(ctx context.Context, num1, num2 int32) (int32, error) {
+	h.called("add")
+	return num1 + num2, nil
+}
+
+func (h *methodHandler) Log(ctx context.Context, message string) error {
+	h.called("log")
+	return nil
+}
+
+func (h *methodHandler) Methods() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.methods...)
+}
+
+// faultInjection is a fault injected or failing to parse.
+type faultInjection struct {
+	method string
+	fault  *tracker.Fault
+	err    error
+}
+
+// newFaultClient serves the calculator named server-C with a fault processor
+// of policy, whose OnInject is replaced to send to the returned channel.
+func newFaultClient(t *testing.T, policy tracker.FaultPolicy, opts ...trackertest.Option) (*calculator.CalculatorServiceClient, *methodHandler, chan faultInjection) {
+	handler := &methodHandler{}
+	injections := make(chan faultInjection, 10)
+	policy.OnInject = func(method string, f *tracker.Fault, err error) {
+		injections <- faultInjection{method, f, err}
+	}
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return tracker.NewFaultProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, handler)
+		}, policy)
+	}, append([]trackertest.Option{trackertest.WithNames("client", "server-C")}, opts...)...)
+	t.Cleanup(func() { h.Close() })
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, handler, injections
+}
+
+func faultCtx(spec string) context.Context {
+	return tracker.WithRequestMeta(context.Background(), tracker.MetaKeyFault, spec)
+}
+
+func allow() bool { return true }
+
+func isAbort(err error, typeID int32) bool {
+	x, ok := err.(thrift.TApplicationException)
+	return ok && x.TypeId() == typeID
+}
+
+func TestFaultProcessorMatching(t *testing.T) {
+	for _, c := range []struct {
+		spec     string
+		injected bool
+	}{
+		{"abort=INTERNAL_ERROR", true},
+		{"abort=INTERNAL_ERROR;target=server-C", true},
+		{"abort=INTERNAL_ERROR;target=server-D", false},
+		{"abort=INTERNAL_ERROR;method=add", true},
+		{"abort=INTERNAL_ERROR;method=ping", false},
+		{"abort=INTERNAL_ERROR;target=server-C;method=add;pct=100", true},
+		{"abort=INTERNAL_ERROR;pct=0", false},
+	} {
+		t.Run(c.spec, func(t *testing.T) {
+			client, handler, injections := newFaultClient(t, tracker.FaultPolicy{Allow: allow})
+			sum, err := client.Add(faultCtx(c.spec), 1, 2)
+			if !c.injected {
+				if err != nil || sum != 3 {
+					t.Fatalf("got (%d, %v), want 3", sum, err)
+				}
+				if len(injections) != 0 {
+					t.Fatalf("got injection %+v", <-injections)
+				}
+				return
+			}
+			if !isAbort(err, thrift.INTERNAL_ERROR) {
+				t.Fatalf("got %v, want the injected fault", err)
+			}
+			if in := <-injections; in.method != "add" || in.fault == nil || in.err != nil {
+				t.Fatalf("got injection %+v", in)
+			}
+			// the connection carries on, without the fault
+			if sum, err := client.Add(context.Background(), 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want 3", sum, err)
+			}
+			if methods := handler.Methods(); len(methods) != 1 {
+				t.Fatalf("handler called for %v, want the call without fault only", methods)
+			}
+		})
+	}
+}
+
+func TestFaultProcessorNotAllowed(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		allow func() bool
+	}{
+		{"nil allow", nil},
+		{"false allow", func() bool { return false }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			client, _, injections := newFaultClient(t, tracker.FaultPolicy{Allow: c.allow})
+			if sum, err := client.Add(faultCtx("abort=INTERNAL_ERROR"), 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want the call through", sum, err)
+			}
+			if len(injections) != 0 {
+				t.Fatalf("got injection %+v", <-injections)
+			}
+		})
+	}
+}
+
+func TestFaultProcessorUntracked(t *testing.T) {
+	client, _, _ := newFaultClient(t, tracker.FaultPolicy{Allow: allow}, trackertest.WithOldClient())
+	if sum, err := client.Add(faultCtx("abort=INTERNAL_ERROR"), 1, 2); err != nil || sum != 3 {
+		t.Fatalf("got (%d, %v), want the call through", sum, err)
+	}
+}
+
+func TestFaultProcessorBadSpec(t *testing.T) {
+	client, _, injections := newFaultClient(t, tracker.FaultPolicy{Allow: allow})
+	if sum, err := client.Add(faultCtx("abort=BROKEN"), 1, 2); err != nil || sum != 3 {
+		t.Fatalf("got (%d, %v), want the call through", sum, err)
+	}
+	if in := <-injections; in.method != "add" || in.fault != nil || in.err == nil {
+		t.Fatalf("got injection %+v, want the parse error", in)
+	}
+}
+
+func TestFaultProcessorDelay(t *testing.T) {
+	client, _, _ := newFaultClient(t, tracker.FaultPolicy{Allow: allow, MaxDelay: 20 * time.Millisecond})
+	start := time.Now()
+	if sum, err := client.Add(faultCtx("delay=1h"), 1, 2); err != nil || sum != 3 {
+		t.Fatalf("got (%d, %v), want 3", sum, err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond || d > 10*time.Second {
+		t.Fatalf("call took %v, want the delay capped to 20ms", d)
+	}
+}
+
+func TestFaultProcessorOneway(t *testing.T) {
+	client, handler, injections := newFaultClient(t, tracker.FaultPolicy{Allow: allow})
+	if err := client.Log(faultCtx("abort=INTERNAL_ERROR;method=log"), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// nothing is written back for the aborted oneway call, the ping reply
+	// comes next
+	if ok, err := client.Ping(faultCtx("abort=INTERNAL_ERROR;method=log")); err != nil || !ok {
+		t.Fatalf("got (%v, %v), want the ping reply", ok, err)
+	}
+	if in := <-injections; in.method != "log" {
+		t.Fatalf("got injection %+v", in)
+	}
+	if methods := handler.Methods(); len(methods) != 1 || methods[0] != "ping" {
+		t.Fatalf("handler called for %v, want ping only", methods)
+	}
+}
@@ -0,0 +1,166 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+var fuzzProtocols = []thrift.TProtocolFactory{
+	thrift.NewTBinaryProtocolFactoryDefault(),
+	thrift.NewTCompactProtocolFactory(),
+}
+
+// maxFuzzAlloc bounds the bytes allocated decoding data, the input is copied
+// a few times on its way to strings and maps.
+func maxFuzzAlloc(data []byte) uint64 {
+	return 1<<20 + 64*uint64(len(data))
+}
+
+func checkAlloc(t *testing.T, data []byte, fn func()) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > maxFuzzAlloc(data) {
+		t.Fatalf("allocated %d bytes decoding %d bytes", alloc, len(data))
+	}
+}
+
+// streamBuffer does not tell how many bytes are left, like a socket, so the
+// protocols can not check sizes read off the wire against it.
+type streamBuffer struct {
+	*thrift.TMemoryBuffer
+}
+
+func (b streamBuffer) RemainingBytes() uint64 {
+	return ^uint64(0)
+}
+
+func newStreamBuffer(data []byte) streamBuffer {
+	buf := streamBuffer{thrift.NewTMemoryBuffer()}
+	buf.Write(data)
+	return buf
+}
+
+// fieldBombs declare a 1 GiB request_id without sending it.
+var fieldBombs = [][]byte{
+	{0x0b, 0x00, 0x01, 0x40, 0x00, 0x00, 0x00},
+	{0x18, 0x80, 0x80, 0x80, 0x80, 0x04},
+}
+
+func TestLimitedProtocolFieldSize(t *testing.T) {
+	for i, data := range fieldBombs {
+		iprot := fuzzProtocols[i].GetProtocol(newStreamBuffer(data))
+		tracker := NewSimpleTracker("server").(*SimpleTracker)
+		tracker.upgradeProtocol()
+		var err error
+		checkAlloc(t, data, func() {
+			_, err = tracker.TryReadRequestHeader(iprot)
+		})
+		var x thrift.TProtocolException
+		if !errors.Is(err, ErrHeaderDecode) || !errors.As(err, &x) || x.TypeId() != thrift.SIZE_LIMIT {
+			t.Fatalf("protocol %d: got %v, want a SIZE_LIMIT decode error", i, err)
+		}
+	}
+}
+
+func fuzzSeed(f *testing.F, write func(oprot thrift.TProtocol)) {
+	for i, pf := range fuzzProtocols {
+		buf := thrift.NewTMemoryBuffer()
+		write(pf.GetProtocol(buf))
+		f.Add(buf.Bytes(), uint8(i))
+	}
+}
+
+func FuzzTryReadRequestHeader(f *testing.F) {
+	fuzzSeed(f, func(oprot thrift.TProtocol) {
+		header := tracking.NewRequestHeader()
+		header.RequestID = "b7d4f5c2-6b8e-4d7b-9a63-2f0f4f3d1e8a"
+		header.Seq = "1.1"
+		header.Meta = map[string]string{"clientA": "ping"}
+		header.Write(oprot)
+	})
+	fuzzSeed(f, func(oprot thrift.TProtocol) {
+		tracking.NewRequestHeader().Write(oprot)
+	})
+	for i, data := range fieldBombs {
+		f.Add(data, uint8(i))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, proto uint8) {
+		buf := newStreamBuffer(data)
+		iprot := fuzzProtocols[int(proto)%len(fuzzProtocols)].GetProtocol(buf)
+		tracker := NewSimpleTracker("server").(*SimpleTracker)
+		tracker.upgradeProtocol()
+
+		var ctx context.Context
+		var err error
+		checkAlloc(t, data, func() {
+			ctx, err = tracker.TryReadRequestHeader(iprot)
+		})
+		if err == nil {
+			if _, ok := ctx.Value(CtxKeyRequestID).(string); !ok {
+				t.Fatal("no request id in context")
+			}
+			return
+		}
+		if !errors.Is(err, ErrHeaderDecode) {
+			t.Fatalf("got %v, want ErrHeaderDecode", err)
+		}
+		// the stream is out of sync, later reads must fail without reading
+		left := buf.Len()
+		if _, err := tracker.TryReadRequestHeader(iprot); !errors.Is(err, ErrHeaderDecode) {
+			t.Fatalf("read after decode error: got %v, want ErrHeaderDecode", err)
+		}
+		if buf.Len() != left {
+			t.Fatal("read after decode error consumed input")
+		}
+	})
+}
+
+func FuzzTryUpgrade(f *testing.F) {
+	fuzzSeed(f, func(oprot thrift.TProtocol) {
+		args := tracking.NewUpgradeArgs_()
+		args.AppID = "client"
+		args.Write(oprot)
+		oprot.WriteMessageEnd()
+	})
+	fuzzSeed(f, func(oprot thrift.TProtocol) {
+		tracking.NewUpgradeArgs_().Write(oprot)
+	})
+	for i, data := range fieldBombs {
+		f.Add(data, uint8(i))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, proto uint8) {
+		pf := fuzzProtocols[int(proto)%len(fuzzProtocols)]
+		in, out := newStreamBuffer(data), thrift.NewTMemoryBuffer()
+		tracker := NewSimpleTracker("server")
+
+		var ok bool
+		var err thrift.TException
+		checkAlloc(t, data, func() {
+			ok, err = tracker.TryUpgrade(1, pf.GetProtocol(in), pf.GetProtocol(out))
+		})
+		if ok != (err == nil) {
+			t.Fatalf("got ok %v with error %v", ok, err)
+		}
+		if ok != tracker.RequestHeaderSupported() {
+			t.Fatalf("got ok %v, upgraded %v", ok, tracker.RequestHeaderSupported())
+		}
+		// a rejected upgrade is answered with a framed exception
+		want := thrift.REPLY
+		if !ok {
+			want = thrift.EXCEPTION
+		}
+		name, mTypeID, seqID, err2 := pf.GetProtocol(out).ReadMessageBegin()
+		if err2 != nil || name != TrackingAPIName || mTypeID != want || seqID != 1 {
+			t.Fatalf("got reply (%q, %v, %d, %v), want (%q, %v, 1)", name, mTypeID, seqID, err2, TrackingAPIName, want)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// rejection is how an interceptProcessor answers a call it rejects.
+type rejection struct {
+	x     thrift.TApplicationException // returned, and replied unless reply is set
+	reply thrift.TStruct               // result struct replied instead of x
+	close bool                         // the connection is closed afterwards
+}
+
+// interceptProcessor reads the request header and message begin of every
+// call ahead of the processor it wraps, to reject the call before it reaches
+// a handler. Calls let through are replayed to the processor, which gets the
+// context of the header through header. Upgrade calls are always let
+// through.
+type interceptProcessor struct {
+	tracker Tracker
+	// header is the tracker the processor is bound to, nil when pass holds
+	// on every upgraded connection so that no header is read here.
+	header    *headerReadTracker
+	processor thrift.TProcessor
+	// pass reports whether calls go to the processor untouched.
+	pass func() bool
+	// check returns the rejection of a call of method, nil to let it
+	// through. ctx is that of the request header, nil on connections not
+	// upgraded.
+	check func(ctx context.Context, method string) *rejection
+}
+
+// newInterceptProcessor binds the processor of newProcessor to a tracker
+// handing it the headers read by the interceptor.
+func newInterceptProcessor(t Tracker, newProcessor NewProcessorFunc) *interceptProcessor {
+	header := &headerReadTracker{Tracker: t}
+	return &interceptProcessor{
+		tracker:   t,
+		header:    header,
+		processor: newProcessor(header),
+	}
+}
+
+func (p *interceptProcessor) Process(iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	if p.pass != nil && p.pass() {
+		return p.processor.Process(iprot, oprot)
+	}
+	var ctx context.Context
+	if p.tracker.RequestHeaderSupported() {
+		var err error
+		if ctx, err = p.tracker.TryReadRequestHeader(iprot); err != nil {
+			return false, err
+		}
+	}
+	name, mTypeID, seqID, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	if name != TrackingAPIName {
+		if r := p.check(ctx, name); r != nil {
+			return p.reject(r, name, mTypeID, seqID, iprot, oprot)
+		}
+	}
+	if p.header != nil {
+		p.header.ctx = ctx
+	}
+	return p.processor.Process(&replayProtocol{
+		TProtocol: iprot,
+		name:      name,
+		mTypeID:   mTypeID,
+		seqID:     seqID,
+	}, oprot)
+}
+
+// reject skips a rejected call and answers it, oneway calls get nothing
+// back.
+func (p *interceptProcessor) reject(r *rejection, name string, mTypeID thrift.TMessageType, seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	if err := skipMessage(iprot); err != nil {
+		return false, err
+	}
+	if mTypeID == thrift.ONEWAY {
+		return !r.close, r.x
+	}
+	if r.reply != nil {
+		if err := writeReply(name, seqID, r.reply, oprot); err != nil {
+			return false, err
+		}
+		return !r.close, nil
+	}
+	if err := writeException(name, seqID, r.x, oprot); err != nil {
+		return false, err
+	}
+	return !r.close, r.x
+}
+
+// skipMessage skips the rest of a message whose begin was read.
+func skipMessage(iprot thrift.TProtocol) error {
+	if err := iprot.Skip(thrift.STRUCT); err != nil {
+		return err
+	}
+	return iprot.ReadMessageEnd()
+}
+
+func writeReply(name string, seqID int32, result thrift.TStruct, oprot thrift.TProtocol) error {
+	if err := oprot.WriteMessageBegin(name, thrift.REPLY, seqID); err != nil {
+		return err
+	}
+	if err := result.Write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
+// replayProtocol hands out a message begin already read off the wire.
+type replayProtocol struct {
+	thrift.TProtocol
+	name     string
+	mTypeID  thrift.TMessageType
+	seqID    int32
+	replayed bool
+}
+
+func (p *replayProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	if p.replayed {
+		return p.TProtocol.ReadMessageBegin()
+	}
+	p.replayed = true
+	return p.name, p.mTypeID, p.seqID, nil
+}
+
+// headerReadTracker hands the context of a request header read by an
+// interceptProcessor to the processor bound to it, which then reads no
+// header.
+type headerReadTracker struct {
+	Tracker
+	ctx context.Context
+}
+
+func (t *headerReadTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	if ctx := t.ctx; ctx != nil {
+		t.ctx = nil
+		return ctx, nil
+	}
+	return t.Tracker.TryReadRequestHeader(iprot)
+}
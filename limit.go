@@ -0,0 +1,139 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// maxContainerSize bounds the containers of a request header or upgrade args,
+// generated code preallocates them with the size read off the wire.
+const maxContainerSize = 1024
+
+// maxFieldSize bounds the strings and binaries of a request header or upgrade
+// args. The wrapped protocols allocate them with the size read off the wire
+// unless the transport tells how many bytes are left, which sockets do not.
+const maxFieldSize = 64 << 10
+
+// limitedProtocol guards the decoding of untrusted tracker structs.
+type limitedProtocol struct {
+	thrift.TProtocol
+}
+
+func checkContainerSize(size int) error {
+	if size > maxContainerSize {
+		return thrift.NewTProtocolExceptionWithType(thrift.SIZE_LIMIT,
+			fmt.Errorf("container size %d exceeds %d", size, maxContainerSize))
+	}
+	return nil
+}
+
+func (p *limitedProtocol) ReadMapBegin() (thrift.TType, thrift.TType, int, error) {
+	kType, vType, size, err := p.TProtocol.ReadMapBegin()
+	if err == nil {
+		err = checkContainerSize(size)
+	}
+	return kType, vType, size, err
+}
+
+func (p *limitedProtocol) ReadListBegin() (thrift.TType, int, error) {
+	eType, size, err := p.TProtocol.ReadListBegin()
+	if err == nil {
+		err = checkContainerSize(size)
+	}
+	return eType, size, err
+}
+
+func (p *limitedProtocol) ReadSetBegin() (thrift.TType, int, error) {
+	eType, size, err := p.TProtocol.ReadSetBegin()
+	if err == nil {
+		err = checkContainerSize(size)
+	}
+	return eType, size, err
+}
+
+// Skip goes through the checks above, unlike the Skip of the wrapped protocol.
+func (p *limitedProtocol) Skip(fieldType thrift.TType) error {
+	return thrift.SkipDefaultDepth(p, fieldType)
+}
+
+func (p *limitedProtocol) ReadString() (string, error) {
+	trans, size, err := p.readFieldSize()
+	if trans == nil {
+		return p.TProtocol.ReadString()
+	}
+	if err != nil {
+		return "", err
+	}
+	buf, err := readFieldBody(trans, size)
+	return string(buf), err
+}
+
+func (p *limitedProtocol) ReadBinary() ([]byte, error) {
+	trans, size, err := p.readFieldSize()
+	if trans == nil {
+		return p.TProtocol.ReadBinary()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readFieldBody(trans, size)
+}
+
+// readFieldSize reads and checks the size of a string or binary on the
+// binary and compact protocols, trans is the transport to read the bytes
+// from. It reads nothing and returns a nil trans for other protocols, whose
+// fields take as many bytes on the wire as they allocate.
+func (p *limitedProtocol) readFieldSize() (trans thrift.TTransport, size int, err error) {
+	var n int64
+	switch inner := innerProtocol(p.TProtocol).(type) {
+	case *thrift.TBinaryProtocol:
+		var i32 int32
+		i32, err = inner.ReadI32()
+		n, trans = int64(i32), inner.Transport()
+	case *thrift.TCompactProtocol:
+		n, err = readVarint32(inner)
+		trans = inner.Transport()
+	default:
+		return nil, 0, nil
+	}
+	if err != nil {
+		return trans, 0, err
+	}
+	if n < 0 {
+		return trans, 0, thrift.NewTProtocolExceptionWithType(thrift.NEGATIVE_SIZE,
+			fmt.Errorf("negative field size %d", n))
+	}
+	if n > maxFieldSize {
+		return trans, 0, thrift.NewTProtocolExceptionWithType(thrift.SIZE_LIMIT,
+			fmt.Errorf("field size %d exceeds %d", n, maxFieldSize))
+	}
+	return trans, int(n), nil
+}
+
+func readFieldBody(trans thrift.TTransport, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(trans, buf); err != nil {
+		return nil, thrift.NewTProtocolException(err)
+	}
+	return buf, nil
+}
+
+// readVarint32 reads the varint of a compact protocol size, as a signed 32
+// bits integer like the compact protocol does.
+func readVarint32(p *thrift.TCompactProtocol) (int64, error) {
+	var v uint64
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := p.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(byte(b)&0x7f) << shift
+		if byte(b)&0x80 == 0 {
+			return int64(int32(v)), nil
+		}
+	}
+	return 0, thrift.NewTProtocolExceptionWithType(thrift.INVALID_DATA, errors.New("varint overflows 32 bits"))
+}
@@ -0,0 +1,306 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ErrClientClosed is returned by calls on a closed MuxClient.
+var ErrClientClosed = errors.New("tracker: mux client closed")
+
+// Caller sends calls with the generated args and result structs. MuxClient,
+// ClientCaller, Balancer and ShadowClient implement it.
+type Caller interface {
+	Call(ctx context.Context, method string, args, result thrift.TStruct) error
+	Oneway(ctx context.Context, method string, args thrift.TStruct) error
+}
+
+// MuxClient multiplexes concurrent calls over one connection. Each call writes
+// its own request header from its context, replies are matched back to calls
+// by seqid and may arrive in any order.
+//
+// Calls take the generated args and result structs, e.g.
+//
+//	args := calculator.CalculatorServiceAddArgs{Num1: 1, Num2: 2}
+//	result := calculator.CalculatorServiceAddResult{}
+//	err := client.Call(ctx, "add", &args, &result)
+type MuxClient struct {
+	tracker Tracker
+	trans   thrift.TTransport
+	iprot   thrift.TProtocol
+	oprot   thrift.TProtocol
+
+	wmu   sync.Mutex // held while a call is written
+	seqID int32
+
+	mu      sync.Mutex
+	pending map[int32]*muxCall
+	closed  bool
+	err     error
+	stopped chan struct{}
+}
+
+type muxCall struct {
+	method string
+	result thrift.TStruct
+	done   chan error
+}
+
+// NewMuxClient negotiates over trans like a generated client and starts
+// reading replies, trans must be open.
+func NewMuxClient(t Tracker, trans thrift.TTransport, f thrift.TProtocolFactory) (*MuxClient, error) {
+	iprot := f.GetProtocol(trans)
+	oprot := f.GetProtocol(trans)
+	c := &MuxClient{
+		tracker: t,
+		trans:   trans,
+		iprot:   iprot,
+		oprot:   oprot,
+		pending: make(map[int32]*muxCall),
+		stopped: make(chan struct{}),
+	}
+	c.seqID++
+	if err := t.Negotiation(c.seqID, iprot, oprot); err != nil {
+		return nil, err
+	}
+	go c.recvLoop()
+	return c, nil
+}
+
+// Call sends method with args and reads its reply into result. Cancelling ctx
+// abandons the call, its reply is skipped when it arrives.
+func (c *MuxClient) Call(ctx context.Context, method string, args, result thrift.TStruct) error {
+	call := &muxCall{method: method, result: result, done: make(chan error, 1)}
+	seqID, err := c.send(ctx, method, thrift.CALL, args, call)
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-call.done:
+		return err
+	case <-ctx.Done():
+	}
+	c.mu.Lock()
+	if c.pending[seqID] == call {
+		delete(c.pending, seqID)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+	c.mu.Unlock()
+	// the reply is being read already
+	return <-call.done
+}
+
+// Oneway sends method with args without waiting for anything back.
+func (c *MuxClient) Oneway(ctx context.Context, method string, args thrift.TStruct) error {
+	_, err := c.send(ctx, method, thrift.ONEWAY, args, nil)
+	return err
+}
+
+func (c *MuxClient) send(ctx context.Context, method string, mTypeID thrift.TMessageType, args thrift.TStruct, call *muxCall) (int32, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return 0, err
+	}
+	c.seqID++
+	seqID := c.seqID
+	if call != nil {
+		// registered first, the reply may come before send returns
+		c.pending[seqID] = call
+	}
+	c.mu.Unlock()
+
+	err := c.tracker.TryWriteRequestHeader(ctx, c.oprot)
+	if err == nil {
+		err = c.oprot.WriteMessageBegin(method, mTypeID, seqID)
+	}
+	if err == nil {
+		err = args.Write(c.oprot)
+	}
+	if err == nil {
+		err = c.oprot.WriteMessageEnd()
+	}
+	if err == nil {
+		err = c.oprot.Flush()
+	}
+	if err != nil {
+		// a partly written call leaves the stream unusable
+		c.mu.Lock()
+		delete(c.pending, seqID)
+		c.mu.Unlock()
+		c.interrupt()
+		return 0, err
+	}
+	return seqID, nil
+}
+
+func (c *MuxClient) recvLoop() {
+	err := c.recv()
+	c.mu.Lock()
+	if c.closed {
+		err = ErrClientClosed
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, call := range pending {
+		call.done <- err
+	}
+	close(c.stopped)
+}
+
+func (c *MuxClient) recv() error {
+	for {
+		method, mTypeID, seqID, err := c.iprot.ReadMessageBegin()
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		call, ok := c.pending[seqID]
+		delete(c.pending, seqID)
+		c.mu.Unlock()
+		if !ok {
+			// abandoned by its caller
+			if err := c.iprot.Skip(thrift.STRUCT); err != nil {
+				return err
+			}
+			if err := c.iprot.ReadMessageEnd(); err != nil {
+				return err
+			}
+			continue
+		}
+		callErr, err := readReply(c.iprot, call.method, call.result, method, mTypeID)
+		if err != nil {
+			call.done <- err
+			return err
+		}
+		call.done <- callErr
+	}
+}
+
+// readReply reads the reply of a call of method into result, callErr is the
+// error of the call alone while err breaks the connection.
+func readReply(iprot thrift.TProtocol, method string, result thrift.TStruct, name string, mTypeID thrift.TMessageType) (callErr, err error) {
+	switch {
+	case name != method:
+		callErr = thrift.NewTApplicationException(thrift.WRONG_METHOD_NAME, method+" failed: wrong method name")
+		err = iprot.Skip(thrift.STRUCT)
+	case mTypeID == thrift.EXCEPTION:
+		x := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "Unknown Exception")
+		callErr, err = x.Read(iprot)
+	case mTypeID != thrift.REPLY:
+		callErr = thrift.NewTApplicationException(thrift.INVALID_MESSAGE_TYPE_EXCEPTION, method+" failed: invalid message type")
+		err = iprot.Skip(thrift.STRUCT)
+	default:
+		err = result.Read(iprot)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return callErr, iprot.ReadMessageEnd()
+}
+
+// Close closes the connection, pending calls fail with ErrClientClosed.
+func (c *MuxClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	err := c.interrupt()
+	<-c.stopped
+	c.wmu.Lock()
+	c.trans.Close()
+	c.wmu.Unlock()
+	return err
+}
+
+// interrupt unblocks the reader. Closing a TSocket while it is read races on
+// its conn, so its net.Conn is closed instead when there is one.
+func (c *MuxClient) interrupt() error {
+	if s, ok := c.trans.(interface{ Conn() net.Conn }); ok && s.Conn() != nil {
+		return s.Conn().Close()
+	}
+	return c.trans.Close()
+}
+
+// ClientCaller makes calls one at a time over the protocols of a generated
+// client, once negotiated, so that calls of its connection can be sent
+// through a Caller, e.g. mirrored with a ShadowClient:
+//
+//	client, err := calculator.NewCalculatorServiceClientFactory(t, trans, f)
+//	caller := tracker.NewClientCaller(client.Tracker, client.InputProtocol, client.OutputProtocol)
+//
+// The generated client must not be used meanwhile. As with generated
+// clients, ctx only gives the request header, calls are bounded by the
+// socket timeout.
+type ClientCaller struct {
+	tracker Tracker
+	iprot   thrift.TProtocol
+	oprot   thrift.TProtocol
+
+	mu    sync.Mutex
+	seqID int32
+}
+
+func NewClientCaller(t Tracker, iprot, oprot thrift.TProtocol) *ClientCaller {
+	return &ClientCaller{tracker: t, iprot: iprot, oprot: oprot}
+}
+
+// Call sends method with args and reads its reply into result.
+func (c *ClientCaller) Call(ctx context.Context, method string, args, result thrift.TStruct) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seqID, err := c.send(ctx, method, thrift.CALL, args)
+	if err != nil {
+		return err
+	}
+	name, mTypeID, replySeqID, err := c.iprot.ReadMessageBegin()
+	if err != nil {
+		return err
+	}
+	if replySeqID != seqID {
+		if err := skipMessage(c.iprot); err != nil {
+			return err
+		}
+		return thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID, method+" failed: out of sequence response")
+	}
+	callErr, err := readReply(c.iprot, method, result, name, mTypeID)
+	if err != nil {
+		return err
+	}
+	return callErr
+}
+
+// Oneway sends method with args without waiting for anything back.
+func (c *ClientCaller) Oneway(ctx context.Context, method string, args thrift.TStruct) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.send(ctx, method, thrift.ONEWAY, args)
+	return err
+}
+
+func (c *ClientCaller) send(ctx context.Context, method string, mTypeID thrift.TMessageType, args thrift.TStruct) (int32, error) {
+	if err := c.tracker.TryWriteRequestHeader(ctx, c.oprot); err != nil {
+		return 0, err
+	}
+	c.seqID++
+	if err := c.oprot.WriteMessageBegin(method, mTypeID, c.seqID); err != nil {
+		return 0, err
+	}
+	if err := args.Write(c.oprot); err != nil {
+		return 0, err
+	}
+	if err := c.oprot.WriteMessageEnd(); err != nil {
+		return 0, err
+	}
+	return c.seqID, c.oprot.Flush()
+}
@@ -0,0 +1,233 @@
+package tracker_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+func add(c *tracker.MuxClient, ctx context.Context, num1, num2 int32) (int32, error) {
+	args := calculator.CalculatorServiceAddArgs{Num1: num1, Num2: num2}
+	result := calculator.CalculatorServiceAddResult{}
+	if err := c.Call(ctx, "add", &args, &result); err != nil {
+		return 0, err
+	}
+	return result.GetSuccess(), nil
+}
+
+func TestMuxClientConcurrentCalls(t *testing.T) {
+	addr := serveFactory(t, tracker.NewProcessorFactory(
+		tracker.NewSimpleTrackerFactory("server"),
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, &signHandler{})
+		},
+	))
+	c, err := tracker.NewMuxClient(tracker.NewSimpleTracker("client"), dial(t, addr), thrift.NewTBinaryProtocolFactoryDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for g := int32(0); g < 8; g++ {
+		wg.Add(1)
+		go func(g int32) {
+			defer wg.Done()
+			for i := int32(0); i < 50; i++ {
+				ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, fmt.Sprint(g, i))
+				sum, err := add(c, ctx, g, i)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// positive sums prove every call carried its header
+				if sum != g+i {
+					t.Errorf("got %d, want %d", sum, g+i)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// rawServer answers calls by hand over an in-memory pipe, for replies in any
+// order or none at all. Its client is untracked.
+type rawServer struct {
+	prot thrift.TProtocol
+}
+
+func newRawServer(t *testing.T) (*tracker.MuxClient, *rawServer) {
+	client, server := trackertest.NewPipe()
+	t.Cleanup(func() { server.Close() })
+	f := thrift.NewTBinaryProtocolFactoryDefault()
+	c, err := tracker.NewMuxClient(trackertest.NewNoopTracker("client"), client, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, &rawServer{prot: f.GetProtocol(server)}
+}
+
+func (s *rawServer) readAdd(t *testing.T) (int32, *calculator.CalculatorServiceAddArgs) {
+	name, _, seqID, err := s.prot.ReadMessageBegin()
+	if err != nil || name != "add" {
+		t.Fatalf("got call %q, %v", name, err)
+	}
+	args := &calculator.CalculatorServiceAddArgs{}
+	if err := args.Read(s.prot); err != nil {
+		t.Fatal(err)
+	}
+	s.prot.ReadMessageEnd()
+	return seqID, args
+}
+
+func (s *rawServer) replyAdd(seqID, sum int32) error {
+	s.prot.WriteMessageBegin("add", thrift.REPLY, seqID)
+	(&calculator.CalculatorServiceAddResult{Success: &sum}).Write(s.prot)
+	s.prot.WriteMessageEnd()
+	return s.prot.Flush()
+}
+
+type addOutcome struct {
+	sum int32
+	err error
+}
+
+func goAdd(c *tracker.MuxClient, ctx context.Context, num1, num2 int32) chan addOutcome {
+	done := make(chan addOutcome, 1)
+	go func() {
+		sum, err := add(c, ctx, num1, num2)
+		done <- addOutcome{sum, err}
+	}()
+	return done
+}
+
+func TestMuxClientReplyOrder(t *testing.T) {
+	c, s := newRawServer(t)
+	first := goAdd(c, context.Background(), 1, 1)
+	seq1, _ := s.readAdd(t)
+	second := goAdd(c, context.Background(), 2, 2)
+	seq2, _ := s.readAdd(t)
+	if err := s.replyAdd(seq2, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-second; got.err != nil || got.sum != 4 {
+		t.Fatalf("second call: got %+v", got)
+	}
+	if err := s.replyAdd(seq1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-first; got.err != nil || got.sum != 2 {
+		t.Fatalf("first call: got %+v", got)
+	}
+}
+
+func TestMuxClientCancel(t *testing.T) {
+	c, s := newRawServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := goAdd(c, ctx, 1, 1)
+	seq1, _ := s.readAdd(t)
+	cancel()
+	if got := <-abandoned; got.err != context.Canceled {
+		t.Fatalf("got %+v, want context.Canceled", got)
+	}
+	// the late reply is skipped, the next call gets its own
+	next := goAdd(c, context.Background(), 2, 2)
+	seq2, _ := s.readAdd(t)
+	if err := s.replyAdd(seq1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.replyAdd(seq2, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-next; got.err != nil || got.sum != 4 {
+		t.Fatalf("got %+v, want 4", got)
+	}
+}
+
+// blockingResult holds the reading of a reply until released.
+type blockingResult struct {
+	calculator.CalculatorServiceAddResult
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingResult) Read(iprot thrift.TProtocol) error {
+	close(r.reading)
+	<-r.release
+	return r.CalculatorServiceAddResult.Read(iprot)
+}
+
+func TestMuxClientCancelWhileReading(t *testing.T) {
+	c, s := newRawServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := &blockingResult{reading: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Call(ctx, "add", &calculator.CalculatorServiceAddArgs{Num1: 1, Num2: 1}, result)
+	}()
+	seqID, _ := s.readAdd(t)
+	// the pipe holds the reply writer until the reader took all of it
+	go s.replyAdd(seqID, 2)
+	<-result.reading
+	cancel()
+	close(result.release)
+	// the reply was being read, the call waits for it rather than racing
+	// the reader on result
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if result.GetSuccess() != 2 {
+		t.Fatalf("got %d, want 2", result.GetSuccess())
+	}
+}
+
+func TestMuxClientOneway(t *testing.T) {
+	handler := &blockingLogHandler{logged: make(chan context.Context, 1), release: make(chan struct{})}
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, handler)
+	})
+	c, err := tracker.NewMuxClient(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, fixtureRequestID)
+	if err := c.Oneway(ctx, "log", &calculator.CalculatorServiceLogArgs{Message: "audit"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := (<-handler.logged).Value(tracker.CtxKeyRequestID); got != fixtureRequestID {
+		t.Fatalf("got request id %v, want %v", got, fixtureRequestID)
+	}
+	close(handler.release)
+	if sum, err := add(c, ctx, 1, 2); err != nil || sum != 3 {
+		t.Fatalf("add after oneway: got (%d, %v)", sum, err)
+	}
+	if n := len(h.Headers()); n != 2 {
+		t.Fatalf("server read %d headers, want 2", n)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the server stopped at a header of a call never sent
+	h.Close()
+}
+
+func TestMuxClientClose(t *testing.T) {
+	c, s := newRawServer(t)
+	pending := goAdd(c, context.Background(), 1, 1)
+	s.readAdd(t)
+	c.Close()
+	if got := <-pending; got.err != tracker.ErrClientClosed {
+		t.Fatalf("pending call: got %+v, want ErrClientClosed", got)
+	}
+	if _, err := add(c, context.Background(), 1, 1); err != tracker.ErrClientClosed {
+		t.Fatalf("call after Close: got %v, want ErrClientClosed", err)
+	}
+}
@@ -0,0 +1,108 @@
+package tracker
+
+import (
+	"errors"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// PipelinedProtocol runs deferred reads, e.g. of the upgrade reply, right
+// before the next message is read.
+type PipelinedProtocol struct {
+	thrift.TProtocol
+	pending []func() error
+}
+
+type pipelinedProtocolFactory struct {
+	factory thrift.TProtocolFactory
+}
+
+// NewPipelinedProtocolFactory wraps f, a client built with the returned
+// factory can negotiate through a pipelined tracker.
+func NewPipelinedProtocolFactory(f thrift.TProtocolFactory) thrift.TProtocolFactory {
+	return &pipelinedProtocolFactory{factory: f}
+}
+
+func (f *pipelinedProtocolFactory) GetProtocol(trans thrift.TTransport) thrift.TProtocol {
+	return NewPipelinedProtocol(f.factory.GetProtocol(trans))
+}
+
+func NewPipelinedProtocol(p thrift.TProtocol) *PipelinedProtocol {
+	return &PipelinedProtocol{TProtocol: p}
+}
+
+// Defer registers fn to be called before the next ReadMessageBegin.
+func (p *PipelinedProtocol) Defer(fn func() error) {
+	p.pending = append(p.pending, fn)
+}
+
+func (p *PipelinedProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	for len(p.pending) > 0 {
+		fn := p.pending[0]
+		p.pending = p.pending[1:]
+		if err := fn(); err != nil {
+			p.pending = nil
+			return "", thrift.INVALID_TMESSAGE_TYPE, 0, err
+		}
+	}
+	return p.TProtocol.ReadMessageBegin()
+}
+
+type pipelinedTracker struct {
+	Tracker
+}
+
+// NewPipelinedTracker wraps t so that its upgrade call is flushed together
+// with the first request instead of costing a round trip of its own. The
+// request header is written before the peer confirms the upgrade, so only use
+// it against peers known to support tracker: an untracked peer fails the
+// first call with an *Error of kind ErrPeerUnsupported. The tracker is then
+// downgraded and the transport reopened, later calls go out untracked.
+//
+// Pipelining needs t to implement PipelinedHandShaker and the client input
+// protocol to come from NewPipelinedProtocolFactory, otherwise it falls back
+// to a plain negotiation. Oneway calls read nothing, the upgrade reply stays
+// unread until the first reply of a two-way call.
+func NewPipelinedTracker(t Tracker) Tracker {
+	return &pipelinedTracker{Tracker: t}
+}
+
+func (t *pipelinedTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	ok, err := pipelineNegotiation(t.Tracker, curSeqID, iprot, oprot, nil)
+	if ok || err != nil {
+		return err
+	}
+	return t.Tracker.Negotiation(curSeqID, iprot, oprot)
+}
+
+// pipelineNegotiation reports false when t or iprot can not be pipelined,
+// recvd is called with the outcome of the deferred upgrade reply.
+func pipelineNegotiation(t Tracker, curSeqID int32, iprot, oprot thrift.TProtocol, recvd func(error)) (bool, error) {
+	ph, ok := t.(PipelinedHandShaker)
+	if !ok {
+		return false, nil
+	}
+	p, ok := iprot.(*PipelinedProtocol)
+	if !ok {
+		return false, nil
+	}
+	recv, err := ph.PipelinedNegotiation(curSeqID, oprot)
+	if err != nil {
+		return true, err
+	}
+	p.Defer(func() error {
+		err := recv(p.TProtocol)
+		if recvd != nil {
+			recvd(err)
+		}
+		if errors.Is(err, ErrPeerUnsupported) {
+			// the peer read the request header as a message, drop what it
+			// answered and start over on a fresh connection
+			if rerr := reopen(p, oprot); rerr != nil {
+				return rerr
+			}
+		}
+		return err
+	})
+	return true, nil
+}
@@ -0,0 +1,101 @@
+package tracker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+func TestPipelinedProtocolDefer(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	wprot := thrift.NewTBinaryProtocolTransport(buf)
+	wprot.WriteMessageBegin("add", thrift.REPLY, 2)
+	wprot.WriteMessageBegin("add", thrift.REPLY, 3)
+
+	p := tracker.NewPipelinedProtocol(thrift.NewTBinaryProtocolTransport(buf))
+	var calls []int
+	p.Defer(func() error { calls = append(calls, 1); return nil })
+	p.Defer(func() error { calls = append(calls, 2); return nil })
+	if _, _, seqID, err := p.ReadMessageBegin(); err != nil || seqID != 2 {
+		t.Fatalf("got (%d, %v), want seqid 2", seqID, err)
+	}
+	if len(calls) != 2 || calls[0] != 1 || calls[1] != 2 {
+		t.Fatalf("deferred calls ran as %v", calls)
+	}
+
+	// a failing deferred read fails the message and drops the ones after it
+	errDeferred := errors.New("deferred")
+	p.Defer(func() error { return errDeferred })
+	p.Defer(func() error { t.Error("ran after a failure"); return nil })
+	if _, _, _, err := p.ReadMessageBegin(); err != errDeferred {
+		t.Fatalf("got %v, want %v", err, errDeferred)
+	}
+	if _, _, seqID, err := p.ReadMessageBegin(); err != nil || seqID != 3 {
+		t.Fatalf("got (%d, %v), want seqid 3", seqID, err)
+	}
+}
+
+func newPipelinedClient(t *testing.T, addr string) *calculator.CalculatorServiceClient {
+	f := tracker.NewPipelinedProtocolFactory(thrift.NewTBinaryProtocolFactoryDefault())
+	client, err := calculator.NewCalculatorServiceClientFactory(
+		tracker.NewPipelinedTracker(tracker.NewSimpleTracker("client")), dial(t, addr), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestPipelinedTracker(t *testing.T) {
+	addr := serveFactory(t, tracker.NewProcessorFactory(
+		tracker.NewSimpleTrackerFactory("server"),
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, &signHandler{})
+		},
+	))
+	client := newPipelinedClient(t, addr)
+	if !client.Tracker.RequestHeaderSupported() {
+		t.Fatal("not upgraded before the first call")
+	}
+	// the first reply is read after the deferred upgrade reply
+	for i := int32(1); i <= 3; i++ {
+		sum, err := client.Add(context.Background(), i, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != i+1 {
+			t.Fatalf("got %d, want %d, header lost", sum, i+1)
+		}
+	}
+}
+
+func TestPipelinedTrackerUntrackedPeer(t *testing.T) {
+	addr := serveFactory(t, tracker.NewProcessorFactory(
+		func() tracker.Tracker { return trackertest.NewNoopTracker("server") },
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, &signHandler{})
+		},
+	))
+	client := newPipelinedClient(t, addr)
+	_, err := client.Add(context.Background(), 1, 1)
+	if !errors.Is(err, tracker.ErrPeerUnsupported) {
+		t.Fatalf("got %v, want ErrPeerUnsupported", err)
+	}
+	if client.Tracker.RequestHeaderSupported() {
+		t.Fatal("still upgraded after the peer refused")
+	}
+	// the transport was reopened, later calls go out untracked
+	for i := int32(1); i <= 3; i++ {
+		sum, err := client.Add(context.Background(), i, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != -(i + 1) {
+			t.Fatalf("got %d, want %d", sum, -(i + 1))
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package tracker
+
+import (
+	"net"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// NegotiationPolicy controls how a policy tracker handles a failing handshake.
+type NegotiationPolicy struct {
+	// Timeout bounds every handshake attempt, zero leaves it to the socket
+	// timeout. A timed out connection is closed and reopened. A handshake
+	// over a TSocket, or a transport of NewPolicyTransport, is interrupted
+	// with a deadline. Other transports, such as a TBufferedTransport over
+	// a socket, are closed while the handshake reads them and must allow it.
+	Timeout time.Duration
+	// Retries is the number of extra attempts after a failed one.
+	Retries int
+	// Degrade falls back to untracked mode on a fresh connection once all
+	// attempts failed, instead of failing the client creation. Transports
+	// are reopened as they are, only those of NewPolicyTransport are built
+	// anew, with nothing left in their buffers.
+	Degrade bool
+	// OnResult is called with the outcome of every negotiation.
+	OnResult func(NegotiationResult)
+}
+
+type NegotiationResult struct {
+	Name     string
+	Attempts int
+	Err      error // last error, nil if the handshake finally succeeded
+	Upgraded bool
+	Degraded bool
+}
+
+type policyTracker struct {
+	Tracker
+	policy NegotiationPolicy
+}
+
+// NewPolicyTracker wraps t so that its negotiation follows policy.
+func NewPolicyTracker(t Tracker, policy NegotiationPolicy) Tracker {
+	return &policyTracker{
+		Tracker: t,
+		policy:  policy,
+	}
+}
+
+func (t *policyTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	result := NegotiationResult{Name: t.Name()}
+	for {
+		result.Attempts++
+		if result.Err = t.negotiate(curSeqID, iprot, oprot); result.Err == nil {
+			break
+		}
+		last := result.Attempts > t.policy.Retries
+		if last && !t.policy.Degrade {
+			break
+		}
+		// the stream is out of sync after a failed handshake, start over
+		if err := reopen(iprot, oprot); err != nil {
+			result.Err = err
+			break
+		}
+		if last {
+			break
+		}
+	}
+	err := result.Err
+	if err != nil && t.policy.Degrade && iprot.Transport().IsOpen() {
+		result.Degraded = true
+		err = nil
+	}
+	result.Upgraded = err == nil && t.Tracker.RequestHeaderSupported()
+	if t.policy.OnResult != nil {
+		t.policy.OnResult(result)
+	}
+	return err
+}
+
+func (t *policyTracker) negotiate(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	if t.policy.Timeout <= 0 {
+		return t.Tracker.Negotiation(curSeqID, iprot, oprot)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- t.Tracker.Negotiation(curSeqID, iprot, oprot)
+	}()
+	timer := time.NewTimer(t.policy.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+	// unblock the handshake, it must not touch the protocols once we return.
+	// A socket deadline is reset by the next read, so it is set again until
+	// the handshake gave up.
+	for {
+		interrupt(iprot.Transport())
+		if oprot.Transport() != iprot.Transport() {
+			interrupt(oprot.Transport())
+		}
+		select {
+		case <-done:
+			return thrift.NewTTransportException(thrift.TIMED_OUT,
+				"tracker negotiation failed: handshake timed out")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// interrupt fails the pending and next I/O on trans of another goroutine.
+// The connection of a socket gets a deadline in the past, which is safe while
+// it is read and leaves the socket to be closed as usual, other transports
+// are closed.
+func interrupt(trans thrift.TTransport) {
+	if s, ok := trans.(interface{ Conn() net.Conn }); ok {
+		if conn := s.Conn(); conn != nil {
+			conn.SetDeadline(time.Now())
+			return
+		}
+	}
+	trans.Close()
+}
+
+type policyTransport struct {
+	thrift.TTransport
+	socket  *thrift.TSocket
+	factory thrift.TTransportFactory
+}
+
+// NewPolicyTransport returns the transport factory wraps socket in, e.g. a
+// TBufferedTransport or TFramedTransport, for clients whose tracker follows a
+// NegotiationPolicy. A timed out handshake over it is interrupted with a
+// deadline on socket, and opening it wraps socket anew, so that a handshake
+// given up leaves nothing half read or written in the buffers.
+func NewPolicyTransport(socket *thrift.TSocket, factory thrift.TTransportFactory) thrift.TTransport {
+	return &policyTransport{
+		TTransport: factory.GetTransport(socket),
+		socket:     socket,
+		factory:    factory,
+	}
+}
+
+func (t *policyTransport) Open() error {
+	if err := t.socket.Open(); err != nil {
+		return err
+	}
+	t.TTransport = t.factory.GetTransport(t.socket)
+	return nil
+}
+
+// Conn returns the connection of the socket, for interrupt.
+func (t *policyTransport) Conn() net.Conn {
+	return t.socket.Conn()
+}
+
+func closeTransports(iprot, oprot thrift.TProtocol) {
+	iprot.Transport().Close()
+	if oprot.Transport() != iprot.Transport() {
+		oprot.Transport().Close()
+	}
+}
+
+func reopen(iprot, oprot thrift.TProtocol) error {
+	closeTransports(iprot, oprot)
+	if err := iprot.Transport().Open(); err != nil {
+		return err
+	}
+	if trans := oprot.Transport(); !trans.IsOpen() {
+		return trans.Open()
+	}
+	return nil
+}
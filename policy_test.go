@@ -0,0 +1,241 @@
+package tracker_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+)
+
+// stallingListener accepts connections and never answers the first stall of
+// them, later ones are forwarded to upstream if set.
+func stallingListener(t *testing.T, stall int32, upstream string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for accepted := int32(1); ; accepted++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			if accepted <= stall || upstream == "" {
+				go io.Copy(ioutil.Discard, conn)
+				continue
+			}
+			up, err := net.Dial("tcp", upstream)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			mu.Lock()
+			conns = append(conns, up)
+			mu.Unlock()
+			go io.Copy(up, conn)
+			go io.Copy(conn, up)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func negotiate(t *testing.T, addr string, policy tracker.NegotiationPolicy) (*calculator.CalculatorServiceClient, tracker.NegotiationResult, error) {
+	var result tracker.NegotiationResult
+	policy.OnResult = func(r tracker.NegotiationResult) { result = r }
+	client, err := calculator.NewCalculatorServiceClientFactory(
+		tracker.NewPolicyTracker(tracker.NewSimpleTracker("client"), policy),
+		dial(t, addr), thrift.NewTBinaryProtocolFactoryDefault())
+	return client, result, err
+}
+
+func TestPolicyTrackerTimeout(t *testing.T) {
+	addr := stallingListener(t, 1<<30, "")
+	start := time.Now()
+	_, result, err := negotiate(t, addr, tracker.NegotiationPolicy{
+		Timeout: 50 * time.Millisecond,
+		Retries: 1,
+	})
+	if x, ok := err.(thrift.TTransportException); !ok || x.TypeId() != thrift.TIMED_OUT {
+		t.Fatalf("got %v, want a TIMED_OUT transport exception", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v", elapsed)
+	}
+	if result.Name != "client" || result.Attempts != 2 || result.Err != err || result.Upgraded || result.Degraded {
+		t.Fatalf("got result %+v", result)
+	}
+}
+
+func TestPolicyTrackerDegrade(t *testing.T) {
+	addr := stallingListener(t, 1<<30, "")
+	client, result, err := negotiate(t, addr, tracker.NegotiationPolicy{
+		Timeout: 50 * time.Millisecond,
+		Degrade: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Degraded || result.Upgraded || result.Attempts != 1 || result.Err == nil {
+		t.Fatalf("got result %+v", result)
+	}
+	if !client.Transport.IsOpen() || client.Tracker.RequestHeaderSupported() {
+		t.Fatal("not degraded to an open untracked connection")
+	}
+}
+
+func TestPolicyTrackerRetry(t *testing.T) {
+	upstream := serveFactory(t, tracker.NewProcessorFactory(
+		tracker.NewSimpleTrackerFactory("server"),
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, &signHandler{})
+		},
+	))
+	addr := stallingListener(t, 1, upstream)
+	client, result, err := negotiate(t, addr, tracker.NegotiationPolicy{
+		Timeout: 50 * time.Millisecond,
+		Retries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Upgraded || result.Attempts != 2 || result.Err != nil {
+		t.Fatalf("got result %+v", result)
+	}
+	// the second attempt ran on a fresh connection, calls go through tracked
+	sum, err := client.Add(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("got %d, want 3", sum)
+	}
+}
+
+// negotiateOver negotiates over a transport of tracker.NewPolicyTransport.
+func negotiateOver(t *testing.T, addr string, f thrift.TTransportFactory, policy tracker.NegotiationPolicy) (*calculator.CalculatorServiceClient, tracker.NegotiationResult, error) {
+	socket, err := thrift.NewTSocket(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans := tracker.NewPolicyTransport(socket, f)
+	if err := trans.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trans.Close() })
+	var result tracker.NegotiationResult
+	policy.OnResult = func(r tracker.NegotiationResult) { result = r }
+	client, err := calculator.NewCalculatorServiceClientFactory(
+		tracker.NewPolicyTracker(tracker.NewSimpleTracker("client"), policy),
+		trans, thrift.NewTBinaryProtocolFactoryDefault())
+	return client, result, err
+}
+
+func serveOver(t *testing.T, f thrift.TTransportFactory) string {
+	socket, err := thrift.NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := thrift.NewTSimpleServerFactory4(tracker.NewProcessorFactory(
+		tracker.NewSimpleTrackerFactory("server"),
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, &signHandler{})
+		},
+	), socket, f, thrift.NewTBinaryProtocolFactoryDefault())
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.AcceptLoop()
+	t.Cleanup(func() { server.Stop() })
+	return socket.Addr().String()
+}
+
+func TestPolicyTransport(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		f    thrift.TTransportFactory
+	}{
+		{"buffered", thrift.NewTBufferedTransportFactory(4096)},
+		{"framed", thrift.NewTFramedTransportFactory(thrift.NewTTransportFactory())},
+	} {
+		t.Run(c.name+"/timeout", func(t *testing.T) {
+			addr := stallingListener(t, 1<<30, "")
+			start := time.Now()
+			_, result, err := negotiateOver(t, addr, c.f, tracker.NegotiationPolicy{
+				Timeout: 50 * time.Millisecond,
+				Retries: 1,
+			})
+			if x, ok := err.(thrift.TTransportException); !ok || x.TypeId() != thrift.TIMED_OUT {
+				t.Fatalf("got %v, want a TIMED_OUT transport exception", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("took %v", elapsed)
+			}
+			if result.Attempts != 2 || result.Err != err {
+				t.Fatalf("got result %+v", result)
+			}
+		})
+
+		t.Run(c.name+"/retry", func(t *testing.T) {
+			addr := stallingListener(t, 1, serveOver(t, c.f))
+			client, result, err := negotiateOver(t, addr, c.f, tracker.NegotiationPolicy{
+				Timeout: 50 * time.Millisecond,
+				Retries: 1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Upgraded || result.Attempts != 2 {
+				t.Fatalf("got result %+v", result)
+			}
+			if sum, err := client.Add(context.Background(), 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want 3", sum, err)
+			}
+		})
+
+		t.Run(c.name+"/degrade", func(t *testing.T) {
+			addr := stallingListener(t, 1, serveOver(t, c.f))
+			client, result, err := negotiateOver(t, addr, c.f, tracker.NegotiationPolicy{
+				Timeout: 50 * time.Millisecond,
+				Degrade: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Degraded || result.Upgraded || result.Attempts != 1 {
+				t.Fatalf("got result %+v", result)
+			}
+			// the connection was reopened to the server, calls go out
+			// untracked through a fresh wrapper
+			if client.Tracker.RequestHeaderSupported() {
+				t.Fatal("not degraded to an untracked connection")
+			}
+			for i := int32(0); i < 3; i++ {
+				if sum, err := client.Add(context.Background(), i, 2); err != nil || sum != -(i+2) {
+					t.Fatalf("got (%d, %v), want the untracked sum %d", sum, err, -(i + 2))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// NewProcessorFunc binds a tracker to a service processor, typically by
+// calling a generated NewXxxServiceProcessor with the handler.
+type NewProcessorFunc func(t Tracker) thrift.TProcessor
+
+// CtxTProcessorFunction processes a call whose message begin was read, with
+// the context of its request header. Processors generated by
+// thrift-tracker-gen map methods to it.
+type CtxTProcessorFunction interface {
+	Process(ctx context.Context, seqId int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException)
+}
+
+type processorFactory struct {
+	newTracker   func() Tracker
+	newProcessor NewProcessorFunc
+}
+
+// NewProcessorFactory returns a thrift.TProcessorFactory which creates a fresh
+// tracker and processor for every accepted transport, so the upgrade state of
+// one connection never leaks into another.
+func NewProcessorFactory(newTracker func() Tracker, newProcessor NewProcessorFunc) thrift.TProcessorFactory {
+	return &processorFactory{
+		newTracker:   newTracker,
+		newProcessor: newProcessor,
+	}
+}
+
+func (f *processorFactory) GetProcessor(trans thrift.TTransport) thrift.TProcessor {
+	return f.newProcessor(f.newTracker())
+}
@@ -0,0 +1,94 @@
+package tracker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+// signHandler answers add with the sum when the call carried a request header
+// and with its negation otherwise.
+type signHandler struct {
+	ctxHandler
+}
+
+func (h *signHandler) Add(ctx context.Context, num1, num2 int32) (int32, error) {
+	if _, ok := ctx.Value(tracker.CtxKeyRequestID).(string); ok {
+		return num1 + num2, nil
+	}
+	return -(num1 + num2), nil
+}
+
+func serveFactory(t *testing.T, factory thrift.TProcessorFactory) string {
+	socket, err := thrift.NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := thrift.NewTSimpleServerFactory2(factory, socket)
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.AcceptLoop()
+	t.Cleanup(func() { server.Stop() })
+	return socket.Addr().String()
+}
+
+func dial(t *testing.T, addr string) thrift.TTransport {
+	trans, err := thrift.NewTSocket(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trans.Close() })
+	return trans
+}
+
+func TestProcessorFactoryMixedClients(t *testing.T) {
+	addr := serveFactory(t, tracker.NewProcessorFactory(
+		tracker.NewSimpleTrackerFactory("server"),
+		func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, &signHandler{})
+		},
+	))
+	f := thrift.NewTBinaryProtocolFactoryDefault()
+	tracked, err := calculator.NewCalculatorServiceClientFactory(tracker.NewSimpleTracker("client"), dial(t, addr), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := calculator.NewCalculatorServiceClientFactory(trackertest.NewNoopTracker("plain"), dial(t, addr), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with a tracker shared by both connections, the upgrade of one would
+	// make the server read a header off the other
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		client *calculator.CalculatorServiceClient
+		sign   int32
+	}{{tracked, 1}, {plain, -1}} {
+		wg.Add(1)
+		go func(client *calculator.CalculatorServiceClient, sign int32) {
+			defer wg.Done()
+			for i := int32(0); i < 50; i++ {
+				sum, err := client.Add(context.Background(), i, 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if sum != sign*(i+1) {
+					t.Errorf("got %d, want %d", sum, sign*(i+1))
+					return
+				}
+			}
+		}(c.client, c.sign)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,35 @@
+package tracker
+
+import (
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// isSimpleJSON reports whether p is, or wraps, a TSimpleJSONProtocol. It
+// writes structs keyed by field name and can not read them back, so neither
+// the upgrade call nor request headers survive it. TJSONProtocol keeps field
+// ids and message framing and is not affected.
+func isSimpleJSON(p thrift.TProtocol) bool {
+	_, ok := innerProtocol(p).(*thrift.TSimpleJSONProtocol)
+	return ok
+}
+
+// innerProtocol returns the protocol wrapped by the wrappers of this package.
+func innerProtocol(p thrift.TProtocol) thrift.TProtocol {
+	for {
+		switch v := p.(type) {
+		case *PipelinedProtocol:
+			p = v.TProtocol
+		case *limitedProtocol:
+			p = v.TProtocol
+		case *replayProtocol:
+			p = v.TProtocol
+		default:
+			return p
+		}
+	}
+}
+
+// errSimpleJSON answers an upgrade call made over TSimpleJSONProtocol, the
+// same way an untracked server would.
+var errSimpleJSON = thrift.NewTApplicationException(thrift.UNKNOWN_METHOD,
+	"Unknown function "+TrackingAPIName+": tracker does not support TSimpleJSONProtocol")
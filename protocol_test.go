@@ -0,0 +1,167 @@
+package tracker_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+func TestProtocolRoundTrip(t *testing.T) {
+	factories := []struct {
+		name    string
+		factory thrift.TProtocolFactory
+	}{
+		{"binary", thrift.NewTBinaryProtocolFactoryDefault()},
+		{"compact", thrift.NewTCompactProtocolFactory()},
+		{"json", thrift.NewTJSONProtocolFactory()},
+	}
+	for _, f := range factories {
+		t.Run(f.name, func(t *testing.T) {
+			handler := &ctxHandler{}
+			h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+				return calculator.NewCalculatorServiceProcessor(t, handler)
+			}, trackertest.WithProtocolFactory(f.factory))
+			client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !h.ClientTracker.RequestHeaderSupported() {
+				t.Fatal("not upgraded")
+			}
+
+			for i := int32(1); i <= 2; i++ {
+				ctx := context.Background()
+				ctx = context.WithValue(ctx, tracker.CtxKeyRequestID, fixtureRequestID)
+				ctx = context.WithValue(ctx, tracker.CtxKeySequenceID, fixtureSeq)
+				ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, fixtureMeta)
+				sum, err := client.Add(ctx, i, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sum != i+2 {
+					t.Fatalf("got %d, want %d", sum, i+2)
+				}
+				if got := handler.ctx.Value(tracker.CtxKeyRequestID); got != fixtureRequestID {
+					t.Errorf("got request id %v, want %v", got, fixtureRequestID)
+				}
+				if got := handler.ctx.Value(tracker.CtxKeyRequestMeta); !reflect.DeepEqual(got, fixtureMeta) {
+					t.Errorf("got meta %v, want %v", got, fixtureMeta)
+				}
+			}
+			if n := len(h.Headers()); n != 2 {
+				t.Errorf("server read %d headers, want 2", n)
+			}
+			if err := h.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSimpleJSONNegotiation(t *testing.T) {
+	out := thrift.NewTMemoryBuffer()
+	f := thrift.NewTSimpleJSONProtocolFactory()
+	ttracker := tracker.NewSimpleTracker("client")
+	if err := ttracker.Negotiation(1, f.GetProtocol(thrift.NewTMemoryBuffer()), f.GetProtocol(out)); err != nil {
+		t.Fatal(err)
+	}
+	if ttracker.RequestHeaderSupported() {
+		t.Fatal("upgraded over TSimpleJSONProtocol")
+	}
+	if out.Len() != 0 {
+		t.Fatalf("upgrade call written: %s", out.Bytes())
+	}
+	if err := ttracker.TryWriteRequestHeader(context.Background(), f.GetProtocol(out)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("header written: %s", out.Bytes())
+	}
+}
+
+func TestSimpleJSONUpgrade(t *testing.T) {
+	in, out := thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+	f := thrift.NewTSimpleJSONProtocolFactory()
+	iprot, oprot := f.GetProtocol(in), f.GetProtocol(out)
+	// what a binary client would see as the upgrade call
+	oprot.WriteMessageBegin(tracker.TrackingAPIName, thrift.CALL, 1)
+	oprot.WriteStructBegin("UpgradeArgs")
+	oprot.WriteFieldBegin("app_id", thrift.STRING, 1)
+	oprot.WriteString("client")
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	oprot.WriteMessageEnd()
+	oprot.Flush()
+	in.Write(out.Bytes())
+	out.Reset()
+
+	processor := calculator.NewCalculatorServiceProcessor(tracker.NewSimpleTracker("server"), &ctxHandler{})
+	ok, err := processor.Process(iprot, oprot)
+	if ok {
+		t.Fatal("upgraded over TSimpleJSONProtocol")
+	}
+	if x, isApp := err.(thrift.TApplicationException); !isApp || x.TypeId() != thrift.UNKNOWN_METHOD {
+		t.Fatalf("got %v, want UNKNOWN_METHOD", err)
+	}
+	name, mTypeID, seqID, err := f.GetProtocol(out).ReadMessageBegin()
+	if err != nil || name != tracker.TrackingAPIName || mTypeID != thrift.EXCEPTION || seqID != 1 {
+		t.Fatalf("reply: got (%q, %v, %d, %v)", name, mTypeID, seqID, err)
+	}
+}
+
+// blockingLogHandler hands the context of log calls over and holds them until
+// released.
+type blockingLogHandler struct {
+	ctxHandler
+	logged  chan context.Context
+	release chan struct{}
+}
+
+func (h *blockingLogHandler) Log(ctx context.Context, message string) error {
+	h.logged <- ctx
+	<-h.release
+	return nil
+}
+
+func TestOnewayRoundTrip(t *testing.T) {
+	handler := &blockingLogHandler{logged: make(chan context.Context, 1), release: make(chan struct{})}
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, handler)
+	})
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, fixtureRequestID)
+	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, fixtureMeta)
+	// Log returns while the handler still runs, it waits for no reply
+	if err := client.Log(ctx, "audit"); err != nil {
+		t.Fatal(err)
+	}
+	got := <-handler.logged
+	if id := got.Value(tracker.CtxKeyRequestID); id != fixtureRequestID {
+		t.Errorf("got request id %v, want %v", id, fixtureRequestID)
+	}
+	if meta := got.Value(tracker.CtxKeyRequestMeta); !reflect.DeepEqual(meta, fixtureMeta) {
+		t.Errorf("got meta %v, want %v", meta, fixtureMeta)
+	}
+	close(handler.release)
+
+	// a reply written for log would be read as the reply of ping
+	if ok, err := client.Ping(ctx); err != nil || !ok {
+		t.Fatalf("ping after log: got (%v, %v)", ok, err)
+	}
+	headers := h.Headers()
+	if len(headers) != 2 || headers[0].RequestID != fixtureRequestID || !reflect.DeepEqual(headers[0].Meta, fixtureMeta) {
+		t.Fatalf("got headers %v", headers)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
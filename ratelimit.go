@@ -0,0 +1,321 @@
+package tracker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ThrottledException is the type of the TApplicationException a throttled
+// call fails with, unless RateLimitPolicy.TooBusy declares another reply. It
+// is outside of the types thrift defines.
+const ThrottledException int32 = 429
+
+// IsThrottled reports whether err is the TApplicationException of a throttled
+// call.
+func IsThrottled(err error) bool {
+	x, ok := err.(thrift.TApplicationException)
+	return ok && x.TypeId() == ThrottledException
+}
+
+// RateLimit is a token bucket refilled with Rate tokens a second and holding
+// up to Burst of them, every call takes one. A zero Burst holds one token.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// AnyCaller and AnyMethod set limits for callers or methods without a limit
+// of their own.
+const (
+	AnyCaller = "*"
+	AnyMethod = "*"
+)
+
+type limitKey struct {
+	caller, method string
+}
+
+type bucket struct {
+	limit     RateLimit
+	tokens    float64
+	last      time.Time
+	allowed   uint64
+	throttled uint64
+}
+
+func (b *bucket) setLimit(limit RateLimit, now time.Time) {
+	b.limit = limit
+	b.tokens, b.last = b.burst(), now
+}
+
+func (b *bucket) burst() float64 {
+	if b.limit.Burst < 1 {
+		return 1
+	}
+	return float64(b.limit.Burst)
+}
+
+func (b *bucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if burst := b.burst(); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.throttled++
+		return false
+	}
+	b.tokens--
+	b.allowed++
+	return true
+}
+
+// RateLimitStats counts the calls of a caller to a method under a limit.
+type RateLimitStats struct {
+	Caller    string
+	Method    string
+	Allowed   uint64
+	Throttled uint64
+}
+
+// maxRateLimitBuckets bounds the buckets of a RateLimiter.
+const maxRateLimitBuckets = 10000
+
+// RateLimiter limits calls per caller and method. Limits are looked up for
+// the caller and method, then the caller and AnyMethod, AnyCaller and the
+// method, and AnyCaller and AnyMethod, calls without any limit are allowed.
+// Every caller and method pair has a bucket of its own. It is safe for
+// concurrent use, limits can be changed while serving.
+//
+// Buckets are bounded: once there are 10000, buckets idle long enough to be
+// full again are evicted, and pairs beyond the bound share the bucket of the
+// limit they fall under, e.g. AnyCaller and the method. Evicted pairs are
+// counted under that limit too.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limits    map[limitKey]RateLimit
+	buckets   map[limitKey]*bucket
+	lastSweep time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		limits:  make(map[limitKey]RateLimit),
+		buckets: make(map[limitKey]*bucket),
+	}
+}
+
+// SetLimit sets the limit of caller calling method, either may be AnyCaller
+// or AnyMethod. Buckets whose limit changes start over full.
+func (l *RateLimiter) SetLimit(caller, method string, limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits[limitKey{caller, method}] = limit
+	l.reset()
+}
+
+// RemoveLimit removes a limit set with SetLimit. Pairs left without a limit
+// lose their bucket and stats.
+func (l *RateLimiter) RemoveLimit(caller, method string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.limits, limitKey{caller, method})
+	l.reset()
+}
+
+// reset refills the buckets whose limit changed, their stats are kept, and
+// drops those left without a limit.
+func (l *RateLimiter) reset() {
+	now := time.Now()
+	for key, b := range l.buckets {
+		_, limit, ok := l.limit(key)
+		if !ok {
+			delete(l.buckets, key)
+		} else if limit != b.limit {
+			b.setLimit(limit, now)
+		}
+	}
+}
+
+// limit returns the limit of a pair and the key it is set under.
+func (l *RateLimiter) limit(key limitKey) (limitKey, RateLimit, bool) {
+	for _, k := range [...]limitKey{
+		key,
+		{key.caller, AnyMethod},
+		{AnyCaller, key.method},
+		{AnyCaller, AnyMethod},
+	} {
+		if limit, ok := l.limits[k]; ok {
+			return k, limit, true
+		}
+	}
+	return limitKey{}, RateLimit{}, false
+}
+
+// Allow takes a token for a call of caller to method, and reports whether
+// there was one.
+func (l *RateLimiter) Allow(caller, method string) bool {
+	key := limitKey{caller, method}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		limitKey, limit, ok := l.limit(key)
+		if !ok {
+			return true
+		}
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.sweep(now)
+		}
+		if len(l.buckets) >= maxRateLimitBuckets {
+			key = limitKey
+			b = l.buckets[key]
+		}
+		if b == nil {
+			b = &bucket{}
+			b.setLimit(limit, now)
+			l.buckets[key] = b
+		}
+	}
+	return b.take(now)
+}
+
+// sweep evicts the buckets full again, at most once a second, and counts
+// their calls under the key of their limit.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Second {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		limitKey, _, _ := l.limit(key)
+		if limitKey == key || b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate < b.burst() {
+			continue
+		}
+		delete(l.buckets, key)
+		shared := l.buckets[limitKey]
+		if shared == nil {
+			shared = &bucket{}
+			shared.setLimit(b.limit, now)
+			l.buckets[limitKey] = shared
+		}
+		shared.allowed += b.allowed
+		shared.throttled += b.throttled
+	}
+}
+
+// Stats returns the counts of every caller and method pair which is under
+// a limit, sorted by caller and method.
+func (l *RateLimiter) Stats() []RateLimitStats {
+	l.mu.Lock()
+	stats := make([]RateLimitStats, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		stats = append(stats, RateLimitStats{
+			Caller:    key.caller,
+			Method:    key.method,
+			Allowed:   b.allowed,
+			Throttled: b.throttled,
+		})
+	}
+	l.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Caller != stats[j].Caller {
+			return stats[i].Caller < stats[j].Caller
+		}
+		return stats[i].Method < stats[j].Method
+	})
+	return stats
+}
+
+// RateLimitPolicy controls how a rate limit processor names callers and
+// rejects their calls.
+type RateLimitPolicy struct {
+	// CallerKey names a request meta key identifying the caller when set in
+	// a request, e.g. for calls forwarded by a proxy. It is only honoured in
+	// calls of the peers listed in TrustedCallers. Callers are otherwise
+	// named by the app_id of their upgrade call, and untracked ones by "".
+	CallerKey string
+	// TrustedCallers lists the app_ids of the peers, typically proxies,
+	// whose calls may name their caller under CallerKey.
+	TrustedCallers []string
+	// TooBusy returns the result struct to reply to a throttled call of
+	// method with, typically one holding a declared "too busy" exception.
+	// Calls it returns nil for, or all with a nil TooBusy, fail with a
+	// TApplicationException of type ThrottledException.
+	TooBusy func(method string) thrift.TStruct
+	// OnThrottle is called with every throttled call.
+	OnThrottle func(caller, method string)
+}
+
+type rateLimitProcessor struct {
+	limiter *RateLimiter
+	policy  RateLimitPolicy
+	trusted map[string]bool
+	known   processorFunctions
+}
+
+// NewRateLimitProcessor returns a processor rejecting the calls limiter
+// throttles before they reach the processor newProcessor binds. t is the
+// tracker of the connection. Calls of methods a processor generated by
+// thrift-tracker-gen does not know are left to it, without taking a token.
+// Other processors, such as the output of the tracker fork of the compiler,
+// are taken to know every method.
+func NewRateLimitProcessor(t Tracker, newProcessor NewProcessorFunc, limiter *RateLimiter, policy RateLimitPolicy) thrift.TProcessor {
+	p := newInterceptProcessor(t, newProcessor)
+	r := &rateLimitProcessor{
+		limiter: limiter,
+		policy:  policy,
+		trusted: make(map[string]bool, len(policy.TrustedCallers)),
+	}
+	r.known, _ = p.processor.(processorFunctions)
+	for _, appID := range policy.TrustedCallers {
+		r.trusted[appID] = true
+	}
+	p.check = r.throttle
+	return p
+}
+
+func (p *rateLimitProcessor) throttle(ctx context.Context, method string) *rejection {
+	if p.known != nil {
+		if _, ok := p.known.GetProcessorFunction(method); !ok {
+			return nil
+		}
+	}
+	caller := p.caller(ctx)
+	if p.limiter.Allow(caller, method) {
+		return nil
+	}
+	if p.policy.OnThrottle != nil {
+		p.policy.OnThrottle(caller, method)
+	}
+	r := &rejection{x: thrift.NewTApplicationException(ThrottledException, "too many calls to "+method+" from "+caller)}
+	if p.policy.TooBusy != nil {
+		r.reply = p.policy.TooBusy(method)
+	}
+	return r
+}
+
+func (p *rateLimitProcessor) caller(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	peer := PeerAppIDFromCtx(ctx)
+	if p.policy.CallerKey != "" && p.trusted[peer] {
+		meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+		if caller := meta[p.policy.CallerKey]; caller != "" {
+			return caller
+		}
+	}
+	return peer
+}
+
+// processorFunctions is implemented by processors generated by
+// thrift-tracker-gen.
+type processorFunctions interface {
+	GetProcessorFunction(key string) (CtxTProcessorFunction, bool)
+}
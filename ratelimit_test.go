@@ -0,0 +1,312 @@
+package tracker_test
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+// slow refills one token every 1000s, tests do not see it refill.
+func slow(burst int) tracker.RateLimit {
+	return tracker.RateLimit{Rate: 0.001, Burst: burst}
+}
+
+func allowN(l *tracker.RateLimiter, caller, method string, n int) []bool {
+	got := make([]bool, n)
+	for i := range got {
+		got[i] = l.Allow(caller, method)
+	}
+	return got
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := tracker.NewRateLimiter()
+	l.SetLimit("billing", "add", slow(2))
+	l.SetLimit("billing", tracker.AnyMethod, slow(1))
+	l.SetLimit(tracker.AnyCaller, "add", slow(3))
+	for _, c := range []struct {
+		caller, method string
+		want           []bool
+	}{
+		{"billing", "add", []bool{true, true, false}},
+		{"billing", "ping", []bool{true, false}},
+		{"billing", "log", []bool{true, false}},
+		{"orders", "add", []bool{true, true, true, false}},
+		{"users", "add", []bool{true, true, true, false}},
+		{"orders", "ping", []bool{true, true, true, true}},
+	} {
+		if got := allowN(l, c.caller, c.method, len(c.want)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %s: got %v, want %v", c.caller, c.method, got, c.want)
+		}
+	}
+
+	want := []tracker.RateLimitStats{
+		{Caller: "billing", Method: "add", Allowed: 2, Throttled: 1},
+		{Caller: "billing", Method: "log", Allowed: 1, Throttled: 1},
+		{Caller: "billing", Method: "ping", Allowed: 1, Throttled: 1},
+		{Caller: "orders", Method: "add", Allowed: 3, Throttled: 1},
+		{Caller: "users", Method: "add", Allowed: 3, Throttled: 1},
+	}
+	if got := l.Stats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestRateLimiterDefault(t *testing.T) {
+	l := tracker.NewRateLimiter()
+	l.SetLimit(tracker.AnyCaller, tracker.AnyMethod, tracker.RateLimit{Rate: 0.001})
+	if got := allowN(l, "", "add", 2); !reflect.DeepEqual(got, []bool{true, false}) {
+		t.Fatalf("got %v, a zero burst holds one token", got)
+	}
+}
+
+func TestRateLimiterChanges(t *testing.T) {
+	l := tracker.NewRateLimiter()
+	l.SetLimit(tracker.AnyCaller, "add", slow(1))
+	allowN(l, "billing", "add", 2)
+
+	// a changed limit starts over full, with its stats kept
+	l.SetLimit(tracker.AnyCaller, "add", slow(2))
+	if got := allowN(l, "billing", "add", 3); !reflect.DeepEqual(got, []bool{true, true, false}) {
+		t.Fatalf("got %v after the limit changed", got)
+	}
+	if got := l.Stats(); len(got) != 1 || got[0].Allowed != 3 || got[0].Throttled != 2 {
+		t.Fatalf("got stats %+v", got)
+	}
+
+	// a more specific limit takes over
+	l.SetLimit("billing", "add", slow(1))
+	if got := allowN(l, "billing", "add", 2); !reflect.DeepEqual(got, []bool{true, false}) {
+		t.Fatalf("got %v after a caller limit was set", got)
+	}
+	l.RemoveLimit("billing", "add")
+	if got := allowN(l, "billing", "add", 3); !reflect.DeepEqual(got, []bool{true, true, false}) {
+		t.Fatalf("got %v after the caller limit was removed", got)
+	}
+
+	l.RemoveLimit(tracker.AnyCaller, "add")
+	if got := allowN(l, "billing", "add", 3); !reflect.DeepEqual(got, []bool{true, true, true}) {
+		t.Fatalf("got %v without limits", got)
+	}
+	if got := l.Stats(); len(got) != 0 {
+		t.Fatalf("got stats %+v, want buckets without a limit dropped", got)
+	}
+}
+
+func TestRateLimiterBounded(t *testing.T) {
+	l := tracker.NewRateLimiter()
+	l.SetLimit(tracker.AnyCaller, "add", slow(1))
+	for i := 0; i < 20000; i++ {
+		l.Allow("caller-"+strconv.Itoa(i), "add")
+	}
+	stats := l.Stats()
+	if len(stats) > 10001 {
+		t.Fatalf("got %d buckets, want them bounded", len(stats))
+	}
+	// callers beyond the bound share the bucket of the limit
+	var shared *tracker.RateLimitStats
+	for i := range stats {
+		if stats[i].Caller == tracker.AnyCaller {
+			shared = &stats[i]
+		}
+	}
+	if shared == nil || shared.Allowed != 1 || shared.Throttled != uint64(20000-len(stats)) {
+		t.Fatalf("got shared bucket %+v among %d buckets", shared, len(stats))
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	l := tracker.NewRateLimiter()
+	// refilled at once, every bucket is full again by the next call
+	l.SetLimit(tracker.AnyCaller, tracker.AnyMethod, tracker.RateLimit{Rate: 1e12})
+	for i := 0; i <= 10000; i++ {
+		l.Allow("caller-"+strconv.Itoa(i), "add")
+	}
+	stats := l.Stats()
+	var allowed uint64
+	for _, s := range stats {
+		allowed += s.Allowed
+	}
+	if len(stats) > 2 || allowed != 10001 {
+		t.Fatalf("got %d buckets allowing %d calls, want idle buckets evicted into the limit", len(stats), allowed)
+	}
+}
+
+// newLimitedClient serves the calculator with a rate limit processor over a
+// mux client, so that it can call methods the server does not know.
+func newLimitedClient(t *testing.T, newProcessor tracker.NewProcessorFunc, opts ...trackertest.Option) *tracker.MuxClient {
+	h := trackertest.New(newProcessor, opts...)
+	t.Cleanup(func() { h.Close() })
+	c, err := tracker.NewMuxClient(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func rateLimited(handler calculator.CalculatorService, limiter *tracker.RateLimiter, policy tracker.RateLimitPolicy) tracker.NewProcessorFunc {
+	return func(t tracker.Tracker) thrift.TProcessor {
+		return tracker.NewRateLimitProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, handler)
+		}, limiter, policy)
+	}
+}
+
+func tooBusy(method string) thrift.TStruct {
+	if method != "add" {
+		return nil
+	}
+	return &calculator.CalculatorServiceAddResult{SystemException: &calculator.CalculatorSystemException{
+		ErrorCode: calculator.CalculatorErrorCode_TOO_BUSY_ERROR, ErrorName: "TOO_BUSY"}}
+}
+
+func TestRateLimitProcessorTooBusy(t *testing.T) {
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit("client", "add", slow(1))
+	handler := &methodHandler{}
+	throttled := make(chan string, 1)
+	c := newLimitedClient(t, rateLimited(handler, limiter, tracker.RateLimitPolicy{
+		TooBusy:    tooBusy,
+		OnThrottle: func(caller, method string) { throttled <- caller + " " + method },
+	}))
+	if _, err := add(c, context.Background(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	result := &calculator.CalculatorServiceAddResult{}
+	if err := c.Call(context.Background(), "add", &calculator.CalculatorServiceAddArgs{}, result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SystemException == nil || result.SystemException.ErrorCode != calculator.CalculatorErrorCode_TOO_BUSY_ERROR {
+		t.Fatalf("got result %+v, want the too busy reply", result)
+	}
+	if got := <-throttled; got != "client add" {
+		t.Fatalf("throttled %q", got)
+	}
+	// the connection carries on, unlimited methods go through
+	if err := c.Call(context.Background(), "ping", &calculator.CalculatorServicePingArgs{}, &calculator.CalculatorServicePingResult{}); err != nil {
+		t.Fatal(err)
+	}
+	if methods := handler.Methods(); !reflect.DeepEqual(methods, []string{"add", "ping"}) {
+		t.Fatalf("handler called for %v", methods)
+	}
+}
+
+func TestRateLimitProcessorThrottled(t *testing.T) {
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit(tracker.AnyCaller, tracker.AnyMethod, slow(1))
+	c := newLimitedClient(t, rateLimited(&methodHandler{}, limiter, tracker.RateLimitPolicy{TooBusy: tooBusy}))
+	if err := c.Call(context.Background(), "ping", &calculator.CalculatorServicePingArgs{}, &calculator.CalculatorServicePingResult{}); err != nil {
+		t.Fatal(err)
+	}
+	// TooBusy has no reply for ping
+	err := c.Call(context.Background(), "ping", &calculator.CalculatorServicePingArgs{}, &calculator.CalculatorServicePingResult{})
+	if !tracker.IsThrottled(err) {
+		t.Fatalf("got %v, want a throttled call", err)
+	}
+}
+
+func TestRateLimitProcessorDefaultPolicy(t *testing.T) {
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit(tracker.AnyCaller, "add", slow(1))
+	c := newLimitedClient(t, rateLimited(&methodHandler{}, limiter, tracker.RateLimitPolicy{}))
+	if _, err := add(c, context.Background(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := add(c, context.Background(), 1, 2); !tracker.IsThrottled(err) {
+		t.Fatalf("got %v, want a throttled call", err)
+	}
+	// the connection carries on after the exception
+	if err := c.Call(context.Background(), "ping", &calculator.CalculatorServicePingArgs{}, &calculator.CalculatorServicePingResult{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimitProcessorUnknownMethod(t *testing.T) {
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit(tracker.AnyCaller, tracker.AnyMethod, slow(1))
+	c := newLimitedClient(t, rateLimited(&methodHandler{}, limiter, tracker.RateLimitPolicy{}))
+	for i := 0; i < 3; i++ {
+		err := c.Call(context.Background(), "sub"+strconv.Itoa(i), &calculator.CalculatorServiceAddArgs{}, &calculator.CalculatorServiceAddResult{})
+		if x, ok := err.(thrift.TApplicationException); !ok || x.TypeId() != thrift.UNKNOWN_METHOD {
+			t.Fatalf("got %v, want the processor to answer unknown methods", err)
+		}
+	}
+	if stats := limiter.Stats(); len(stats) != 0 {
+		t.Fatalf("got stats %+v, want no bucket for unknown methods", stats)
+	}
+}
+
+func TestRateLimitProcessorCallerKey(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		trusted []string
+		caller  string
+	}{
+		{"trusted proxy", []string{"proxy"}, "billing"},
+		{"untrusted peer", []string{"gateway"}, "proxy"},
+		{"no trusted peer", nil, "proxy"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			limiter := tracker.NewRateLimiter()
+			limiter.SetLimit(tracker.AnyCaller, tracker.AnyMethod, slow(10))
+			client := newLimitedClient(t, rateLimited(&methodHandler{}, limiter, tracker.RateLimitPolicy{
+				CallerKey:      "x-caller",
+				TrustedCallers: c.trusted,
+			}), trackertest.WithNames("proxy", "server"))
+			if _, err := add(client, tracker.WithRequestMeta(context.Background(), "x-caller", "billing"), 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if stats := limiter.Stats(); len(stats) != 1 || stats[0].Caller != c.caller {
+				t.Fatalf("got stats %+v, want the call of %s", stats, c.caller)
+			}
+		})
+	}
+}
+
+func TestRateLimitProcessorUntracked(t *testing.T) {
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit("", "add", slow(1))
+	c := newLimitedClient(t, rateLimited(&methodHandler{}, limiter, tracker.RateLimitPolicy{TooBusy: tooBusy}),
+		trackertest.WithOldClient())
+	add(c, context.Background(), 1, 2)
+	if stats := limiter.Stats(); len(stats) != 1 || stats[0].Caller != "" || stats[0].Allowed != 1 {
+		t.Fatalf("got stats %+v, want the call of an untracked caller", stats)
+	}
+}
+
+func TestRateLimitProcessorWithFault(t *testing.T) {
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit(tracker.AnyCaller, "add", slow(2))
+	handler := &ctxHandler{}
+	c := newLimitedClient(t, func(t tracker.Tracker) thrift.TProcessor {
+		return tracker.NewRateLimitProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+			return tracker.NewFaultProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+				return calculator.NewCalculatorServiceProcessor(t, handler)
+			}, tracker.FaultPolicy{Allow: allow})
+		}, limiter, tracker.RateLimitPolicy{TooBusy: tooBusy})
+	})
+
+	// the header read by the rate limit processor reaches the fault one
+	if _, err := add(c, faultCtx("abort=INTERNAL_ERROR;method=add"), 1, 2); !isAbort(err, thrift.INTERNAL_ERROR) {
+		t.Fatalf("got %v, want the injected fault", err)
+	}
+	ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, "req-1")
+	if sum, err := add(c, ctx, 1, 2); err != nil || sum != 3 {
+		t.Fatalf("got (%d, %v), want 3", sum, err)
+	}
+	if handler.ctx.Value(tracker.CtxKeyRequestID) != "req-1" {
+		t.Fatal("request id lost through both processors")
+	}
+	// throttled ahead of the fault
+	result := &calculator.CalculatorServiceAddResult{}
+	if err := c.Call(faultCtx("abort=INTERNAL_ERROR"), "add", &calculator.CalculatorServiceAddArgs{}, result); err != nil || result.SystemException == nil {
+		t.Fatalf("got (%+v, %v), want the too busy reply", result, err)
+	}
+}
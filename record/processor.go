@@ -0,0 +1,205 @@
+package record
+
+import (
+	"context"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/wire"
+)
+
+type recordingProcessor struct {
+	tracker   *headerTracker
+	processor thrift.TProcessor
+	factory   thrift.TProtocolFactory
+	w         *Writer
+}
+
+// NewProcessor returns a processor writing every call served by the
+// processor newProcessor binds to w, with its request header and reply. t is
+// the tracker of the connection and f its protocol factory. Calls are read
+// whole from the protocol the processor is given and served from memory, so
+// it nests in and around processors reading ahead such as
+// tracker.NewFaultProcessor. The upgrade call is not recorded. Write errors
+// do not fail calls, check w.Err.
+func NewProcessor(t tracker.Tracker, newProcessor tracker.NewProcessorFunc, f thrift.TProtocolFactory, w *Writer) thrift.TProcessor {
+	header := &headerTracker{Tracker: t}
+	return &recordingProcessor{
+		tracker:   header,
+		processor: newProcessor(header),
+		factory:   f,
+		w:         w,
+	}
+}
+
+func (p *recordingProcessor) Process(iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	var ctx context.Context
+	if p.tracker.RequestHeaderSupported() {
+		var err error
+		if ctx, err = p.tracker.Tracker.TryReadRequestHeader(iprot); err != nil {
+			return false, err
+		}
+	}
+	name, typ, seqID, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	p.tracker.ctx = ctx
+	if name == tracker.TrackingAPIName {
+		return p.processor.Process(&replayProtocol{
+			TProtocol: iprot,
+			name:      name,
+			typ:       typ,
+			seqID:     seqID,
+		}, oprot)
+	}
+
+	in := thrift.NewTMemoryBuffer()
+	if err := p.readRequest(iprot, p.factory.GetProtocol(in), name, typ, seqID); err != nil {
+		return false, err
+	}
+	request := append([]byte(nil), in.Bytes()...)
+	out := thrift.NewTMemoryBuffer()
+	start := time.Now()
+	ok, x := p.processor.Process(p.factory.GetProtocol(in), p.factory.GetProtocol(out))
+	latency := time.Since(start)
+	var response []byte
+	if out.Len() > 0 {
+		response = append([]byte(nil), out.Bytes()...)
+		if err := copyMessage(p.factory.GetProtocol(out), oprot); err != nil {
+			return false, err
+		}
+	}
+	p.record(ctx, start, latency, name, typ, request, response)
+	return ok, x
+}
+
+// readRequest copies the rest of a request whose message begin was read
+// from iprot to out.
+func (p *recordingProcessor) readRequest(iprot, out thrift.TProtocol, name string, typ thrift.TMessageType, seqID int32) error {
+	if err := out.WriteMessageBegin(name, typ, seqID); err != nil {
+		return err
+	}
+	if err := wire.CopySame(iprot, out, thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// copyMessage copies a message from in to out, which use the same protocol.
+func copyMessage(in, out thrift.TProtocol) error {
+	name, typ, seqID, err := in.ReadMessageBegin()
+	if err != nil {
+		return err
+	}
+	if err := out.WriteMessageBegin(name, typ, seqID); err != nil {
+		return err
+	}
+	if err := wire.CopySame(in, out, thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := in.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// record writes a call, request and response being messages encoded with
+// the protocol of the server.
+func (p *recordingProcessor) record(ctx context.Context, start time.Time, latency time.Duration, method string, typ thrift.TMessageType, request, response []byte) {
+	e := &Entry{
+		Time:    start,
+		Method:  method,
+		Oneway:  typ == thrift.ONEWAY,
+		Latency: latency,
+	}
+	if ctx != nil {
+		e.RequestID, _ = ctx.Value(tracker.CtxKeyRequestID).(string)
+		e.Seq, _ = ctx.Value(tracker.CtxKeySequenceID).(string)
+		e.Meta, _ = ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string)
+	}
+	var err error
+	if e.Request, err = p.binary(request); err != nil {
+		return
+	}
+	if response != nil {
+		if e.Response, err = p.binary(response); err != nil {
+			return
+		}
+	}
+	p.w.Write(e)
+}
+
+// binary encodes the message at the start of b, as read with the protocol of
+// the server, with TBinaryProtocol. Strings are copied as read, binary
+// fields of TJSONProtocol stay base64 encoded.
+func (p *recordingProcessor) binary(b []byte) ([]byte, error) {
+	in := p.factory.GetProtocol(memoryBuffer(b))
+	buf := thrift.NewTMemoryBuffer()
+	out := thrift.NewTBinaryProtocolTransport(buf)
+	name, typ, seqID, err := in.ReadMessageBegin()
+	if err != nil {
+		return nil, err
+	}
+	if err := out.WriteMessageBegin(name, typ, seqID); err != nil {
+		return nil, err
+	}
+	if err := wire.CopySame(in, out, thrift.STRUCT); err != nil {
+		return nil, err
+	}
+	if err := in.ReadMessageEnd(); err != nil {
+		return nil, err
+	}
+	if err := out.WriteMessageEnd(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func memoryBuffer(b []byte) *thrift.TMemoryBuffer {
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(b)
+	return buf
+}
+
+// headerTracker hands the context of a request header read by a recording
+// processor to the processor bound to it, which then reads no header.
+type headerTracker struct {
+	tracker.Tracker
+	ctx context.Context
+}
+
+func (t *headerTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	if ctx := t.ctx; ctx != nil {
+		t.ctx = nil
+		return ctx, nil
+	}
+	return t.Tracker.TryReadRequestHeader(iprot)
+}
+
+// replayProtocol hands out a message begin already read off the wire.
+type replayProtocol struct {
+	thrift.TProtocol
+	name     string
+	typ      thrift.TMessageType
+	seqID    int32
+	replayed bool
+}
+
+func (p *replayProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	if p.replayed {
+		return p.TProtocol.ReadMessageBegin()
+	}
+	p.replayed = true
+	return p.name, p.typ, p.seqID, nil
+}
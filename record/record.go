@@ -0,0 +1,73 @@
+// Package record records the calls served by a processor to a file, for
+// cmd/thrift-tracker-replay to replay them against another build.
+package record
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// MetaKeyReplayOf is set in the meta of replayed calls to the request id of
+// the recorded call, replayed calls get request ids of their own.
+const MetaKeyReplayOf = "x-replay-of"
+
+// Entry is a recorded call. Request and Response are whole messages encoded
+// with TBinaryProtocol, whatever the protocol of the connection was.
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"request_id,omitempty"`
+	Seq       string            `json:"seq,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Method    string            `json:"method"`
+	Oneway    bool              `json:"oneway,omitempty"`
+	Request   []byte            `json:"request"`
+	Response  []byte            `json:"response,omitempty"`
+	Latency   time.Duration     `json:"latency_ns"`
+}
+
+// Writer writes entries as JSON lines, it is safe for concurrent use. After
+// a failed write it drops all entries, see Err.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+func (w *Writer) Write(e *Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.enc.Encode(e)
+	}
+	return w.err
+}
+
+// Err returns the error of the first failed write.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+type Reader struct {
+	dec *json.Decoder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Read returns the next entry, or io.EOF at the end.
+func (r *Reader) Read() (*Entry, error) {
+	e := &Entry{}
+	if err := r.dec.Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
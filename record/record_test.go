@@ -0,0 +1,258 @@
+package record_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/record"
+	"github.com/eleme/thrift-tracker/trackertest"
+	"github.com/eleme/thrift-tracker/wire"
+)
+
+type handler struct{}
+
+func (handler) Ping(ctx context.Context) (bool, error) { return true, nil }
+
+func (handler) Add(ctx context.Context, num1, num2 int32) (int32, error) { return num1 + num2, nil }
+
+func (handler) Log(ctx context.Context, message string) error { return nil }
+
+// describe decodes a recorded message.
+func describe(t *testing.T, b []byte) string {
+	m, err := wire.NewDecoder(bytes.NewReader(b), wire.Options{}).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Name + " " + wire.TypeName(m.Type) + " " + wire.Format(m.Body)
+}
+
+func TestProcessor(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		f    thrift.TProtocolFactory
+	}{
+		{"binary", thrift.NewTBinaryProtocolFactoryDefault()},
+		{"compact", thrift.NewTCompactProtocolFactory()},
+		{"json", thrift.NewTJSONProtocolFactory()},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var file bytes.Buffer
+			w := record.NewWriter(&file)
+			h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+				return record.NewProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+					return calculator.NewCalculatorServiceProcessor(t, handler{})
+				}, c.f, w)
+			}, trackertest.WithProtocolFactory(c.f))
+			client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, "req-1")
+			ctx = tracker.WithRequestMeta(ctx, "k", "v")
+			if sum, err := client.Add(ctx, 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want 3", sum, err)
+			}
+			if err := client.Log(ctx, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			// the log call has been served once ping is answered
+			if _, err := client.Ping(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := record.NewReader(&file)
+			var entries []*record.Entry
+			for {
+				e, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries = append(entries, e)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("recorded %d calls, want 3 without the upgrade call", len(entries))
+			}
+			add, log, ping := entries[0], entries[1], entries[2]
+			if add.Method != "add" || add.RequestID != "req-1" || add.Meta["k"] != "v" || add.Oneway {
+				t.Fatalf("got entry %+v", add)
+			}
+			if got := describe(t, add.Request); got != "add call {1: 1, 2: 2}" {
+				t.Fatalf("got request %s", got)
+			}
+			if got := describe(t, add.Response); got != "add reply {0: 3}" {
+				t.Fatalf("got response %s", got)
+			}
+			if log.Method != "log" || !log.Oneway || log.Response != nil {
+				t.Fatalf("got entry %+v", log)
+			}
+			if got := describe(t, log.Request); got != `log oneway {1: "hello"}` {
+				t.Fatalf("got request %s", got)
+			}
+			if ping.RequestID == "" || ping.RequestID == "req-1" {
+				t.Fatalf("got request id %q, want one of the tracker", ping.RequestID)
+			}
+		})
+	}
+}
+
+func TestProcessorUntracked(t *testing.T) {
+	var file bytes.Buffer
+	w := record.NewWriter(&file)
+	f := thrift.NewTBinaryProtocolFactoryDefault()
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return record.NewProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+			return calculator.NewCalculatorServiceProcessor(t, handler{})
+		}, f, w)
+	}, trackertest.WithOldClient())
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Add(context.Background(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := record.NewReader(&file).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.RequestID != "" || e.Meta != nil {
+		t.Fatalf("got header fields in %+v", e)
+	}
+	if got := describe(t, e.Request); got != "add call {1: 1, 2: 2}" {
+		t.Fatalf("got request %s", got)
+	}
+}
+
+func allow() bool { return true }
+
+// TestProcessorNested records next to processors reading the request header
+// and message begin ahead of the processor they wrap.
+func TestProcessorNested(t *testing.T) {
+	f := thrift.NewTBinaryProtocolFactoryDefault()
+	newCalculator := func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, handler{})
+	}
+	limiter := tracker.NewRateLimiter()
+	limiter.SetLimit(tracker.AnyCaller, tracker.AnyMethod, tracker.RateLimit{Rate: 1000, Burst: 100})
+	for _, c := range []struct {
+		name string
+		new  func(w *record.Writer) tracker.NewProcessorFunc
+	}{
+		{"in fault", func(w *record.Writer) tracker.NewProcessorFunc {
+			return func(t tracker.Tracker) thrift.TProcessor {
+				return tracker.NewFaultProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+					return record.NewProcessor(t, newCalculator, f, w)
+				}, tracker.FaultPolicy{Allow: allow})
+			}
+		}},
+		{"in rate limit", func(w *record.Writer) tracker.NewProcessorFunc {
+			return func(t tracker.Tracker) thrift.TProcessor {
+				return tracker.NewRateLimitProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+					return record.NewProcessor(t, newCalculator, f, w)
+				}, limiter, tracker.RateLimitPolicy{})
+			}
+		}},
+		{"around fault", func(w *record.Writer) tracker.NewProcessorFunc {
+			return func(t tracker.Tracker) thrift.TProcessor {
+				return record.NewProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+					return tracker.NewFaultProcessor(t, newCalculator, tracker.FaultPolicy{Allow: allow})
+				}, f, w)
+			}
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var file bytes.Buffer
+			w := record.NewWriter(&file)
+			h := trackertest.New(c.new(w))
+			client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, "req-1")
+			if sum, err := client.Add(tracker.WithRequestMeta(ctx, "k", "v"), 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want 3", sum, err)
+			}
+			if err := h.Close(); err != nil {
+				t.Fatal(err)
+			}
+			e, err := record.NewReader(&file).Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.RequestID != "req-1" || e.Meta["k"] != "v" {
+				t.Fatalf("got header fields in %+v", e)
+			}
+			if got := describe(t, e.Request); got != "add call {1: 1, 2: 2}" {
+				t.Fatalf("got request %s", got)
+			}
+			if got := describe(t, e.Response); got != "add reply {0: 3}" {
+				t.Fatalf("got response %s", got)
+			}
+		})
+	}
+}
+
+func TestProcessorFault(t *testing.T) {
+	var file bytes.Buffer
+	w := record.NewWriter(&file)
+	f := thrift.NewTBinaryProtocolFactoryDefault()
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return record.NewProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+			return tracker.NewFaultProcessor(t, func(t tracker.Tracker) thrift.TProcessor {
+				return calculator.NewCalculatorServiceProcessor(t, handler{})
+			}, tracker.FaultPolicy{Allow: allow})
+		}, f, w)
+	})
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := tracker.WithRequestMeta(context.Background(), tracker.MetaKeyFault, "abort=INTERNAL_ERROR")
+	if _, err := client.Add(ctx, 1, 2); err == nil {
+		t.Fatal("got no injected fault")
+	}
+	h.Close()
+	e, err := record.NewReader(&file).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(t, e.Request); got != "add call {1: 1, 2: 2}" {
+		t.Fatalf("got request %s", got)
+	}
+	// the injected exception is recorded as the reply
+	if got := describe(t, e.Response); !strings.HasPrefix(got, "add exception ") {
+		t.Fatalf("got response %s", got)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) { return 0, io.ErrShortWrite }
+
+func TestWriterErr(t *testing.T) {
+	w := record.NewWriter(failingWriter{})
+	if err := w.Write(&record.Entry{Method: "add"}); err != io.ErrShortWrite {
+		t.Fatalf("got %v, want io.ErrShortWrite", err)
+	}
+	if err := w.Write(&record.Entry{Method: "add"}); err != io.ErrShortWrite || w.Err() != io.ErrShortWrite {
+		t.Fatalf("got %v, want the first error kept", err)
+	}
+}
@@ -0,0 +1,260 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// MetaKeyShadow is set to "1" in the request meta of calls mirrored by a
+// ShadowClient.
+const MetaKeyShadow = "shadow"
+
+// IsShadow reports whether ctx is of a mirrored call, handlers should skip
+// side effects such as writes and notifications for it.
+func IsShadow(ctx context.Context) bool {
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	return meta[MetaKeyShadow] == "1"
+}
+
+// ShadowPolicy controls which calls a ShadowClient mirrors.
+type ShadowPolicy struct {
+	// Percent of the calls mirrored, from 0 to 100.
+	Percent float64
+	// Timeout bounds every mirrored call, zero leaves it unbounded. Mirrored
+	// calls are not cancelled with the context of the primary call.
+	Timeout time.Duration
+	// MaxPending caps the mirrored calls in flight, calls beyond it are not
+	// mirrored. Zero means no cap.
+	MaxPending int
+	// OnResult is called with the outcome of every mirrored call once both
+	// calls are done, from a goroutine of its own.
+	OnResult func(ShadowResult)
+}
+
+// ShadowResult compares a call with its mirror. Outcomes are "ok",
+// "exception <field id>" for exceptions declared by the method, "error <type>"
+// for a TApplicationException and "failed" for anything else, oneway calls
+// are "ok" once sent.
+type ShadowResult struct {
+	Method         string
+	RequestID      string
+	Primary        string
+	Shadow         string
+	ShadowErr      error
+	PrimaryLatency time.Duration
+	ShadowLatency  time.Duration
+}
+
+// Diff reports whether the outcomes differ.
+func (r *ShadowResult) Diff() bool {
+	return r.Primary != r.Shadow
+}
+
+// ShadowClient mirrors a share of the calls of a primary client to a shadow
+// one, e.g. a new build fed with live traffic. Mirrored calls are sent
+// asynchronously with MetaKeyShadow set, their replies are discarded and only
+// reported to ShadowPolicy.OnResult. The primary calls and their replies are
+// not affected.
+type ShadowClient struct {
+	primary Caller
+	shadow  Caller
+	policy  ShadowPolicy
+	pending chan struct{}
+}
+
+// NewShadowClient returns a client calling primary and mirroring to shadow,
+// e.g. MuxClients, or a ClientCaller over a generated client. It does not own
+// them, close them once done.
+func NewShadowClient(primary, shadow Caller, policy ShadowPolicy) *ShadowClient {
+	c := &ShadowClient{primary: primary, shadow: shadow, policy: policy}
+	if policy.MaxPending > 0 {
+		c.pending = make(chan struct{}, policy.MaxPending)
+	}
+	return c
+}
+
+// Call calls the primary client and mirrors the call when sampled.
+func (c *ShadowClient) Call(ctx context.Context, method string, args, result thrift.TStruct) error {
+	primary := c.mirror(ctx, method, thrift.CALL, args)
+	if primary == nil {
+		return c.primary.Call(ctx, method, args, result)
+	}
+	start := time.Now()
+	r := &outcomeResult{TStruct: result}
+	err := c.primary.Call(ctx, method, args, r)
+	primary <- shadowOutcome{outcome(r.fieldID, r.set, err), time.Since(start)}
+	return err
+}
+
+// Oneway sends with the primary client and mirrors the call when sampled.
+func (c *ShadowClient) Oneway(ctx context.Context, method string, args thrift.TStruct) error {
+	primary := c.mirror(ctx, method, thrift.ONEWAY, args)
+	if primary == nil {
+		return c.primary.Oneway(ctx, method, args)
+	}
+	start := time.Now()
+	err := c.primary.Oneway(ctx, method, args)
+	primary <- shadowOutcome{outcome(0, false, err), time.Since(start)}
+	return err
+}
+
+type shadowOutcome struct {
+	outcome string
+	latency time.Duration
+}
+
+// mirror starts the mirrored call when sampled, it then reports once the
+// outcome of the primary call is sent to the returned channel.
+func (c *ShadowClient) mirror(ctx context.Context, method string, mTypeID thrift.TMessageType, args thrift.TStruct) chan<- shadowOutcome {
+	if c.policy.Percent <= 0 || rand.Float64()*100 >= c.policy.Percent {
+		return nil
+	}
+	if c.pending != nil {
+		select {
+		case c.pending <- struct{}{}:
+		default:
+			return nil
+		}
+	}
+	// the caller may reuse args once the primary call returns
+	snapshot, err := snapshotArgs(args)
+	if err != nil {
+		c.release()
+		return nil
+	}
+	requestID, _ := ctx.Value(CtxKeyRequestID).(string)
+	shadowCtx := WithRequestMeta(detachedCtx{ctx}, MetaKeyShadow, "1")
+	primary := make(chan shadowOutcome, 1)
+	go func() {
+		if c.policy.Timeout > 0 {
+			var cancel context.CancelFunc
+			shadowCtx, cancel = context.WithTimeout(shadowCtx, c.policy.Timeout)
+			defer cancel()
+		}
+		res := ShadowResult{Method: method, RequestID: requestID}
+		start := time.Now()
+		if mTypeID == thrift.ONEWAY {
+			res.ShadowErr = c.shadow.Oneway(shadowCtx, method, snapshot)
+			res.Shadow = outcome(0, false, res.ShadowErr)
+		} else {
+			r := &outcomeResult{}
+			res.ShadowErr = c.shadow.Call(shadowCtx, method, snapshot, r)
+			res.Shadow = outcome(r.fieldID, r.set, res.ShadowErr)
+		}
+		res.ShadowLatency = time.Since(start)
+		p := <-primary
+		res.Primary, res.PrimaryLatency = p.outcome, p.latency
+		c.release()
+		if c.policy.OnResult != nil {
+			c.policy.OnResult(res)
+		}
+	}()
+	return primary
+}
+
+func (c *ShadowClient) release() {
+	if c.pending != nil {
+		<-c.pending
+	}
+}
+
+func outcome(fieldID int16, set bool, err error) string {
+	if x, ok := err.(thrift.TApplicationException); ok {
+		return "error " + strconv.Itoa(int(x.TypeId()))
+	}
+	if err != nil {
+		return "failed"
+	}
+	if set && fieldID != 0 {
+		return "exception " + strconv.Itoa(int(fieldID))
+	}
+	return "ok"
+}
+
+// outcomeResult notes the first field of a result struct, which is the
+// success or the exception thrown. It reads into TStruct, or discards the
+// result when there is none.
+type outcomeResult struct {
+	thrift.TStruct
+	fieldID int16
+	set     bool
+}
+
+func (r *outcomeResult) Read(iprot thrift.TProtocol) error {
+	p := &outcomeProtocol{TProtocol: iprot, result: r}
+	if r.TStruct == nil {
+		return p.Skip(thrift.STRUCT)
+	}
+	return r.TStruct.Read(p)
+}
+
+type outcomeProtocol struct {
+	thrift.TProtocol
+	result *outcomeResult
+	depth  int
+}
+
+func (p *outcomeProtocol) ReadStructBegin() (string, error) {
+	p.depth++
+	return p.TProtocol.ReadStructBegin()
+}
+
+func (p *outcomeProtocol) ReadStructEnd() error {
+	p.depth--
+	return p.TProtocol.ReadStructEnd()
+}
+
+func (p *outcomeProtocol) ReadFieldBegin() (string, thrift.TType, int16, error) {
+	name, typeID, id, err := p.TProtocol.ReadFieldBegin()
+	if err == nil && p.depth == 1 && typeID != thrift.STOP && !p.result.set {
+		p.result.fieldID, p.result.set = id, true
+	}
+	return name, typeID, id, err
+}
+
+// Skip goes through the methods above, unlike the Skip of the wrapped
+// protocol.
+func (p *outcomeProtocol) Skip(fieldType thrift.TType) error {
+	return thrift.SkipDefaultDepth(p, fieldType)
+}
+
+// snapshotArgs copies args, a pointer to a generated struct, by writing it
+// to a buffer and reading it back into a new struct of its type.
+func snapshotArgs(args thrift.TStruct) (thrift.TStruct, error) {
+	v := reflect.ValueOf(args)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, errors.New("tracker: args is not a pointer to a struct")
+	}
+	snapshot, ok := reflect.New(v.Type().Elem()).Interface().(thrift.TStruct)
+	if !ok {
+		return nil, errors.New("tracker: args is not a pointer to a struct")
+	}
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := args.Write(prot); err != nil {
+		return nil, err
+	}
+	if err := snapshot.Read(prot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// detachedCtx keeps the values of its parent but neither its deadline nor
+// its cancellation.
+type detachedCtx struct {
+	parent context.Context
+}
+
+func (detachedCtx) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedCtx) Done() <-chan struct{} { return nil }
+
+func (detachedCtx) Err() error { return nil }
+
+func (c detachedCtx) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,276 @@
+package tracker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+type fakeCall struct {
+	ctx    context.Context
+	method string
+	args   thrift.TStruct
+}
+
+// fakeCaller answers calls with reply, or fails them with err, once release
+// is closed when set.
+type fakeCaller struct {
+	reply   thrift.TStruct
+	err     error
+	release chan struct{}
+	calls   chan fakeCall
+}
+
+func newFakeCaller(reply thrift.TStruct, err error) *fakeCaller {
+	return &fakeCaller{reply: reply, err: err, calls: make(chan fakeCall, 1000)}
+}
+
+func (c *fakeCaller) Call(ctx context.Context, method string, args, result thrift.TStruct) error {
+	c.calls <- fakeCall{ctx, method, args}
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return c.err
+	}
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := c.reply.Write(prot); err != nil {
+		return err
+	}
+	return result.Read(prot)
+}
+
+func (c *fakeCaller) Oneway(ctx context.Context, method string, args thrift.TStruct) error {
+	c.calls <- fakeCall{ctx, method, args}
+	return c.err
+}
+
+func sumResult(sum int32) *calculator.CalculatorServiceAddResult {
+	return &calculator.CalculatorServiceAddResult{Success: &sum}
+}
+
+func pingResult(ok bool) *calculator.CalculatorServicePingResult {
+	return &calculator.CalculatorServicePingResult{Success: &ok}
+}
+
+func newShadowClient(primary, shadow tracker.Caller, policy tracker.ShadowPolicy) (*tracker.ShadowClient, chan tracker.ShadowResult) {
+	results := make(chan tracker.ShadowResult, 1000)
+	policy.OnResult = func(r tracker.ShadowResult) { results <- r }
+	return tracker.NewShadowClient(primary, shadow, policy), results
+}
+
+func TestShadowClient(t *testing.T) {
+	primary, shadow := newFakeCaller(sumResult(3), nil), newFakeCaller(sumResult(4), nil)
+	c, results := newShadowClient(primary, shadow, tracker.ShadowPolicy{Percent: 100})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), tracker.CtxKeyRequestID, "req-1"))
+	args := &calculator.CalculatorServiceAddArgs{Num1: 1, Num2: 2}
+	result := &calculator.CalculatorServiceAddResult{}
+	if err := c.Call(ctx, "add", args, result); err != nil || result.GetSuccess() != 3 {
+		t.Fatalf("got (%v, %v), want the primary reply", result.GetSuccess(), err)
+	}
+	// the caller reuses its args and its context ends
+	args.Num1 = 10
+	cancel()
+
+	r := <-results
+	if r.Method != "add" || r.RequestID != "req-1" || r.Primary != "ok" || r.Shadow != "ok" || r.Diff() {
+		t.Fatalf("got result %+v", r)
+	}
+	call := <-shadow.calls
+	if got := call.args.(*calculator.CalculatorServiceAddArgs); got == args || got.Num1 != 1 || got.Num2 != 2 {
+		t.Fatalf("shadow got args %+v, want a snapshot of the call", got)
+	}
+	if !tracker.IsShadow(call.ctx) || call.ctx.Value(tracker.CtxKeyRequestID) != "req-1" {
+		t.Fatal("shadow call lacks shadow=1 or the request id")
+	}
+	if call.ctx.Err() != nil {
+		t.Fatal("shadow call cancelled with the primary call")
+	}
+	if call := <-primary.calls; tracker.IsShadow(call.ctx) {
+		t.Fatal("primary call marked as shadow")
+	}
+}
+
+func TestShadowClientTimeout(t *testing.T) {
+	shadow := newFakeCaller(sumResult(3), nil)
+	c, results := newShadowClient(newFakeCaller(sumResult(3), nil), shadow, tracker.ShadowPolicy{Percent: 100, Timeout: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := c.Call(ctx, "add", &calculator.CalculatorServiceAddArgs{}, &calculator.CalculatorServiceAddResult{}); err != nil {
+		t.Fatal(err)
+	}
+	<-results
+	deadline, ok := (<-shadow.calls).ctx.Deadline()
+	if !ok || time.Until(deadline) > time.Minute {
+		t.Fatalf("got deadline %v, want the timeout of the policy", deadline)
+	}
+}
+
+func TestShadowClientOutcomes(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		primary *fakeCaller
+		shadow  *fakeCaller
+		want    tracker.ShadowResult
+		diff    bool
+	}{
+		{
+			name:    "same",
+			primary: newFakeCaller(pingResult(true), nil),
+			shadow:  newFakeCaller(pingResult(false), nil),
+			want:    tracker.ShadowResult{Primary: "ok", Shadow: "ok"},
+		},
+		{
+			name:    "exception",
+			primary: newFakeCaller(pingResult(true), nil),
+			shadow: newFakeCaller(&calculator.CalculatorServicePingResult{
+				UserException: &calculator.CalculatorUserException{ErrorName: "busy"},
+			}, nil),
+			want: tracker.ShadowResult{Primary: "ok", Shadow: "exception 1"},
+			diff: true,
+		},
+		{
+			name:    "application exception",
+			primary: newFakeCaller(pingResult(true), nil),
+			shadow:  newFakeCaller(nil, thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "unknown method ping")),
+			want:    tracker.ShadowResult{Primary: "ok", Shadow: "error 1"},
+			diff:    true,
+		},
+		{
+			name:    "failed",
+			primary: newFakeCaller(nil, errors.New("broken pipe")),
+			shadow:  newFakeCaller(pingResult(true), nil),
+			want:    tracker.ShadowResult{Primary: "failed", Shadow: "ok"},
+			diff:    true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			client, results := newShadowClient(c.primary, c.shadow, tracker.ShadowPolicy{Percent: 100})
+			client.Call(context.Background(), "ping", &calculator.CalculatorServicePingArgs{}, &calculator.CalculatorServicePingResult{})
+			r := <-results
+			if r.Primary != c.want.Primary || r.Shadow != c.want.Shadow || r.Diff() != c.diff {
+				t.Fatalf("got %+v, want %+v", r, c.want)
+			}
+			if (r.ShadowErr != nil) != (c.shadow.err != nil) {
+				t.Fatalf("got shadow error %v", r.ShadowErr)
+			}
+		})
+	}
+}
+
+func TestShadowClientOneway(t *testing.T) {
+	shadow := newFakeCaller(nil, nil)
+	c, results := newShadowClient(newFakeCaller(nil, nil), shadow, tracker.ShadowPolicy{Percent: 100})
+	if err := c.Oneway(context.Background(), "log", &calculator.CalculatorServiceLogArgs{Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-results; r.Method != "log" || r.Primary != "ok" || r.Shadow != "ok" {
+		t.Fatalf("got result %+v", r)
+	}
+	if call := <-shadow.calls; call.args.(*calculator.CalculatorServiceLogArgs).Message != "hi" {
+		t.Fatalf("shadow got args %+v", call.args)
+	}
+}
+
+// drain returns the number of results reported until none comes for a while.
+func drain(results chan tracker.ShadowResult) int {
+	n := 0
+	for {
+		select {
+		case <-results:
+			n++
+		case <-time.After(200 * time.Millisecond):
+			return n
+		}
+	}
+}
+
+func TestShadowClientSampling(t *testing.T) {
+	for _, c := range []struct {
+		percent  float64
+		min, max int
+	}{
+		{0, 0, 0},
+		{30, 200, 400},
+		{100, 1000, 1000},
+	} {
+		client, results := newShadowClient(newFakeCaller(sumResult(3), nil), newFakeCaller(sumResult(3), nil),
+			tracker.ShadowPolicy{Percent: c.percent})
+		for i := 0; i < 1000; i++ {
+			if err := client.Call(context.Background(), "add", &calculator.CalculatorServiceAddArgs{}, &calculator.CalculatorServiceAddResult{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := drain(results); n < c.min || n > c.max {
+			t.Errorf("%v%%: mirrored %d calls of 1000", c.percent, n)
+		}
+	}
+}
+
+func TestShadowClientMaxPending(t *testing.T) {
+	shadow := newFakeCaller(sumResult(3), nil)
+	shadow.release = make(chan struct{})
+	c, results := newShadowClient(newFakeCaller(sumResult(3), nil), shadow, tracker.ShadowPolicy{Percent: 100, MaxPending: 1})
+	call := func() {
+		if err := c.Call(context.Background(), "add", &calculator.CalculatorServiceAddArgs{}, &calculator.CalculatorServiceAddResult{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		call()
+	}
+	// the first mirrored call holds the only slot
+	<-shadow.calls
+	close(shadow.release)
+	if n := drain(results); n != 1 {
+		t.Fatalf("mirrored %d calls, want 1", n)
+	}
+	call()
+	<-results
+}
+
+func TestClientCaller(t *testing.T) {
+	handler := &ctxHandler{}
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, handler)
+	})
+	defer h.Close()
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := client.Add(context.Background(), 1, 1); err != nil || sum != 2 {
+		t.Fatalf("got (%d, %v), want 2", sum, err)
+	}
+
+	// mirrored to a caller that fails, the primary call is not affected
+	caller := tracker.NewClientCaller(client.Tracker, client.InputProtocol, client.OutputProtocol)
+	c, results := newShadowClient(caller, newFakeCaller(nil, errors.New("refused")), tracker.ShadowPolicy{Percent: 100})
+	ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, "req-1")
+	result := &calculator.CalculatorServiceAddResult{}
+	if err := c.Call(ctx, "add", &calculator.CalculatorServiceAddArgs{Num1: 1, Num2: 2}, result); err != nil || result.GetSuccess() != 3 {
+		t.Fatalf("got (%d, %v), want 3", result.GetSuccess(), err)
+	}
+	if handler.ctx.Value(tracker.CtxKeyRequestID) != "req-1" {
+		t.Fatal("request header of the call not written")
+	}
+	if r := <-results; r.Primary != "ok" || r.Shadow != "failed" {
+		t.Fatalf("got result %+v", r)
+	}
+	if err := c.Oneway(ctx, "log", &calculator.CalculatorServiceLogArgs{Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	<-results
+
+	// the generated client carries on once the caller is done
+	if sum, err := client.Add(context.Background(), 2, 2); err != nil || sum != 4 {
+		t.Fatalf("got (%d, %v), want 4", sum, err)
+	}
+}
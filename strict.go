@@ -0,0 +1,49 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+type strictTracker struct {
+	Tracker
+}
+
+// NewStrictTracker wraps t so that its negotiation fails with
+// ErrPeerUnsupported when the peer does not support tracker, instead of
+// silently falling back to untracked calls. It should be the outermost
+// wrapper, a cached tracker skips the negotiation of known untracked peers.
+func NewStrictTracker(t Tracker) Tracker {
+	return &strictTracker{Tracker: t}
+}
+
+func (t *strictTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	if err := t.Tracker.Negotiation(curSeqID, iprot, oprot); err != nil {
+		return err
+	}
+	if !t.Tracker.RequestHeaderSupported() {
+		return &Error{
+			Kind: ErrPeerUnsupported,
+			Err:  thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+TrackingAPIName),
+		}
+	}
+	return nil
+}
+
+// NewStrictProcessor wraps p, which must be bound to t, so that calls on a
+// connection not upgraded yet are rejected with a TApplicationException.
+func NewStrictProcessor(t Tracker, p thrift.TProcessor) thrift.TProcessor {
+	return &interceptProcessor{
+		tracker:   t,
+		processor: p,
+		pass:      t.RequestHeaderSupported,
+		check: func(ctx context.Context, method string) *rejection {
+			return &rejection{
+				x: thrift.NewTApplicationException(thrift.PROTOCOL_ERROR,
+					"tracker required: "+method+" called without upgrade"),
+				close: true,
+			}
+		},
+	}
+}
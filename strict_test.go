@@ -0,0 +1,123 @@
+package tracker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+func TestStrictTracker(t *testing.T) {
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, &ctxHandler{})
+	})
+	defer h.Close()
+	client, err := calculator.NewCalculatorServiceClientFactory(tracker.NewStrictTracker(h.ClientTracker), h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Add(context.Background(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStrictTrackerUntrackedPeer(t *testing.T) {
+	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, &ctxHandler{})
+	}, trackertest.WithOldServer())
+	defer h.Close()
+	_, err := calculator.NewCalculatorServiceClientFactory(tracker.NewStrictTracker(h.ClientTracker), h.Transport, h.ProtocolFactory)
+	if !errors.Is(err, tracker.ErrPeerUnsupported) {
+		t.Fatalf("got %v, want ErrPeerUnsupported", err)
+	}
+	var x thrift.TApplicationException
+	if !errors.As(err, &x) || x.TypeId() != thrift.UNKNOWN_METHOD {
+		t.Fatalf("got %v, want an UNKNOWN_METHOD exception behind it", err)
+	}
+}
+
+func newStrictHarness(handler calculator.CalculatorService, opts ...trackertest.Option) *trackertest.Harness {
+	return trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return tracker.NewStrictProcessor(t, calculator.NewCalculatorServiceProcessor(t, handler))
+	}, opts...)
+}
+
+func TestStrictProcessor(t *testing.T) {
+	handler := &ctxHandler{}
+	h := newStrictHarness(handler)
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, fixtureRequestID)
+	if _, err := client.Add(ctx, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Log(ctx, "tracked"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := handler.ctx.Value(tracker.CtxKeyRequestID); got != fixtureRequestID {
+		t.Fatalf("got request id %v, want %v", got, fixtureRequestID)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStrictProcessorRejects(t *testing.T) {
+	isRejection := func(err error) bool {
+		x, ok := err.(thrift.TApplicationException)
+		return ok && x.TypeId() == thrift.PROTOCOL_ERROR
+	}
+
+	t.Run("call", func(t *testing.T) {
+		handler := &ctxHandler{}
+		h := newStrictHarness(handler, trackertest.WithOldClient())
+		client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Add(context.Background(), 1, 2); !isRejection(err) {
+			t.Fatalf("got %v, want a PROTOCOL_ERROR exception", err)
+		}
+		if handler.ctx != nil {
+			t.Fatal("rejected call reached the handler")
+		}
+		// the connection is dropped after the rejection
+		if _, err := client.Ping(context.Background()); err == nil {
+			t.Fatal("ping answered after a rejected call")
+		}
+		if err := h.Close(); !isRejection(err) {
+			t.Fatalf("server stopped with %v, want the rejection", err)
+		}
+	})
+
+	t.Run("oneway", func(t *testing.T) {
+		handler := &ctxHandler{}
+		h := newStrictHarness(handler, trackertest.WithOldClient())
+		client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Log(context.Background(), "untracked"); err != nil {
+			t.Fatal(err)
+		}
+		// rejected without a reply, the server drops the connection
+		if _, err := client.Ping(context.Background()); err == nil {
+			t.Fatal("ping answered after a rejected oneway call")
+		}
+		if handler.ctx != nil {
+			t.Fatal("rejected call reached the handler")
+		}
+		if err := h.Close(); !isRejection(err) {
+			t.Fatalf("server stopped with %v, want the rejection", err)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+"""Re-capture the golden fixtures of conformance_test.go with thriftpy.
+
+A thriftpy.contrib.tracking client talks to a tracked and to an untracked
+thriftpy server over socket pairs, every flush of either side is one
+message. seqid is always 0 as thriftpy never increments it. The bytes are
+explained in README.md, update it along with the fixtures.
+
+    $ pip install thriftpy
+    $ python testdata/thriftpy/capture.py
+"""
+import binascii
+import os
+import socket
+import threading
+
+import thriftpy
+from thriftpy.contrib.tracking import (
+    TrackerBase,
+    TTrackedClient,
+    TTrackedProcessor,
+)
+from thriftpy.protocol.binary import TBinaryProtocol
+from thriftpy.thrift import TProcessor
+from thriftpy.transport import TTransportException
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+ROOT = os.path.join(HERE, "..", "..")
+
+calc_thrift = thriftpy.load(os.path.join(ROOT, "example", "calculator.thrift"),
+                            module_name="calculator_thrift")
+
+REQUEST_ID = "b7d4f5c2-6b8e-4d7b-9a63-2f0f4f3d1e8a"
+
+
+class FixtureTracker(TrackerBase):
+    """Sends fixed handshake info and headers, the fixtures do not change
+    between captures."""
+
+    def init_handshake_info(self, handshake_obj):
+        handshake_obj.app_id = "client"
+
+    def gen_header(self, header):
+        header.request_id = REQUEST_ID
+        header.seq = "1.1"
+        header.meta = {"clientA": "ping"}
+
+    def handle_handshake_info(self, handshake_obj):
+        pass
+
+    def handle(self, header):
+        pass
+
+    def record(self, header, exception):
+        pass
+
+
+class Handler(object):
+    def ping(self):
+        return True
+
+    def add(self, num1, num2):
+        return num1 + num2
+
+    def log(self, message):
+        pass
+
+
+class RecordingSocket(object):
+    """A transport over one end of a socket pair keeping every flush."""
+
+    def __init__(self, sock):
+        self.sock = sock
+        self.wbuf = b""
+        self.flushed = []
+
+    def is_open(self):
+        return True
+
+    def read(self, sz):
+        buf = b""
+        while len(buf) < sz:
+            chunk = self.sock.recv(sz - len(buf))
+            if not chunk:
+                raise TTransportException(TTransportException.END_OF_FILE)
+            buf += chunk
+        return buf
+
+    def write(self, buf):
+        self.wbuf += buf
+
+    def flush(self):
+        self.sock.sendall(self.wbuf)
+        self.flushed.append(self.wbuf)
+        self.wbuf = b""
+
+    def close(self):
+        self.sock.close()
+
+
+def session(processor, calls):
+    """Runs calls on a tracked client connected to processor and returns the
+    flushes of the client and of the server."""
+    c, s = socket.socketpair()
+    client_trans, server_trans = RecordingSocket(c), RecordingSocket(s)
+
+    def serve():
+        proto = TBinaryProtocol(server_trans)
+        try:
+            while True:
+                processor.process(proto, proto)
+        except TTransportException:
+            pass
+
+    server = threading.Thread(target=serve)
+    server.start()
+    client = TTrackedClient(FixtureTracker(), calc_thrift.CalculatorService,
+                            TBinaryProtocol(client_trans))
+    calls(client)
+    client_trans.close()
+    server.join()
+    server_trans.close()
+    return client_trans.flushed, server_trans.flushed
+
+
+def capture(name, title, data):
+    data = binascii.hexlify(data).decode()
+    with open(os.path.join(HERE, name), "w") as f:
+        f.write("# %s\n" % title)
+        for i in range(0, len(data), 32):
+            line = data[i:i + 32]
+            f.write(" ".join(line[j:j + 2] for j in range(0, len(line), 2)))
+            f.write("\n")
+
+
+tracked_client, tracked_server = session(
+    TTrackedProcessor(FixtureTracker(), calc_thrift.CalculatorService,
+                      Handler()),
+    lambda client: client.ping())
+untracked_client, untracked_server = session(
+    TProcessor(calc_thrift.CalculatorService, Handler()),
+    lambda client: None)
+
+capture("upgrade_call.hex", "client upgrade call, UpgradeArgs(app_id='client')",
+        tracked_client[0])
+capture("upgrade_reply.hex", "server upgrade reply, UpgradeReply()",
+        tracked_server[0])
+capture("unknown_method.hex",
+        "untracked server reply, TApplicationException(UNKNOWN_METHOD)",
+        untracked_server[0])
+capture("header_call.hex",
+        "RequestHeader(request_id='%s', seq='1.1', meta={'clientA': 'ping'}) "
+        "followed by ping()" % REQUEST_ID,
+        tracked_client[1])
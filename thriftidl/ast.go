@@ -0,0 +1,242 @@
+// Package thriftidl parses .thrift files into an AST, so that tools of this
+// repository can read service definitions without a thrift compiler.
+package thriftidl
+
+import (
+	"fmt"
+)
+
+// Pos is a position in a .thrift file, lines and columns start at 1.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Error is a syntax error at Pos.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+type File struct {
+	Name       string
+	Includes   []*Include
+	Namespaces []*Namespace
+	Typedefs   []*Typedef
+	Consts     []*Const
+	Enums      []*Enum
+	Structs    []*Struct // structs, unions and exceptions
+	Services   []*Service
+}
+
+type Include struct {
+	Pos  Pos
+	Path string
+}
+
+type Namespace struct {
+	Pos   Pos
+	Scope string // e.g. go, py or *
+	Name  string
+}
+
+// Annotation is a (name = "value") annotation, Value is "1" when omitted.
+type Annotation struct {
+	Pos   Pos
+	Name  string
+	Value string
+}
+
+// Type is a base type (e.g. i32, string), a container (list, set, map) or a
+// reference to a type defined elsewhere, possibly included (e.g. shared.Foo).
+type Type struct {
+	Pos         Pos
+	Name        string
+	KeyType     *Type // of map
+	ValueType   *Type // of map, list and set
+	Annotations []*Annotation
+}
+
+func (t *Type) String() string {
+	switch t.Name {
+	case "map":
+		return "map<" + t.KeyType.String() + "," + t.ValueType.String() + ">"
+	case "list", "set":
+		return t.Name + "<" + t.ValueType.String() + ">"
+	}
+	return t.Name
+}
+
+type Typedef struct {
+	Pos         Pos
+	Name        string
+	Type        *Type
+	Annotations []*Annotation
+}
+
+type ConstKind int
+
+const (
+	ConstInt ConstKind = iota
+	ConstDouble
+	ConstString
+	ConstIdentifier // e.g. an enum value
+	ConstList
+	ConstMap
+)
+
+type ConstValue struct {
+	Pos    Pos
+	Kind   ConstKind
+	Int    int64
+	Double float64
+	String string // of ConstString and ConstIdentifier
+	List   []*ConstValue
+	Map    []*ConstMapEntry
+}
+
+type ConstMapEntry struct {
+	Key   *ConstValue
+	Value *ConstValue
+}
+
+type Const struct {
+	Pos   Pos
+	Name  string
+	Type  *Type
+	Value *ConstValue
+}
+
+type Enum struct {
+	Pos         Pos
+	Name        string
+	Values      []*EnumValue
+	Annotations []*Annotation
+}
+
+// EnumValue has its explicit value or the one after the previous value.
+type EnumValue struct {
+	Pos         Pos
+	Name        string
+	Value       int64
+	Annotations []*Annotation
+}
+
+type StructKind int
+
+const (
+	StructKindStruct StructKind = iota
+	StructKindUnion
+	StructKindException
+)
+
+func (k StructKind) String() string {
+	switch k {
+	case StructKindUnion:
+		return "union"
+	case StructKindException:
+		return "exception"
+	}
+	return "struct"
+}
+
+type Struct struct {
+	Pos         Pos
+	Kind        StructKind
+	Name        string
+	Fields      []*Field
+	Annotations []*Annotation
+}
+
+type Requiredness int
+
+const (
+	Default Requiredness = iota
+	Required
+	Optional
+)
+
+// Field is a field of a struct, or a parameter or exception of a function.
+// Fields without an explicit id get negative ones, as thrift does.
+type Field struct {
+	Pos          Pos
+	ID           int
+	Name         string
+	Type         *Type
+	Requiredness Requiredness
+	Default      *ConstValue
+	Annotations  []*Annotation
+}
+
+type Service struct {
+	Pos         Pos
+	Name        string
+	Extends     string
+	Functions   []*Function
+	Annotations []*Annotation
+}
+
+type Function struct {
+	Pos         Pos
+	Name        string
+	Oneway      bool
+	ReturnType  *Type // nil for void
+	Params      []*Field
+	Throws      []*Field
+	Annotations []*Annotation
+}
+
+// Struct returns the struct, union or exception called name.
+func (f *File) Struct(name string) *Struct {
+	for _, s := range f.Structs {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (f *File) Typedef(name string) *Typedef {
+	for _, t := range f.Typedefs {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func (f *File) Enum(name string) *Enum {
+	for _, e := range f.Enums {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func (f *File) Service(name string) *Service {
+	for _, s := range f.Services {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *Service) Function(name string) *Function {
+	for _, fn := range s.Functions {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package thriftidl
+
+import (
+	"bytes"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokDouble
+	tokString
+	tokPunct // one of {}()[]<>,;:=*
+)
+
+type token struct {
+	kind tokenKind
+	text string // string literals unquoted
+	pos  Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of file"
+	case tokString:
+		return "string literal"
+	}
+	return "\"" + t.text + "\""
+}
+
+type lexer struct {
+	filename string
+	src      string
+	off      int
+	line     int
+	col      int
+}
+
+func newLexer(filename string, src []byte) *lexer {
+	return &lexer{filename: filename, src: string(src), line: 1, col: 1}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Filename: l.filename, Line: l.line, Column: l.col}
+}
+
+func (l *lexer) errorf(pos Pos, msg string) error {
+	return &Error{Pos: pos, Msg: msg}
+}
+
+func (l *lexer) peekByte(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *lexer) advance() {
+	if l.src[l.off] == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.off++
+}
+
+// skip skips white space and comments.
+func (l *lexer) skip() error {
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.advance()
+		case c == '#' || (c == '/' && l.peekByte(1) == '/'):
+			for l.off < len(l.src) && l.src[l.off] != '\n' {
+				l.advance()
+			}
+		case c == '/' && l.peekByte(1) == '*':
+			pos := l.pos()
+			l.advance()
+			l.advance()
+			for {
+				if l.off >= len(l.src) {
+					return l.errorf(pos, "comment not terminated")
+				}
+				if l.src[l.off] == '*' && l.peekByte(1) == '/' {
+					l.advance()
+					l.advance()
+					break
+				}
+				l.advance()
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	pos := l.pos()
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+	start := l.off
+	c := l.src[l.off]
+	switch {
+	case isLetter(c):
+		for l.off < len(l.src) && (isLetter(l.src[l.off]) || isDigit(l.src[l.off]) || l.src[l.off] == '.') {
+			l.advance()
+		}
+		return token{kind: tokIdent, text: l.src[start:l.off], pos: pos}, nil
+	case isDigit(c) || ((c == '+' || c == '-') && (isDigit(l.peekByte(1)) || l.peekByte(1) == '.')) || (c == '.' && isDigit(l.peekByte(1))):
+		return l.number(pos)
+	case c == '"' || c == '\'':
+		return l.string(pos)
+	case strings.IndexByte("{}()[]<>,;:=*", c) >= 0:
+		l.advance()
+		return token{kind: tokPunct, text: string(c), pos: pos}, nil
+	}
+	return token{}, l.errorf(pos, "unexpected character "+quoteByte(c))
+}
+
+func quoteByte(c byte) string {
+	if c < ' ' || c > '~' {
+		return "\\x" + string("0123456789abcdef"[c>>4]) + string("0123456789abcdef"[c&15])
+	}
+	return "'" + string(c) + "'"
+}
+
+func (l *lexer) number(pos Pos) (token, error) {
+	start := l.off
+	if c := l.src[l.off]; c == '+' || c == '-' {
+		l.advance()
+	}
+	if l.src[l.off] == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		l.advance()
+		l.advance()
+		for l.off < len(l.src) && strings.IndexByte("0123456789abcdefABCDEF", l.src[l.off]) >= 0 {
+			l.advance()
+		}
+		return token{kind: tokInt, text: l.src[start:l.off], pos: pos}, nil
+	}
+	kind := tokInt
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case isDigit(c):
+		case c == '.':
+			kind = tokDouble
+		case c == 'e' || c == 'E':
+			kind = tokDouble
+			if n := l.peekByte(1); n == '+' || n == '-' {
+				l.advance()
+			}
+		default:
+			return token{kind: kind, text: l.src[start:l.off], pos: pos}, nil
+		}
+		l.advance()
+	}
+	return token{kind: kind, text: l.src[start:l.off], pos: pos}, nil
+}
+
+func (l *lexer) string(pos Pos) (token, error) {
+	quote := l.src[l.off]
+	l.advance()
+	var b bytes.Buffer
+	for {
+		if l.off >= len(l.src) || l.src[l.off] == '\n' {
+			return token{}, l.errorf(pos, "string literal not terminated")
+		}
+		c := l.src[l.off]
+		if c == quote {
+			l.advance()
+			return token{kind: tokString, text: b.String(), pos: pos}, nil
+		}
+		if c == '\\' && l.off+1 < len(l.src) {
+			l.advance()
+			switch e := l.src[l.off]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(e)
+			}
+			l.advance()
+			continue
+		}
+		b.WriteByte(c)
+		l.advance()
+	}
+}
@@ -0,0 +1,570 @@
+package thriftidl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+var baseTypes = map[string]bool{
+	"bool": true, "byte": true, "i8": true, "i16": true, "i32": true, "i64": true,
+	"double": true, "string": true, "binary": true, "slist": true,
+}
+
+// ParseFile parses the .thrift file at filename, its includes are listed but
+// not parsed.
+func ParseFile(filename string) (*File, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filename, src)
+}
+
+// Parse parses src, filename is used in positions only. Errors are *Error.
+func Parse(filename string, src []byte) (*File, error) {
+	p := &parser{lex: newLexer(filename, src)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	f := &File{Name: filename}
+	if err := p.parseFile(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(pos Pos, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) unexpected(want string) error {
+	return p.errorf(p.tok.pos, "expected %s, found %s", want, p.tok.String())
+}
+
+func (p *parser) isPunct(c string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == c
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	return p.tok.kind == tokIdent && p.tok.text == kw
+}
+
+func (p *parser) expectPunct(c string) error {
+	if !p.isPunct(c) {
+		return p.unexpected("\"" + c + "\"")
+	}
+	return p.next()
+}
+
+func (p *parser) ident(what string) (string, Pos, error) {
+	if p.tok.kind != tokIdent {
+		return "", p.tok.pos, p.unexpected(what)
+	}
+	name, pos := p.tok.text, p.tok.pos
+	return name, pos, p.next()
+}
+
+func (p *parser) stringLit(what string) (string, error) {
+	if p.tok.kind != tokString {
+		return "", p.unexpected(what)
+	}
+	s := p.tok.text
+	return s, p.next()
+}
+
+// skipSeparator skips an optional list separator.
+func (p *parser) skipSeparator() error {
+	if p.isPunct(",") || p.isPunct(";") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) parseFile(f *File) error {
+	for p.tok.kind != tokEOF {
+		if p.tok.kind != tokIdent {
+			return p.unexpected("definition")
+		}
+		pos := p.tok.pos
+		var err error
+		switch p.tok.text {
+		case "include", "cpp_include":
+			kw := p.tok.text
+			if err = p.next(); err != nil {
+				return err
+			}
+			var path string
+			if path, err = p.stringLit("include path"); err == nil && kw == "include" {
+				f.Includes = append(f.Includes, &Include{Pos: pos, Path: path})
+			}
+		case "namespace":
+			err = p.parseNamespace(f, pos)
+		case "typedef":
+			err = p.parseTypedef(f, pos)
+		case "const":
+			err = p.parseConst(f, pos)
+		case "enum":
+			err = p.parseEnum(f, pos)
+		case "struct":
+			err = p.parseStruct(f, pos, StructKindStruct)
+		case "union":
+			err = p.parseStruct(f, pos, StructKindUnion)
+		case "exception":
+			err = p.parseStruct(f, pos, StructKindException)
+		case "service":
+			err = p.parseService(f, pos)
+		default:
+			return p.errorf(pos, "unknown definition %s", p.tok.String())
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.skipSeparator(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseNamespace(f *File, pos Pos) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	var scope string
+	if p.isPunct("*") {
+		scope = "*"
+		if err := p.next(); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if scope, _, err = p.ident("namespace scope"); err != nil {
+			return err
+		}
+	}
+	name, _, err := p.ident("namespace")
+	if err != nil {
+		return err
+	}
+	f.Namespaces = append(f.Namespaces, &Namespace{Pos: pos, Scope: scope, Name: name})
+	_, err = p.parseAnnotations()
+	return err
+}
+
+func (p *parser) parseTypedef(f *File, pos Pos) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return err
+	}
+	name, _, err := p.ident("typedef name")
+	if err != nil {
+		return err
+	}
+	t := &Typedef{Pos: pos, Name: name, Type: typ}
+	if t.Annotations, err = p.parseAnnotations(); err != nil {
+		return err
+	}
+	f.Typedefs = append(f.Typedefs, t)
+	return nil
+}
+
+func (p *parser) parseConst(f *File, pos Pos) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return err
+	}
+	name, _, err := p.ident("const name")
+	if err != nil {
+		return err
+	}
+	if err := p.expectPunct("="); err != nil {
+		return err
+	}
+	value, err := p.parseConstValue()
+	if err != nil {
+		return err
+	}
+	f.Consts = append(f.Consts, &Const{Pos: pos, Name: name, Type: typ, Value: value})
+	return nil
+}
+
+func (p *parser) parseEnum(f *File, pos Pos) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	name, _, err := p.ident("enum name")
+	if err != nil {
+		return err
+	}
+	e := &Enum{Pos: pos, Name: name}
+	if err := p.expectPunct("{"); err != nil {
+		return err
+	}
+	next := int64(0)
+	for !p.isPunct("}") {
+		vname, vpos, err := p.ident("enum value")
+		if err != nil {
+			return err
+		}
+		v := &EnumValue{Pos: vpos, Name: vname, Value: next}
+		if p.isPunct("=") {
+			if err := p.next(); err != nil {
+				return err
+			}
+			if p.tok.kind != tokInt {
+				return p.unexpected("integer")
+			}
+			if v.Value, err = parseInt(p.tok.text); err != nil {
+				return p.errorf(p.tok.pos, "bad integer %s", p.tok.text)
+			}
+			if err := p.next(); err != nil {
+				return err
+			}
+		}
+		if v.Annotations, err = p.parseAnnotations(); err != nil {
+			return err
+		}
+		if err := p.skipSeparator(); err != nil {
+			return err
+		}
+		next = v.Value + 1
+		e.Values = append(e.Values, v)
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if e.Annotations, err = p.parseAnnotations(); err != nil {
+		return err
+	}
+	f.Enums = append(f.Enums, e)
+	return nil
+}
+
+func (p *parser) parseStruct(f *File, pos Pos, kind StructKind) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	name, _, err := p.ident(kind.String() + " name")
+	if err != nil {
+		return err
+	}
+	s := &Struct{Pos: pos, Kind: kind, Name: name}
+	if err := p.expectPunct("{"); err != nil {
+		return err
+	}
+	if s.Fields, err = p.parseFields("}"); err != nil {
+		return err
+	}
+	if s.Annotations, err = p.parseAnnotations(); err != nil {
+		return err
+	}
+	f.Structs = append(f.Structs, s)
+	return nil
+}
+
+// parseFields parses fields up to and including end.
+func (p *parser) parseFields(end string) ([]*Field, error) {
+	var fields []*Field
+	implicitID := 0
+	ids := make(map[int]bool)
+	for !p.isPunct(end) {
+		if p.tok.kind == tokEOF {
+			return nil, p.unexpected(strconv.Quote(end))
+		}
+		field := &Field{Pos: p.tok.pos}
+		if p.tok.kind == tokInt {
+			id, err := parseInt(p.tok.text)
+			if err != nil || id < -32768 || id > 32767 {
+				return nil, p.errorf(p.tok.pos, "bad field id %s", p.tok.text)
+			}
+			field.ID = int(id)
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+		} else {
+			implicitID--
+			field.ID = implicitID
+		}
+		if ids[field.ID] {
+			return nil, p.errorf(field.Pos, "duplicate field id %d", field.ID)
+		}
+		ids[field.ID] = true
+		if p.isKeyword("required") || p.isKeyword("optional") {
+			field.Requiredness = Required
+			if p.tok.text == "optional" {
+				field.Requiredness = Optional
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if field.Type, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if field.Name, _, err = p.ident("field name"); err != nil {
+			return nil, err
+		}
+		if p.isPunct("=") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if field.Default, err = p.parseConstValue(); err != nil {
+				return nil, err
+			}
+		}
+		if field.Annotations, err = p.parseAnnotations(); err != nil {
+			return nil, err
+		}
+		if err := p.skipSeparator(); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, p.next()
+}
+
+func (p *parser) parseService(f *File, pos Pos) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	name, _, err := p.ident("service name")
+	if err != nil {
+		return err
+	}
+	s := &Service{Pos: pos, Name: name}
+	if p.isKeyword("extends") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if s.Extends, _, err = p.ident("service name"); err != nil {
+			return err
+		}
+	}
+	if err := p.expectPunct("{"); err != nil {
+		return err
+	}
+	for !p.isPunct("}") {
+		fn, err := p.parseFunction()
+		if err != nil {
+			return err
+		}
+		s.Functions = append(s.Functions, fn)
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if s.Annotations, err = p.parseAnnotations(); err != nil {
+		return err
+	}
+	f.Services = append(f.Services, s)
+	return nil
+}
+
+func (p *parser) parseFunction() (*Function, error) {
+	fn := &Function{Pos: p.tok.pos}
+	if p.isKeyword("oneway") {
+		fn.Oneway = true
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if p.isKeyword("void") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	} else if fn.ReturnType, err = p.parseType(); err != nil {
+		return nil, err
+	}
+	if fn.Name, _, err = p.ident("function name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	if fn.Params, err = p.parseFields(")"); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("throws") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		if fn.Throws, err = p.parseFields(")"); err != nil {
+			return nil, err
+		}
+	}
+	if fn.Oneway && (fn.ReturnType != nil || len(fn.Throws) > 0) {
+		return nil, p.errorf(fn.Pos, "oneway function %s must be void and throw nothing", fn.Name)
+	}
+	if fn.Annotations, err = p.parseAnnotations(); err != nil {
+		return nil, err
+	}
+	return fn, p.skipSeparator()
+}
+
+func (p *parser) parseType() (*Type, error) {
+	name, pos, err := p.ident("type")
+	if err != nil {
+		return nil, err
+	}
+	t := &Type{Pos: pos, Name: name}
+	switch name {
+	case "map", "set", "list":
+		if p.isKeyword("cpp_type") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if _, err := p.stringLit("cpp type"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectPunct("<"); err != nil {
+			return nil, err
+		}
+		if name == "map" {
+			if t.KeyType, err = p.parseType(); err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		if t.ValueType, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(">"); err != nil {
+			return nil, err
+		}
+	case "void", "required", "optional", "oneway", "throws":
+		return nil, p.errorf(pos, "expected type, found %q", name)
+	}
+	if t.Annotations, err = p.parseAnnotations(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *parser) parseAnnotations() ([]*Annotation, error) {
+	if !p.isPunct("(") {
+		return nil, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var annotations []*Annotation
+	for !p.isPunct(")") {
+		name, pos, err := p.ident("annotation")
+		if err != nil {
+			return nil, err
+		}
+		a := &Annotation{Pos: pos, Name: name, Value: "1"}
+		if p.isPunct("=") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if a.Value, err = p.stringLit("annotation value"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.skipSeparator(); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, p.next()
+}
+
+func (p *parser) parseConstValue() (*ConstValue, error) {
+	v := &ConstValue{Pos: p.tok.pos}
+	var err error
+	switch {
+	case p.tok.kind == tokInt:
+		v.Kind = ConstInt
+		if v.Int, err = parseInt(p.tok.text); err != nil {
+			return nil, p.errorf(p.tok.pos, "bad integer %s", p.tok.text)
+		}
+	case p.tok.kind == tokDouble:
+		v.Kind = ConstDouble
+		if v.Double, err = strconv.ParseFloat(p.tok.text, 64); err != nil {
+			return nil, p.errorf(p.tok.pos, "bad double %s", p.tok.text)
+		}
+	case p.tok.kind == tokString:
+		v.Kind = ConstString
+		v.String = p.tok.text
+	case p.tok.kind == tokIdent:
+		v.Kind = ConstIdentifier
+		v.String = p.tok.text
+	case p.isPunct("["):
+		v.Kind = ConstList
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.isPunct("]") {
+			elem, err := p.parseConstValue()
+			if err != nil {
+				return nil, err
+			}
+			v.List = append(v.List, elem)
+			if err := p.skipSeparator(); err != nil {
+				return nil, err
+			}
+		}
+	case p.isPunct("{"):
+		v.Kind = ConstMap
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.isPunct("}") {
+			key, err := p.parseConstValue()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseConstValue()
+			if err != nil {
+				return nil, err
+			}
+			v.Map = append(v.Map, &ConstMapEntry{Key: key, Value: value})
+			if err := p.skipSeparator(); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, p.unexpected("constant")
+	}
+	return v, p.next()
+}
+
+func parseInt(s string) (int64, error) {
+	return strconv.ParseInt(s, 0, 64)
+}
@@ -0,0 +1,198 @@
+package thriftidl
+
+import (
+	"testing"
+)
+
+func TestParseTracking(t *testing.T) {
+	f, err := ParseFile("../tracking.thrift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range f.Structs {
+		names = append(names, s.Name)
+	}
+	if len(names) != 4 || names[0] != "RequestHeader" || names[1] != "ResponseHeader" ||
+		names[2] != "UpgradeReply" || names[3] != "UpgradeArgs" {
+		t.Fatalf("structs = %v", names)
+	}
+	header := f.Struct("RequestHeader")
+	want := []struct {
+		id   int
+		name string
+		typ  string
+	}{
+		{1, "request_id", "string"},
+		{2, "seq", "string"},
+		{3, "meta", "map<string,string>"},
+	}
+	if len(header.Fields) != len(want) {
+		t.Fatalf("RequestHeader has %d fields", len(header.Fields))
+	}
+	for i, w := range want {
+		field := header.Fields[i]
+		if field.ID != w.id || field.Name != w.name || field.Type.String() != w.typ {
+			t.Errorf("field %d = %d: %s %s, want %d: %s %s", i, field.ID, field.Type, field.Name, w.id, w.typ, w.name)
+		}
+	}
+	if header.Pos.Line != 4 || header.Pos.Column != 1 {
+		t.Errorf("RequestHeader at %s", header.Pos)
+	}
+	if s := f.Struct("UpgradeReply"); len(s.Fields) != 0 {
+		t.Errorf("UpgradeReply has %d fields", len(s.Fields))
+	}
+	if s := f.Struct("UpgradeArgs"); len(s.Fields) != 1 || s.Fields[0].Name != "app_id" {
+		t.Errorf("UpgradeArgs fields = %v", s.Fields)
+	}
+}
+
+func TestParseCalculator(t *testing.T) {
+	f, err := ParseFile("../example/calculator.thrift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := f.Enum("CalculatorErrorCode")
+	if e == nil || len(e.Values) != 3 {
+		t.Fatalf("CalculatorErrorCode = %v", e)
+	}
+	for i, v := range e.Values {
+		if v.Value != int64(i) {
+			t.Errorf("%s = %d", v.Name, v.Value)
+		}
+	}
+	x := f.Struct("CalculatorUserException")
+	if x == nil || x.Kind != StructKindException || len(x.Fields) != 3 {
+		t.Fatalf("CalculatorUserException = %v", x)
+	}
+	if x.Fields[0].Requiredness != Required || x.Fields[0].Type.Name != "CalculatorErrorCode" {
+		t.Errorf("error_code = %v", x.Fields[0])
+	}
+	if x.Fields[2].Requiredness != Optional {
+		t.Errorf("message is not optional")
+	}
+
+	s := f.Service("CalculatorService")
+	if s == nil || len(s.Functions) != 3 {
+		t.Fatalf("CalculatorService = %v", s)
+	}
+	ping := s.Function("ping")
+	if ping.ReturnType.Name != "bool" || len(ping.Params) != 0 || len(ping.Throws) != 3 {
+		t.Errorf("ping = %v", ping)
+	}
+	add := s.Function("add")
+	if add.ReturnType.Name != "i32" || len(add.Params) != 2 || len(add.Throws) != 3 {
+		t.Fatalf("add = %v", add)
+	}
+	if add.Params[0].Name != "num1" || add.Params[1].ID != 2 {
+		t.Errorf("add params = %v, %v", add.Params[0], add.Params[1])
+	}
+	if add.Throws[2].Name != "unknown_exception" || add.Throws[2].Type.Name != "CalculatorUnknownException" {
+		t.Errorf("add throws = %v", add.Throws[2])
+	}
+	log := s.Function("log")
+	if !log.Oneway || log.ReturnType != nil || len(log.Params) != 1 {
+		t.Errorf("log = %v", log)
+	}
+}
+
+func TestParseDefinitions(t *testing.T) {
+	src := `
+include "shared.thrift"
+namespace go example.calc
+namespace * calc
+
+typedef list<shared.Item> (go.type = "Items") Items
+const i32 MAX = 0x10
+const map<string, list<double>> LIMITS = {"a": [1.5, -2], 'b': []}
+
+enum Color {
+	RED = 2,
+	GREEN,
+	BLUE = 10 (deprecated)
+}
+
+union Value {
+	i64 number
+	string text = "none" (go.tag = 'json:"text"')
+}
+
+service Derived extends shared.Base {
+	void reset() (idempotent)
+}
+`
+	f, err := Parse("defs.thrift", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Includes) != 1 || f.Includes[0].Path != "shared.thrift" {
+		t.Errorf("includes = %v", f.Includes)
+	}
+	if len(f.Namespaces) != 2 || f.Namespaces[0].Name != "example.calc" || f.Namespaces[1].Scope != "*" {
+		t.Errorf("namespaces = %v, %v", f.Namespaces[0], f.Namespaces[1])
+	}
+	td := f.Typedefs[0]
+	if td.Name != "Items" || td.Type.String() != "list<shared.Item>" ||
+		len(td.Type.Annotations) != 1 || td.Type.Annotations[0].Value != "Items" {
+		t.Errorf("typedef = %v", td)
+	}
+	if c := f.Consts[0]; c.Value.Kind != ConstInt || c.Value.Int != 16 {
+		t.Errorf("MAX = %v", c.Value)
+	}
+	limits := f.Consts[1].Value
+	if limits.Kind != ConstMap || len(limits.Map) != 2 {
+		t.Fatalf("LIMITS = %v", limits)
+	}
+	if a := limits.Map[0]; a.Key.String != "a" || len(a.Value.List) != 2 || a.Value.List[0].Double != 1.5 || a.Value.List[1].Int != -2 {
+		t.Errorf("LIMITS[a] = %v", a.Value)
+	}
+	color := f.Enum("Color")
+	if v := color.Values; v[0].Value != 2 || v[1].Value != 3 || v[2].Value != 10 {
+		t.Errorf("Color = %d, %d, %d", v[0].Value, v[1].Value, v[2].Value)
+	}
+	if a := color.Values[2].Annotations; len(a) != 1 || a[0].Name != "deprecated" || a[0].Value != "1" {
+		t.Errorf("BLUE annotations = %v", a)
+	}
+	value := f.Struct("Value")
+	if value.Kind != StructKindUnion || value.Fields[0].ID != -1 || value.Fields[1].ID != -2 {
+		t.Errorf("Value = %v", value)
+	}
+	if text := value.Fields[1]; text.Default.String != "none" || text.Annotations[0].Value != `json:"text"` {
+		t.Errorf("text = %v", text)
+	}
+	derived := f.Service("Derived")
+	if derived.Extends != "shared.Base" || derived.Functions[0].Annotations[0].Name != "idempotent" {
+		t.Errorf("Derived = %v", derived)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"struct A {\n  1: string a\n", `x.thrift:3:1: expected "}", found end of file`},
+		{"struct A {\n  1: string a,\n  1: i32 b\n}", "x.thrift:3:3: duplicate field id 1"},
+		{"enum E { A = x }", `x.thrift:1:14: expected integer, found "x"`},
+		{"service S {\n  oneway i32 f()\n}", "x.thrift:2:3: oneway function f must be void and throw nothing"},
+		{"struct A {\n  1: map<string> m\n}", `x.thrift:2:16: expected ",", found ">"`},
+		{"strukt A {}", `x.thrift:1:1: unknown definition "strukt"`},
+		{"const string S = \"abc\n", "x.thrift:1:18: string literal not terminated"},
+		{"/* struct A {}", "x.thrift:1:1: comment not terminated"},
+		{"struct A {\n\t1: string a @\n}", "x.thrift:2:14: unexpected character '@'"},
+		{"struct A { 1: required void a }", `x.thrift:1:24: expected type, found "void"`},
+	}
+	for _, c := range cases {
+		_, err := Parse("x.thrift", []byte(c.src))
+		if err == nil {
+			t.Errorf("%q: no error", c.src)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("%q: error %T is not *Error", c.src, err)
+		}
+		if err.Error() != c.want {
+			t.Errorf("%q:\n got %s\nwant %s", c.src, err, c.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package tracker
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/apache/thrift/lib/go/thrift"
@@ -15,7 +16,10 @@ const (
 	CtxKeySequenceID  ctxKey = "__thrift_tracking_sequence_id"
 	CtxKeyRequestID   ctxKey = "__thrift_tracking_request_id"
 	CtxKeyRequestMeta ctxKey = "__thrift_tracking_request_meta"
+	// CtxKeyPeerAppID is the app_id the client sent in its upgrade call.
+	CtxKeyPeerAppID ctxKey = "__thrift_tracking_peer_app_id"
 	// CtxKeyResponseMeta           ctxKey = "__thrift_tracking_response_meta"
+	ctxKeyTracking  ctxKey = "__thrift_tracking"
 	TrackingAPIName string = "__thriftpy_tracing_method_name__v2"
 )
 
@@ -26,9 +30,17 @@ type HandShaker interface {
 	// ResponseHeaderSupported() bool
 }
 
+// PipelinedHandShaker is implemented by trackers able to send the upgrade call
+// without waiting for its reply.
+type PipelinedHandShaker interface {
+	PipelinedNegotiation(curSeqID int32, oprot thrift.TProtocol) (recv func(iprot thrift.TProtocol) error, err error)
+}
+
 type Tracker interface {
 	HandShaker
 
+	// Name is the app_id sent in upgrade calls, e.g. the name of the service.
+	Name() string
 	RequestSeqIDFromCtx(ctx context.Context) (string, string)
 	TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) // context will pass into service handler
 	TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error
@@ -42,6 +54,11 @@ type SimpleTracker struct {
 	mu       *sync.RWMutex
 	upgraded bool
 	name     string
+	// headerErr is set once a request header fails to decode, the stream is
+	// out of sync from there on
+	headerErr error
+	// peerAppID is the app_id of the upgrade call, boxed once for contexts
+	peerAppID interface{}
 }
 
 func NewSimpleTrackerFactory(name string) func() Tracker {
@@ -58,77 +75,143 @@ func NewSimpleTracker(name string) Tracker {
 	}
 }
 
+func (t *SimpleTracker) Name() string {
+	return t.name
+}
+
 func (t *SimpleTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
-	// send
-	if err := oprot.WriteMessageBegin(TrackingAPIName, thrift.CALL, curSeqID); err != nil {
+	if isSimpleJSON(oprot) {
+		return nil // stays untracked
+	}
+	if err := t.writeUpgrade(curSeqID, oprot); err != nil {
 		return err
 	}
-	args := tracking.NewUpgradeArgs_()
-	args.AppID = t.name
-	if err := args.Write(oprot); err != nil {
+	if err := oprot.Flush(); err != nil {
 		return err
 	}
-	if err := oprot.WriteMessageEnd(); err != nil {
+	supported, err := t.readUpgradeReply(curSeqID, iprot)
+	if err != nil {
 		return err
 	}
-	if err := oprot.Flush(); err != nil {
+	if supported {
+		t.upgradeProtocol()
+	}
+	return nil
+}
+
+// PipelinedNegotiation writes the upgrade call without flushing it, so that it
+// goes out together with the first request, and switches to upgraded state
+// optimistically. The returned recv must read the upgrade reply before the
+// reply of that first request is read.
+func (t *SimpleTracker) PipelinedNegotiation(curSeqID int32, oprot thrift.TProtocol) (func(iprot thrift.TProtocol) error, error) {
+	if isSimpleJSON(oprot) {
+		return func(thrift.TProtocol) error { return nil }, nil
+	}
+	if err := t.writeUpgrade(curSeqID, oprot); err != nil {
+		return nil, err
+	}
+	t.upgradeProtocol()
+	recv := func(iprot thrift.TProtocol) error {
+		supported, err := t.readUpgradeReply(curSeqID, iprot)
+		if err != nil {
+			t.downgradeProtocol()
+			return err
+		}
+		if !supported {
+			// request header already sent, the peer can not parse it
+			t.downgradeProtocol()
+			return &Error{
+				Kind: ErrPeerUnsupported,
+				Err:  thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+TrackingAPIName),
+			}
+		}
+		return nil
+	}
+	return recv, nil
+}
+
+func (t *SimpleTracker) writeUpgrade(curSeqID int32, oprot thrift.TProtocol) error {
+	if err := oprot.WriteMessageBegin(TrackingAPIName, thrift.CALL, curSeqID); err != nil {
 		return err
 	}
+	args := tracking.NewUpgradeArgs_()
+	args.AppID = t.name
+	if err := args.Write(oprot); err != nil {
+		return err
+	}
+	return oprot.WriteMessageEnd()
+}
 
-	// recv
+// readUpgradeReply reports whether the peer supports tracker, an UNKNOWN_METHOD
+// exception means it does not.
+func (t *SimpleTracker) readUpgradeReply(curSeqID int32, iprot thrift.TProtocol) (bool, error) {
 	method, mTypeID, seqID, err := iprot.ReadMessageBegin()
 	if err != nil {
-		return err
+		return false, err
 	}
 	if method != TrackingAPIName {
-		return thrift.NewTApplicationException(thrift.WRONG_METHOD_NAME,
-			"tracker negotiation failed: wrong method name")
+		return false, &Error{
+			Kind: ErrHandshakeWrongMethod,
+			Err:  thrift.NewTApplicationException(thrift.WRONG_METHOD_NAME, "got "+method),
+		}
 	}
 	if curSeqID != seqID {
-		return thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID,
-			"tracker negotiation failed: out of sequence response")
+		return false, &Error{
+			Kind: ErrHandshakeOutOfSequence,
+			Err: thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID,
+				fmt.Sprintf("expected %d, got %d", curSeqID, seqID)),
+		}
 	}
 	if mTypeID == thrift.EXCEPTION {
 		err0 := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION,
 			"Unknown Exception")
 		var err1 thrift.TApplicationException
 		if err1, err = err0.Read(iprot); err != nil {
-			return err
+			return false, err
 		}
 		if err = iprot.ReadMessageEnd(); err != nil {
-			return err
+			return false, err
 		}
 		if err1.TypeId() == thrift.UNKNOWN_METHOD { // server does not support tracker, ignore
-			return nil
+			return false, nil
 		}
-		return err1
+		return false, err1
 	}
 	if mTypeID != thrift.REPLY {
-		return thrift.NewTApplicationException(thrift.INVALID_MESSAGE_TYPE_EXCEPTION,
+		return false, thrift.NewTApplicationException(thrift.INVALID_MESSAGE_TYPE_EXCEPTION,
 			"tracker negotiation failed: invalid message type")
 	}
 	reply := tracking.NewUpgradeReply()
 	if err := reply.Read(iprot); err != nil {
-		return err
+		return false, err
 	}
 	if err := iprot.ReadMessageEnd(); err != nil {
-		return err
+		return false, err
 	}
-	t.upgradeProtocol()
-	return nil
+	return true, nil
 }
 
+// TryUpgrade handles the upgrade call, seqID and the message begin are already
+// read. Upgrading an upgraded connection again is harmless.
 func (t *SimpleTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	if isSimpleJSON(iprot) {
+		if err := writeException(TrackingAPIName, seqID, errSimpleJSON, oprot); err != nil {
+			return false, err
+		}
+		return false, errSimpleJSON
+	}
 	args := tracking.NewUpgradeArgs_()
-	if err := args.Read(iprot); err != nil {
+	if err := args.Read(&limitedProtocol{iprot}); err != nil {
 		iprot.ReadMessageEnd()
 		x := thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error())
-		x.Write(oprot)
-		oprot.WriteMessageEnd()
-		oprot.Flush()
+		if err2 := writeException(TrackingAPIName, seqID, x, oprot); err2 != nil {
+			return false, err2
+		}
+		return false, err
+	}
+	if err := iprot.ReadMessageEnd(); err != nil {
 		return false, err
 	}
-	iprot.ReadMessageEnd()
 
 	result := tracking.NewUpgradeReply()
 	if err := oprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID); err != nil {
@@ -143,16 +226,38 @@ func (t *SimpleTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (
 	if err := oprot.Flush(); err != nil {
 		return false, err
 	}
+	t.mu.Lock()
+	t.peerAppID = args.AppID
+	t.mu.Unlock()
 	t.upgradeProtocol()
 	return true, nil
 }
 
+func writeException(name string, seqID int32, x thrift.TApplicationException, oprot thrift.TProtocol) error {
+	if err := oprot.WriteMessageBegin(name, thrift.EXCEPTION, seqID); err != nil {
+		return err
+	}
+	if err := x.Write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
 func (t *SimpleTracker) upgradeProtocol() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.upgraded = true
 }
 
+func (t *SimpleTracker) downgradeProtocol() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.upgraded = false
+}
+
 func (t *SimpleTracker) RequestHeaderSupported() bool {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -181,14 +286,23 @@ func (t *SimpleTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Co
 	if !t.RequestHeaderSupported() {
 		return context.TODO(), nil
 	}
+	t.mu.RLock()
+	headerErr, peerAppID := t.headerErr, t.peerAppID
+	t.mu.RUnlock()
+	if headerErr != nil {
+		return context.TODO(), headerErr
+	}
 	header := tracking.NewRequestHeader()
-	if err := header.Read(iprot); err != nil {
-		return context.TODO(), err
+	if err := header.Read(&limitedProtocol{iprot}); err != nil {
+		headerErr = &Error{Kind: ErrHeaderDecode, Err: err}
+		t.mu.Lock()
+		t.headerErr = headerErr
+		t.mu.Unlock()
+		return context.TODO(), headerErr
 	}
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, CtxKeyRequestID, header.GetRequestID())
-	ctx = context.WithValue(ctx, CtxKeySequenceID, header.GetSeq())
-	ctx = context.WithValue(ctx, CtxKeyRequestMeta, header.GetMeta())
+	// the strings and meta map are made by Read, the context can keep them
+	ctx := newTrackingCtx(context.Background(), header.RequestID, header.Seq, header.Meta)
+	ctx.peerAppID = peerAppID
 	return ctx, nil
 }
 
@@ -196,13 +310,19 @@ func (t *SimpleTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.
 	if !t.RequestHeaderSupported() {
 		return nil
 	}
-	header := tracking.NewRequestHeader()
-	if meta, ok := ctx.Value(CtxKeyRequestMeta).(map[string]string); ok {
-		header.Meta = make(map[string]string, len(meta))
-		for k, v := range meta {
-			header.Meta[k] = v
-		}
-	}
+	header := headerPool.Get().(*tracking.RequestHeader)
+	defer putHeader(header)
+	// meta is only read while writing, no need to copy it
+	header.Meta, _ = ctx.Value(CtxKeyRequestMeta).(map[string]string)
 	header.RequestID, header.Seq = t.RequestSeqIDFromCtx(ctx)
 	return header.Write(oprot)
 }
+
+var headerPool = sync.Pool{
+	New: func() interface{} { return tracking.NewRequestHeader() },
+}
+
+func putHeader(header *tracking.RequestHeader) {
+	*header = tracking.RequestHeader{}
+	headerPool.Put(header)
+}
@@ -0,0 +1,142 @@
+package tracker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+type brokenTransport struct {
+	*thrift.TMemoryBuffer
+}
+
+func (t *brokenTransport) Write(b []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func (t *brokenTransport) WriteByte(c byte) error {
+	return errors.New("broken pipe")
+}
+
+func (t *brokenTransport) WriteString(s string) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func upgradeArgs(appID string) func(oprot thrift.TProtocol) {
+	return func(oprot thrift.TProtocol) {
+		args := tracking.NewUpgradeArgs_()
+		args.AppID = appID
+		args.Write(oprot)
+		oprot.WriteMessageEnd()
+	}
+}
+
+func TestTryUpgrade(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    []func(oprot thrift.TProtocol) // one per upgrade call
+		broken   bool
+		ok       bool
+		reply    thrift.TMessageType
+		upgraded bool
+	}{
+		{
+			name:     "upgrade",
+			input:    []func(thrift.TProtocol){upgradeArgs("client")},
+			ok:       true,
+			reply:    thrift.REPLY,
+			upgraded: true,
+		},
+		{
+			name: "malformed args",
+			input: []func(thrift.TProtocol){func(oprot thrift.TProtocol) {
+				oprot.WriteStructBegin("UpgradeArgs")
+				oprot.WriteFieldBegin("app_id", thrift.STRING, 1)
+				oprot.WriteI32(-1) // negative string length
+				oprot.WriteFieldStop()
+				oprot.WriteStructEnd()
+			}},
+			reply: thrift.EXCEPTION,
+		},
+		{
+			name: "truncated input",
+			input: []func(thrift.TProtocol){func(oprot thrift.TProtocol) {
+				oprot.WriteStructBegin("UpgradeArgs")
+				oprot.WriteFieldBegin("app_id", thrift.STRING, 1)
+				oprot.WriteI32(16)
+				oprot.Transport().Write([]byte("client"))
+			}},
+			reply: thrift.EXCEPTION,
+		},
+		{
+			name:     "double upgrade",
+			input:    []func(thrift.TProtocol){upgradeArgs("client"), upgradeArgs("client")},
+			ok:       true,
+			reply:    thrift.REPLY,
+			upgraded: true,
+		},
+		{
+			name:   "broken output",
+			input:  []func(thrift.TProtocol){upgradeArgs("client")},
+			broken: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			in, out := thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+			var outTrans thrift.TTransport = out
+			if c.broken {
+				outTrans = &brokenTransport{out}
+			}
+			iprot := thrift.NewTBinaryProtocolTransport(in)
+			oprot := thrift.NewTBinaryProtocolTransport(outTrans)
+			tracker := NewSimpleTracker("server")
+
+			for i, write := range c.input {
+				seqID := int32(i + 1)
+				write(thrift.NewTBinaryProtocolTransport(in))
+				ok, err := tracker.TryUpgrade(seqID, iprot, oprot)
+				if ok != c.ok {
+					t.Fatalf("upgrade %d: got ok %v, want %v", seqID, ok, c.ok)
+				}
+				if ok != (err == nil) {
+					t.Fatalf("upgrade %d: got ok %v with error %v", seqID, ok, err)
+				}
+				if c.broken {
+					continue
+				}
+
+				rprot := thrift.NewTBinaryProtocolTransport(out)
+				name, mTypeID, replySeqID, err := rprot.ReadMessageBegin()
+				if err != nil {
+					t.Fatalf("upgrade %d: read reply: %v", seqID, err)
+				}
+				if name != TrackingAPIName || mTypeID != c.reply || replySeqID != seqID {
+					t.Fatalf("upgrade %d: got reply (%q, %v, %d), want (%q, %v, %d)",
+						seqID, name, mTypeID, replySeqID, TrackingAPIName, c.reply, seqID)
+				}
+				if mTypeID == thrift.EXCEPTION {
+					x := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "")
+					x, err := x.Read(rprot)
+					if err != nil {
+						t.Fatalf("upgrade %d: read exception: %v", seqID, err)
+					}
+					if x.TypeId() != thrift.PROTOCOL_ERROR {
+						t.Fatalf("upgrade %d: got exception type %d, want %d", seqID, x.TypeId(), thrift.PROTOCOL_ERROR)
+					}
+				} else if err := tracking.NewUpgradeReply().Read(rprot); err != nil {
+					t.Fatalf("upgrade %d: read reply body: %v", seqID, err)
+				}
+				if out.Len() != 0 {
+					t.Fatalf("upgrade %d: %d trailing bytes in reply", seqID, out.Len())
+				}
+			}
+			if tracker.RequestHeaderSupported() != c.upgraded {
+				t.Fatalf("got upgraded %v, want %v", tracker.RequestHeaderSupported(), c.upgraded)
+			}
+		})
+	}
+}
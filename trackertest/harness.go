@@ -0,0 +1,214 @@
+// Package trackertest wires tracked clients and processors together in memory,
+// so that tracking propagation can be unit tested without the network.
+package trackertest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+type config struct {
+	clientName      string
+	serverName      string
+	protocolFactory thrift.TProtocolFactory
+	oldClient       bool
+	oldServer       bool
+	headerReadErr   error
+	headerWriteErr  error
+}
+
+type Option func(*config)
+
+// WithNames names the client and server trackers, they default to "client"
+// and "server".
+func WithNames(client, server string) Option {
+	return func(c *config) {
+		c.clientName = client
+		c.serverName = server
+	}
+}
+
+// WithProtocolFactory replaces the default binary protocol.
+func WithProtocolFactory(f thrift.TProtocolFactory) Option {
+	return func(c *config) {
+		c.protocolFactory = f
+	}
+}
+
+// WithOldClient makes the client behave like one without tracker support.
+func WithOldClient() Option {
+	return func(c *config) {
+		c.oldClient = true
+	}
+}
+
+// WithOldServer makes the server behave like one without tracker support.
+func WithOldServer() Option {
+	return func(c *config) {
+		c.oldServer = true
+	}
+}
+
+// WithHeaderReadError makes the server fail reading every request header.
+func WithHeaderReadError(err error) Option {
+	return func(c *config) {
+		c.headerReadErr = err
+	}
+}
+
+// WithHeaderWriteError makes the client fail writing every request header.
+func WithHeaderWriteError(err error) Option {
+	return func(c *config) {
+		c.headerWriteErr = err
+	}
+}
+
+// Harness serves a processor over an in-memory pipe, a test builds its
+// tracked client with the generated factory, e.g.
+//
+//	h := trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+//		return calculator.NewCalculatorServiceProcessor(t, handler)
+//	})
+//	defer h.Close()
+//	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+type Harness struct {
+	ClientTracker   tracker.Tracker
+	ServerTracker   tracker.Tracker
+	Transport       thrift.TTransport
+	ProtocolFactory thrift.TProtocolFactory
+
+	mu      sync.Mutex
+	headers []*tracking.RequestHeader
+	closed  int32
+	done    chan error
+}
+
+func New(newProcessor tracker.NewProcessorFunc, opts ...Option) *Harness {
+	c := &config{
+		clientName:      "client",
+		serverName:      "server",
+		protocolFactory: thrift.NewTBinaryProtocolFactoryDefault(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	h := &Harness{
+		ProtocolFactory: c.protocolFactory,
+		done:            make(chan error, 1),
+	}
+	if c.oldClient {
+		h.ClientTracker = NewNoopTracker(c.clientName)
+	} else {
+		h.ClientTracker = tracker.NewSimpleTracker(c.clientName)
+	}
+	if c.headerWriteErr != nil {
+		h.ClientTracker = &faultyTracker{Tracker: h.ClientTracker, writeErr: c.headerWriteErr}
+	}
+	var serverTracker tracker.Tracker
+	if c.oldServer {
+		serverTracker = NewNoopTracker(c.serverName)
+	} else {
+		serverTracker = tracker.NewSimpleTracker(c.serverName)
+	}
+	if c.headerReadErr != nil {
+		serverTracker = &faultyTracker{Tracker: serverTracker, readErr: c.headerReadErr}
+	}
+	h.ServerTracker = &headerRecorder{Tracker: serverTracker, h: h}
+
+	client, server := NewPipe()
+	h.Transport = client
+	processor := newProcessor(h.ServerTracker)
+	go h.serve(processor, server)
+	return h
+}
+
+func (h *Harness) serve(processor thrift.TProcessor, trans thrift.TTransport) {
+	defer trans.Close()
+	iprot := h.ProtocolFactory.GetProtocol(trans)
+	oprot := h.ProtocolFactory.GetProtocol(trans)
+	for {
+		ok, err := processor.Process(iprot, oprot)
+		// exceptions are replied to, the connection carries on unless the
+		// processor drops it, as for strict rejections
+		if x, isApp := err.(thrift.TApplicationException); isApp && (ok || x.TypeId() == thrift.UNKNOWN_METHOD) {
+			continue
+		}
+		if err != nil {
+			if atomic.LoadInt32(&h.closed) == 1 {
+				err = nil // client went away
+			}
+			h.done <- err
+			return
+		}
+		if !ok {
+			h.done <- nil
+			return
+		}
+	}
+}
+
+// Headers returns the request headers read by the server so far.
+func (h *Harness) Headers() []*tracking.RequestHeader {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	headers := make([]*tracking.RequestHeader, len(h.headers))
+	copy(headers, h.headers)
+	return headers
+}
+
+// Close closes the client end and returns the error which stopped the
+// server, if any.
+func (h *Harness) Close() error {
+	atomic.StoreInt32(&h.closed, 1)
+	h.Transport.Close()
+	return <-h.done
+}
+
+// headerRecorder keeps every header its tracker reads.
+type headerRecorder struct {
+	tracker.Tracker
+	h *Harness
+}
+
+func (t *headerRecorder) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	supported := t.Tracker.RequestHeaderSupported()
+	ctx, err := t.Tracker.TryReadRequestHeader(iprot)
+	if err != nil || !supported {
+		return ctx, err
+	}
+	header := tracking.NewRequestHeader()
+	header.RequestID, _ = ctx.Value(tracker.CtxKeyRequestID).(string)
+	header.Seq, _ = ctx.Value(tracker.CtxKeySequenceID).(string)
+	header.Meta, _ = ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string)
+	t.h.mu.Lock()
+	t.h.headers = append(t.h.headers, header)
+	t.h.mu.Unlock()
+	return ctx, nil
+}
+
+// faultyTracker fails header reads and writes on purpose.
+type faultyTracker struct {
+	tracker.Tracker
+	readErr  error
+	writeErr error
+}
+
+func (t *faultyTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	if t.readErr != nil && t.Tracker.RequestHeaderSupported() {
+		return context.TODO(), t.readErr
+	}
+	return t.Tracker.TryReadRequestHeader(iprot)
+}
+
+func (t *faultyTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error {
+	if t.writeErr != nil && t.Tracker.RequestHeaderSupported() {
+		return t.writeErr
+	}
+	return t.Tracker.TryWriteRequestHeader(ctx, oprot)
+}
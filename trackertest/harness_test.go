@@ -0,0 +1,135 @@
+package trackertest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+)
+
+// handler keeps the context of the last call.
+type handler struct {
+	ctx context.Context
+}
+
+func (h *handler) Ping(ctx context.Context) (bool, error) {
+	h.ctx = ctx
+	return true, nil
+}
+
+func (h *handler) Add(ctx context.Context, num1, num2 int32) (int32, error) {
+	h.ctx = ctx
+	return num1 + num2, nil
+}
+
+func (h *handler) Log(ctx context.Context, message string) error {
+	h.ctx = ctx
+	return nil
+}
+
+func newHarness(h *handler, opts ...trackertest.Option) *trackertest.Harness {
+	return trackertest.New(func(t tracker.Tracker) thrift.TProcessor {
+		return calculator.NewCalculatorServiceProcessor(t, h)
+	}, opts...)
+}
+
+func newClient(t *testing.T, h *trackertest.Harness) *calculator.CalculatorServiceClient {
+	client, err := calculator.NewCalculatorServiceClientFactory(h.ClientTracker, h.Transport, h.ProtocolFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func requestCtx() context.Context {
+	ctx := context.WithValue(context.Background(), tracker.CtxKeyRequestID, "req-1")
+	return tracker.WithRequestMeta(ctx, "k", "v")
+}
+
+func TestHarness(t *testing.T) {
+	hd := &handler{}
+	h := newHarness(hd, trackertest.WithNames("billing", "calculator"))
+	client := newClient(t, h)
+	if sum, err := client.Add(requestCtx(), 1, 2); err != nil || sum != 3 {
+		t.Fatalf("got (%d, %v), want 3", sum, err)
+	}
+	if got := tracker.PeerAppIDFromCtx(hd.ctx); got != "billing" {
+		t.Fatalf("got peer app_id %q, want billing", got)
+	}
+	headers := h.Headers()
+	if len(headers) != 1 || headers[0].RequestID != "req-1" || headers[0].Meta["k"] != "v" {
+		t.Fatalf("got headers %v", headers)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHarnessOldPeers(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opt  trackertest.Option
+	}{
+		{"old client", trackertest.WithOldClient()},
+		{"old server", trackertest.WithOldServer()},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			hd := &handler{}
+			h := newHarness(hd, c.opt)
+			client := newClient(t, h)
+			if h.ClientTracker.RequestHeaderSupported() || h.ServerTracker.RequestHeaderSupported() {
+				t.Fatal("upgraded with an old peer")
+			}
+			if sum, err := client.Add(requestCtx(), 1, 2); err != nil || sum != 3 {
+				t.Fatalf("got (%d, %v), want 3", sum, err)
+			}
+			if _, ok := hd.ctx.Value(tracker.CtxKeyRequestID).(string); ok {
+				t.Fatal("request id reached the handler untracked")
+			}
+			if headers := h.Headers(); len(headers) != 0 {
+				t.Fatalf("got headers %v, want none", headers)
+			}
+			if err := h.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestHarnessHeaderReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	hd := &handler{}
+	h := newHarness(hd, trackertest.WithHeaderReadError(errRead))
+	client := newClient(t, h)
+	if _, err := client.Add(requestCtx(), 1, 2); err == nil {
+		t.Fatal("call succeeded without its header")
+	}
+	if hd.ctx != nil {
+		t.Fatal("call reached the handler")
+	}
+	// the error which stopped the server comes back from Close
+	if err := h.Close(); err != errRead {
+		t.Fatalf("got %v, want %v", err, errRead)
+	}
+}
+
+func TestHarnessHeaderWriteError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	hd := &handler{}
+	h := newHarness(hd, trackertest.WithHeaderWriteError(errWrite))
+	client := newClient(t, h)
+	if _, err := client.Add(requestCtx(), 1, 2); err != errWrite {
+		t.Fatalf("got %v, want %v", err, errWrite)
+	}
+	if hd.ctx != nil {
+		t.Fatal("call reached the handler")
+	}
+	// the server only saw the client go away
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
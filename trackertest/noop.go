@@ -0,0 +1,62 @@
+package trackertest
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+)
+
+// NoopTracker is never upgraded, it acts like a peer built without tracker
+// support: it never negotiates, never sends headers, and answers upgrade
+// calls the way a plain processor answers any unknown method.
+type NoopTracker struct {
+	tracker.Tracker
+}
+
+func NewNoopTracker(name string) tracker.Tracker {
+	return &NoopTracker{Tracker: tracker.NewSimpleTracker(name)}
+}
+
+func (t *NoopTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	return nil
+}
+
+func (t *NoopTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	return refuseUpgrade(seqID, iprot, oprot)
+}
+
+func (t *NoopTracker) RequestHeaderSupported() bool {
+	return false
+}
+
+func (t *NoopTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	return context.TODO(), nil
+}
+
+func (t *NoopTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error {
+	return nil
+}
+
+func refuseUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	if err := iprot.Skip(thrift.STRUCT); err != nil {
+		return false, err
+	}
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return false, err
+	}
+	x := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+tracker.TrackingAPIName)
+	if err := oprot.WriteMessageBegin(tracker.TrackingAPIName, thrift.EXCEPTION, seqID); err != nil {
+		return false, err
+	}
+	if err := x.Write(oprot); err != nil {
+		return false, err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return false, err
+	}
+	if err := oprot.Flush(); err != nil {
+		return false, err
+	}
+	return false, x
+}
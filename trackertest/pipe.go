@@ -0,0 +1,13 @@
+package trackertest
+
+import (
+	"net"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// NewPipe returns the two ends of an in-memory, full duplex connection.
+func NewPipe() (client, server thrift.TTransport) {
+	c, s := net.Pipe()
+	return thrift.NewTSocketFromConnTimeout(c, 0), thrift.NewTSocketFromConnTimeout(s, 0)
+}
@@ -0,0 +1,140 @@
+package trackertest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+// HeaderWrite is one TryWriteRequestHeader call seen by a RecordingTracker,
+// Header is nil if no header was written since the tracker was not upgraded.
+type HeaderWrite struct {
+	Ctx    context.Context
+	Header *tracking.RequestHeader
+}
+
+// RecordingTracker records every header it writes or reads. Its negotiation
+// does no I/O, so that generated clients can be tested without a server: it
+// just switches to upgraded state unless RefuseUpgrade is set. As a server it
+// has the embedded Tracker answer upgrade calls and then read the headers, so
+// that contexts carry the app_id of the peer, refusing them with
+// UNKNOWN_METHOD if RefuseUpgrade is set.
+type RecordingTracker struct {
+	tracker.Tracker
+	RefuseUpgrade bool
+
+	mu       sync.Mutex
+	upgraded bool
+	writes   []HeaderWrite
+	reads    []*tracking.RequestHeader
+}
+
+func NewRecordingTracker(name string) *RecordingTracker {
+	return &RecordingTracker{Tracker: tracker.NewSimpleTracker(name)}
+}
+
+// SetUpgraded forces the upgrade state, e.g. to feed headers to a processor
+// without an upgrade call first.
+func (t *RecordingTracker) SetUpgraded(upgraded bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.upgraded = upgraded
+}
+
+func (t *RecordingTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	t.SetUpgraded(!t.RefuseUpgrade)
+	return nil
+}
+
+func (t *RecordingTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	if t.RefuseUpgrade {
+		return refuseUpgrade(seqID, iprot, oprot)
+	}
+	ok, err := t.Tracker.TryUpgrade(seqID, iprot, oprot)
+	if ok {
+		t.SetUpgraded(true)
+	}
+	return ok, err
+}
+
+func (t *RecordingTracker) RequestHeaderSupported() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.upgraded
+}
+
+func (t *RecordingTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	if !t.RequestHeaderSupported() {
+		return context.TODO(), nil
+	}
+	if t.Tracker.RequestHeaderSupported() {
+		// upgraded by TryUpgrade
+		ctx, err := t.Tracker.TryReadRequestHeader(iprot)
+		if err != nil {
+			return ctx, err
+		}
+		header := tracking.NewRequestHeader()
+		header.RequestID, _ = ctx.Value(tracker.CtxKeyRequestID).(string)
+		header.Seq, _ = ctx.Value(tracker.CtxKeySequenceID).(string)
+		header.Meta, _ = ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string)
+		t.mu.Lock()
+		t.reads = append(t.reads, header)
+		t.mu.Unlock()
+		return ctx, nil
+	}
+	header := tracking.NewRequestHeader()
+	if err := header.Read(iprot); err != nil {
+		return context.TODO(), &tracker.Error{Kind: tracker.ErrHeaderDecode, Err: err}
+	}
+	t.mu.Lock()
+	t.reads = append(t.reads, header)
+	t.mu.Unlock()
+	return tracker.WithTracking(context.Background(), &tracker.Tracking{
+		RequestID: header.GetRequestID(),
+		Seq:       header.GetSeq(),
+		Meta:      header.GetMeta(),
+	}), nil
+}
+
+func (t *RecordingTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error {
+	write := HeaderWrite{Ctx: ctx}
+	defer func() {
+		t.mu.Lock()
+		t.writes = append(t.writes, write)
+		t.mu.Unlock()
+	}()
+	if !t.RequestHeaderSupported() {
+		return nil
+	}
+	header := tracking.NewRequestHeader()
+	if meta, ok := ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string); ok {
+		header.Meta = make(map[string]string, len(meta))
+		for k, v := range meta {
+			header.Meta[k] = v
+		}
+	}
+	header.RequestID, header.Seq = t.RequestSeqIDFromCtx(ctx)
+	write.Header = header
+	return header.Write(oprot)
+}
+
+// Writes returns the TryWriteRequestHeader calls so far.
+func (t *RecordingTracker) Writes() []HeaderWrite {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	writes := make([]HeaderWrite, len(t.writes))
+	copy(writes, t.writes)
+	return writes
+}
+
+// Reads returns the headers read so far.
+func (t *RecordingTracker) Reads() []*tracking.RequestHeader {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reads := make([]*tracking.RequestHeader, len(t.reads))
+	copy(reads, t.reads)
+	return reads
+}
@@ -0,0 +1,137 @@
+package trackertest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/trackertest"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+// logCall has client send a oneway log call into a buffer, as a server
+// would read it.
+func logCall(t *testing.T, client tracker.Tracker, ctx context.Context) *thrift.TMemoryBuffer {
+	buf := thrift.NewTMemoryBuffer()
+	c, err := calculator.NewCalculatorServiceClientFactory(client, buf, thrift.NewTBinaryProtocolFactoryDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Log(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestRecordingTrackerClient(t *testing.T) {
+	rt := trackertest.NewRecordingTracker("client")
+	ctx := requestCtx()
+	logCall(t, rt, ctx)
+	writes := rt.Writes()
+	if len(writes) != 1 || writes[0].Ctx != ctx {
+		t.Fatalf("got writes %v", writes)
+	}
+	if h := writes[0].Header; h == nil || h.RequestID != "req-1" || h.Meta["k"] != "v" {
+		t.Fatalf("got header %v", h)
+	}
+
+	refused := trackertest.NewRecordingTracker("client")
+	refused.RefuseUpgrade = true
+	logCall(t, refused, ctx)
+	if writes := refused.Writes(); len(writes) != 1 || writes[0].Header != nil {
+		t.Fatalf("got writes %v, want one without header", writes)
+	}
+}
+
+func TestRecordingTrackerServer(t *testing.T) {
+	buf := logCall(t, trackertest.NewRecordingTracker("client"), requestCtx())
+	rt := trackertest.NewRecordingTracker("server")
+	rt.SetUpgraded(true)
+	hd := &handler{}
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	if _, err := calculator.NewCalculatorServiceProcessor(rt, hd).Process(prot, prot); err != nil {
+		t.Fatal(err)
+	}
+	reads := rt.Reads()
+	if len(reads) != 1 || reads[0].RequestID != "req-1" || reads[0].Meta["k"] != "v" {
+		t.Fatalf("got reads %v", reads)
+	}
+	if got := hd.ctx.Value(tracker.CtxKeyRequestID); got != "req-1" {
+		t.Fatalf("got request id %v in the handler", got)
+	}
+}
+
+// upgrade feeds an upgrade call to a processor bound to server and returns
+// the type of its reply.
+func upgrade(t *testing.T, server tracker.Tracker) thrift.TMessageType {
+	in, out := thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+	iprot, oprot := thrift.NewTBinaryProtocolTransport(in), thrift.NewTBinaryProtocolTransport(out)
+	iprot.WriteMessageBegin(tracker.TrackingAPIName, thrift.CALL, 1)
+	tracking.NewUpgradeArgs_().Write(iprot)
+	iprot.WriteMessageEnd()
+	calculator.NewCalculatorServiceProcessor(server, &handler{}).Process(iprot, oprot)
+	_, mTypeID, seqID, err := oprot.ReadMessageBegin()
+	if err != nil || seqID != 1 {
+		t.Fatalf("got reply seqid %d, %v", seqID, err)
+	}
+	return mTypeID
+}
+
+func TestRecordingTrackerUpgrade(t *testing.T) {
+	rt := trackertest.NewRecordingTracker("server")
+	if got := upgrade(t, rt); got != thrift.REPLY || !rt.RequestHeaderSupported() {
+		t.Fatalf("got %v, want an accepted upgrade", got)
+	}
+	rt = trackertest.NewRecordingTracker("server")
+	rt.RefuseUpgrade = true
+	if got := upgrade(t, rt); got != thrift.EXCEPTION || rt.RequestHeaderSupported() {
+		t.Fatalf("got %v, want a refused upgrade", got)
+	}
+}
+
+func TestRecordingTrackerPeerAppID(t *testing.T) {
+	rt := trackertest.NewRecordingTracker("server")
+	in, out := thrift.NewTMemoryBuffer(), thrift.NewTMemoryBuffer()
+	iprot, oprot := thrift.NewTBinaryProtocolTransport(in), thrift.NewTBinaryProtocolTransport(out)
+	iprot.WriteMessageBegin(tracker.TrackingAPIName, thrift.CALL, 1)
+	args := tracking.NewUpgradeArgs_()
+	args.AppID = "billing"
+	args.Write(iprot)
+	iprot.WriteMessageEnd()
+	hd := &handler{}
+	processor := calculator.NewCalculatorServiceProcessor(rt, hd)
+	if _, err := processor.Process(iprot, oprot); err != nil {
+		t.Fatal(err)
+	}
+	client := trackertest.NewRecordingTracker("client")
+	client.SetUpgraded(true)
+	buf := logCall(t, client, requestCtx())
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	if _, err := processor.Process(prot, prot); err != nil {
+		t.Fatal(err)
+	}
+	if got := tracker.PeerAppIDFromCtx(hd.ctx); got != "billing" {
+		t.Fatalf("got peer app_id %q, want billing", got)
+	}
+	if reads := rt.Reads(); len(reads) != 1 || reads[0].RequestID != "req-1" || reads[0].Meta["k"] != "v" {
+		t.Fatalf("got reads %v", reads)
+	}
+}
+
+func TestNoopTracker(t *testing.T) {
+	nt := trackertest.NewNoopTracker("server")
+	if got := upgrade(t, nt); got != thrift.EXCEPTION || nt.RequestHeaderSupported() {
+		t.Fatalf("got %v, want a refused upgrade", got)
+	}
+	// as a client it writes no upgrade call and no header
+	if buf := logCall(t, trackertest.NewNoopTracker("client"), requestCtx()); buf.Len() == 0 {
+		t.Fatal("log call not written")
+	} else {
+		prot := thrift.NewTBinaryProtocolTransport(buf)
+		if name, _, _, err := prot.ReadMessageBegin(); err != nil || name != "log" {
+			t.Fatalf("got message %q, %v, want log first", name, err)
+		}
+	}
+}
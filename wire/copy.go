@@ -0,0 +1,168 @@
+package wire
+
+import (
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Copy reads a value of type t, e.g. the args struct of a message, from in
+// and writes it to out, which may use another protocol.
+func Copy(in, out thrift.TProtocol, t thrift.TType) error {
+	return copyValue(in, out, t, false, 0)
+}
+
+// CopySame is Copy reading strings with ReadString instead of ReadBinary.
+// TJSONProtocol can not tell binary fields from strings, between two of its
+// protocols binary fields keep their base64 encoding.
+func CopySame(in, out thrift.TProtocol, t thrift.TType) error {
+	return copyValue(in, out, t, true, 0)
+}
+
+func copyValue(in, out thrift.TProtocol, t thrift.TType, same bool, depth int) error {
+	if depth > maxDepth {
+		return thrift.NewTProtocolExceptionWithType(thrift.DEPTH_LIMIT, fmt.Errorf("depth limit exceeded"))
+	}
+	switch t {
+	case thrift.BOOL:
+		v, err := in.ReadBool()
+		if err != nil {
+			return err
+		}
+		return out.WriteBool(v)
+	case thrift.BYTE:
+		v, err := in.ReadByte()
+		if err != nil {
+			return err
+		}
+		return out.WriteByte(v)
+	case thrift.I16:
+		v, err := in.ReadI16()
+		if err != nil {
+			return err
+		}
+		return out.WriteI16(v)
+	case thrift.I32:
+		v, err := in.ReadI32()
+		if err != nil {
+			return err
+		}
+		return out.WriteI32(v)
+	case thrift.I64:
+		v, err := in.ReadI64()
+		if err != nil {
+			return err
+		}
+		return out.WriteI64(v)
+	case thrift.DOUBLE:
+		v, err := in.ReadDouble()
+		if err != nil {
+			return err
+		}
+		return out.WriteDouble(v)
+	case thrift.STRING:
+		if same {
+			v, err := in.ReadString()
+			if err != nil {
+				return err
+			}
+			return out.WriteString(v)
+		}
+		v, err := in.ReadBinary()
+		if err != nil {
+			return err
+		}
+		return out.WriteBinary(v)
+	case thrift.STRUCT:
+		name, err := in.ReadStructBegin()
+		if err != nil {
+			return err
+		}
+		if err := out.WriteStructBegin(name); err != nil {
+			return err
+		}
+		for {
+			name, ft, id, err := in.ReadFieldBegin()
+			if err != nil {
+				return err
+			}
+			if ft == thrift.STOP {
+				break
+			}
+			if err := out.WriteFieldBegin(name, ft, id); err != nil {
+				return err
+			}
+			if err := copyValue(in, out, ft, same, depth+1); err != nil {
+				return err
+			}
+			if err := in.ReadFieldEnd(); err != nil {
+				return err
+			}
+			if err := out.WriteFieldEnd(); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadStructEnd(); err != nil {
+			return err
+		}
+		if err := out.WriteFieldStop(); err != nil {
+			return err
+		}
+		return out.WriteStructEnd()
+	case thrift.MAP:
+		kt, vt, size, err := in.ReadMapBegin()
+		if err != nil {
+			return err
+		}
+		if err := out.WriteMapBegin(kt, vt, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(in, out, kt, same, depth+1); err != nil {
+				return err
+			}
+			if err := copyValue(in, out, vt, same, depth+1); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadMapEnd(); err != nil {
+			return err
+		}
+		return out.WriteMapEnd()
+	case thrift.SET:
+		et, size, err := in.ReadSetBegin()
+		if err != nil {
+			return err
+		}
+		if err := out.WriteSetBegin(et, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(in, out, et, same, depth+1); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadSetEnd(); err != nil {
+			return err
+		}
+		return out.WriteSetEnd()
+	case thrift.LIST:
+		et, size, err := in.ReadListBegin()
+		if err != nil {
+			return err
+		}
+		if err := out.WriteListBegin(et, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(in, out, et, same, depth+1); err != nil {
+				return err
+			}
+		}
+		if err := in.ReadListEnd(); err != nil {
+			return err
+		}
+		return out.WriteListEnd()
+	}
+	return thrift.NewTProtocolExceptionWithType(thrift.INVALID_DATA, fmt.Errorf("unknown type %d", t))
+}
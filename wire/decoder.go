@@ -0,0 +1,450 @@
+// Package wire decodes captured byte streams of tracked thrift connections,
+// one direction at a time: the upgrade handshake, request headers and
+// messages with their args or results.
+//
+// Unless the upgrade state of the connection is known, see SetHeaderMode, a
+// request header is told apart from a message by its first byte, so only
+// strict binary messages, the default of thrift, are supported.
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+)
+
+// DefaultMaxFrameSize is the largest frame decoded when Options.MaxFrameSize
+// is 0, as thrift.TFramedTransport.
+const DefaultMaxFrameSize = 16384000
+
+// maxDepth bounds the nesting of decoded and copied values.
+const maxDepth = 64
+
+type Options struct {
+	Framed       bool
+	Compact      bool
+	MaxFrameSize uint32
+	IDL          *IDL // optional, names fields and enum values
+}
+
+// Header is the request header written before a call, or the response
+// header written before a reply, which has Meta only.
+type Header struct {
+	RequestID string            `json:"request_id,omitempty"`
+	Seq       string            `json:"seq,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+type Message struct {
+	Offset int64   // in the stream, of the header if any
+	Header *Header // nil when the connection is not upgraded
+	Name   string
+	Type   thrift.TMessageType
+	SeqID  int32
+	Body   *Struct // args, result or exception
+}
+
+// Upgrade reports whether m is the upgrade call or its reply.
+func (m *Message) Upgrade() bool {
+	return m.Name == tracker.TrackingAPIName
+}
+
+// TypeName returns e.g. "call" for thrift.CALL.
+func TypeName(t thrift.TMessageType) string {
+	switch t {
+	case thrift.CALL:
+		return "call"
+	case thrift.REPLY:
+		return "reply"
+	case thrift.EXCEPTION:
+		return "exception"
+	case thrift.ONEWAY:
+		return "oneway"
+	}
+	return fmt.Sprintf("type(%d)", t)
+}
+
+// Error is a decode error at Offset, the stream can not be decoded further.
+type Error struct {
+	Offset int64
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+}
+
+// HeaderMode tells whether a header precedes the next message.
+type HeaderMode int
+
+const (
+	// HeaderAuto tells a header from a message by its first byte.
+	HeaderAuto HeaderMode = iota
+	// HeaderNever is for connections not upgraded, or before the upgrade
+	// reply.
+	HeaderNever
+	// HeaderAlways is for calls after an accepted upgrade.
+	HeaderAlways
+)
+
+type Decoder struct {
+	opts    Options
+	src     *transport
+	in      *transport // src, or the current frame
+	base    int64      // offset of in
+	prot    thrift.TProtocol
+	headers HeaderMode
+	err     error
+}
+
+func NewDecoder(r io.Reader, opts Options) *Decoder {
+	if opts.MaxFrameSize == 0 {
+		opts.MaxFrameSize = DefaultMaxFrameSize
+	}
+	d := &Decoder{opts: opts, src: newTransport(r, -1)}
+	if !opts.Framed {
+		d.setInput(d.src, 0)
+	}
+	return d
+}
+
+func (d *Decoder) setInput(in *transport, base int64) {
+	d.in, d.base = in, base
+	if d.opts.Compact {
+		d.prot = thrift.NewTCompactProtocol(in)
+	} else {
+		d.prot = thrift.NewTBinaryProtocolTransport(in)
+	}
+}
+
+// SetHeaderMode sets whether headers precede the next messages, HeaderAuto
+// by default.
+func (d *Decoder) SetHeaderMode(mode HeaderMode) {
+	d.headers = mode
+}
+
+// Offset returns the offset in the stream of the next message, or of its
+// frame.
+func (d *Decoder) Offset() int64 {
+	if d.in == nil || (d.opts.Framed && d.in.remaining == 0) {
+		return d.src.n
+	}
+	return d.base + d.in.n
+}
+
+// Next returns the next message, or io.EOF at the end of the stream. Errors
+// are *Error and sticky.
+func (d *Decoder) Next() (*Message, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	m, err := d.next()
+	if err != nil {
+		d.err = err
+	}
+	return m, err
+}
+
+func (d *Decoder) next() (*Message, error) {
+	if d.opts.Framed {
+		for d.in == nil || d.in.remaining == 0 {
+			if err := d.nextFrame(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	offset := d.base + d.in.n
+	isMessage, err := d.atMessage()
+	if err == io.EOF && !d.opts.Framed {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, d.errorf(offset, err)
+	}
+	switch d.headers {
+	case HeaderNever:
+		isMessage = true
+	case HeaderAlways:
+		isMessage = false
+	}
+	var header *Struct
+	if !isMessage {
+		if header, err = readStruct(d.prot, builtinSchema("RequestHeader"), 0); err != nil {
+			return nil, d.errorf(offset, fmt.Errorf("header: %v", err))
+		}
+		if isMessage, err = d.atMessage(); err == nil && !isMessage && d.headers == HeaderAuto {
+			err = fmt.Errorf("no message after header")
+		}
+		if err != nil {
+			return nil, d.errorf(d.base+d.in.n, err)
+		}
+	}
+	m := &Message{Offset: offset}
+	if m.Name, m.Type, m.SeqID, err = d.prot.ReadMessageBegin(); err != nil {
+		return nil, d.errorf(offset, err)
+	}
+	if m.Body, err = readStruct(d.prot, d.schema(m.Name, m.Type), 0); err != nil {
+		return nil, d.errorf(offset, fmt.Errorf("%s %s: %v", TypeName(m.Type), m.Name, err))
+	}
+	if err = d.prot.ReadMessageEnd(); err != nil {
+		return nil, d.errorf(offset, err)
+	}
+	if header != nil {
+		m.Header = newHeader(header, m.Type)
+	}
+	return m, nil
+}
+
+func (d *Decoder) errorf(offset int64, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &Error{Offset: offset, Err: err}
+}
+
+func (d *Decoder) nextFrame() error {
+	offset := d.src.n
+	var size [4]byte
+	if _, err := io.ReadFull(d.src, size[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return d.errorf(offset, err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > d.opts.MaxFrameSize {
+		return d.errorf(offset, fmt.Errorf("frame of %d bytes is too large", n))
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(d.src, frame); err != nil {
+		return d.errorf(offset, err)
+	}
+	d.setInput(newTransport(bytes.NewReader(frame), int64(n)), offset+4)
+	return nil
+}
+
+// atMessage reports whether a message, rather than a header, comes next.
+func (d *Decoder) atMessage() (bool, error) {
+	b, err := d.in.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	if d.opts.Compact {
+		return b[0] == 0x82, nil // COMPACT_PROTOCOL_ID
+	}
+	return b[0] == 0x80, nil // high byte of VERSION_1
+}
+
+func (d *Decoder) schema(name string, t thrift.TMessageType) *structSchema {
+	if t == thrift.EXCEPTION {
+		return builtinSchema("TApplicationException")
+	}
+	if name == tracker.TrackingAPIName {
+		if t == thrift.REPLY {
+			return builtinSchema("UpgradeReply")
+		}
+		return builtinSchema("UpgradeArgs")
+	}
+	if d.opts.IDL == nil {
+		return nil
+	}
+	f, fn := d.opts.IDL.function(name)
+	if fn == nil {
+		return nil
+	}
+	if t == thrift.REPLY {
+		return resultSchema(f, fn)
+	}
+	return argsSchema(f, fn)
+}
+
+// newHeader reads a header decoded as a RequestHeader, as a ResponseHeader
+// when it precedes a reply.
+func newHeader(s *Struct, t thrift.TMessageType) *Header {
+	h := &Header{}
+	metaID := int16(3)
+	if t == thrift.REPLY || t == thrift.EXCEPTION {
+		metaID = 1
+	} else {
+		h.RequestID, _ = s.Field(1).(string)
+		h.Seq, _ = s.Field(2).(string)
+	}
+	if meta, ok := s.Field(metaID).(Map); ok {
+		h.Meta = make(map[string]string, len(meta))
+		for _, e := range meta {
+			k, _ := e.Key.(string)
+			v, _ := e.Value.(string)
+			h.Meta[k] = v
+		}
+	}
+	return h
+}
+
+func readStruct(p thrift.TProtocol, schema *structSchema, depth int) (*Struct, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("values nested too deeply")
+	}
+	if _, err := p.ReadStructBegin(); err != nil {
+		return nil, err
+	}
+	s := &Struct{}
+	if schema != nil {
+		s.Name = schema.name
+	}
+	for {
+		_, t, id, err := p.ReadFieldBegin()
+		if err != nil {
+			return nil, err
+		}
+		if t == thrift.STOP {
+			break
+		}
+		f := &Field{ID: id}
+		var ref typeRef
+		f.Name, ref = schema.field(id)
+		if f.Value, err = readValue(p, t, ref, depth+1); err != nil {
+			return nil, err
+		}
+		if err := p.ReadFieldEnd(); err != nil {
+			return nil, err
+		}
+		s.Fields = append(s.Fields, f)
+	}
+	return s, p.ReadStructEnd()
+}
+
+func readValue(p thrift.TProtocol, t thrift.TType, ref typeRef, depth int) (interface{}, error) {
+	switch t {
+	case thrift.BOOL:
+		return p.ReadBool()
+	case thrift.BYTE:
+		return p.ReadByte()
+	case thrift.I16:
+		return p.ReadI16()
+	case thrift.I32:
+		v, err := p.ReadI32()
+		if err != nil {
+			return nil, err
+		}
+		if e := ref.enum(); e != nil {
+			for _, ev := range e.Values {
+				if ev.Value == int64(v) {
+					return Enum{Name: ev.Name, Value: v}, nil
+				}
+			}
+			return Enum{Value: v}, nil
+		}
+		return v, nil
+	case thrift.I64:
+		return p.ReadI64()
+	case thrift.DOUBLE:
+		return p.ReadDouble()
+	case thrift.STRING:
+		return p.ReadString()
+	case thrift.STRUCT:
+		return readStruct(p, ref.schema(), depth)
+	case thrift.MAP:
+		kt, vt, size, err := p.ReadMapBegin()
+		if err != nil {
+			return nil, err
+		}
+		m := make(Map, 0, capacity(size))
+		for i := 0; i < size; i++ {
+			k, err := readValue(p, kt, ref.key(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readValue(p, vt, ref.elem(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, MapEntry{k, v})
+		}
+		return m, p.ReadMapEnd()
+	case thrift.SET, thrift.LIST:
+		var et thrift.TType
+		var size int
+		var err error
+		if t == thrift.SET {
+			et, size, err = p.ReadSetBegin()
+		} else {
+			et, size, err = p.ReadListBegin()
+		}
+		if err != nil {
+			return nil, err
+		}
+		l := make([]interface{}, 0, capacity(size))
+		for i := 0; i < size; i++ {
+			v, err := readValue(p, et, ref.elem(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		if t == thrift.SET {
+			return Set(l), p.ReadSetEnd()
+		}
+		return l, p.ReadListEnd()
+	}
+	return nil, fmt.Errorf("unknown type %d", t)
+}
+
+// capacity does not trust sizes read from the stream.
+func capacity(size int) int {
+	if size > 1024 {
+		return 1024
+	}
+	return size
+}
+
+// transport counts the bytes read for offsets, and can peek.
+type transport struct {
+	*bufio.Reader
+	n         int64
+	remaining int64 // -1 when unknown
+}
+
+func newTransport(r io.Reader, size int64) *transport {
+	return &transport{Reader: bufio.NewReader(r), remaining: size}
+}
+
+func (t *transport) Read(b []byte) (int, error) {
+	n, err := t.Reader.Read(b)
+	t.consumed(n)
+	return n, err
+}
+
+func (t *transport) ReadByte() (byte, error) {
+	c, err := t.Reader.ReadByte()
+	if err == nil {
+		t.consumed(1)
+	}
+	return c, err
+}
+
+func (t *transport) consumed(n int) {
+	t.n += int64(n)
+	if t.remaining > 0 {
+		t.remaining -= int64(n)
+	}
+}
+
+func (t *transport) RemainingBytes() uint64 {
+	if t.remaining < 0 {
+		return ^uint64(0)
+	}
+	return uint64(t.remaining)
+}
+
+func (t *transport) Write(b []byte) (int, error)     { return 0, io.ErrClosedPipe }
+func (t *transport) WriteByte(c byte) error          { return io.ErrClosedPipe }
+func (t *transport) WriteString(string) (int, error) { return 0, io.ErrClosedPipe }
+func (t *transport) Flush() error                    { return nil }
+func (t *transport) Open() error                     { return nil }
+func (t *transport) IsOpen() bool                    { return true }
+func (t *transport) Close() error                    { return nil }
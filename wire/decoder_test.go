@@ -0,0 +1,341 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/eleme/thrift-tracker"
+	"github.com/eleme/thrift-tracker/example/calculator"
+	"github.com/eleme/thrift-tracker/tracking"
+)
+
+type testMessage struct {
+	header *tracking.RequestHeader
+	name   string
+	typ    thrift.TMessageType
+	body   interface{ Write(thrift.TProtocol) error }
+}
+
+func testHeader() *tracking.RequestHeader {
+	return &tracking.RequestHeader{RequestID: "req-1", Seq: "1.1", Meta: map[string]string{"k": "v"}}
+}
+
+func int32Ptr(v int32) *int32 { return &v }
+
+var testMessages = []testMessage{
+	{nil, tracker.TrackingAPIName, thrift.CALL, &tracking.UpgradeArgs_{AppID: "client"}},
+	{nil, tracker.TrackingAPIName, thrift.REPLY, &tracking.UpgradeReply{}},
+	{testHeader(), "add", thrift.CALL, &calculator.CalculatorServiceAddArgs{Num1: 1, Num2: 2}},
+	{nil, "add", thrift.REPLY, &calculator.CalculatorServiceAddResult{Success: int32Ptr(3)}},
+	{testHeader(), "log", thrift.ONEWAY, &calculator.CalculatorServiceLogArgs{Message: "hi"}},
+	{testHeader(), "ping", thrift.CALL, &calculator.CalculatorServicePingArgs{}},
+	{nil, "ping", thrift.REPLY, &calculator.CalculatorServicePingResult{
+		UserException: &calculator.CalculatorUserException{
+			ErrorCode: calculator.CalculatorErrorCode_TOO_BUSY_ERROR,
+			ErrorName: "busy",
+		},
+	}},
+	{nil, "sub", thrift.EXCEPTION, thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function sub")},
+}
+
+// encode writes msgs as a client or server would and returns the stream
+// with the offset at which each message starts.
+func encode(t *testing.T, opts Options, msgs []testMessage) ([]byte, []int64) {
+	var stream []byte
+	var offsets []int64
+	for i, m := range msgs {
+		buf := thrift.NewTMemoryBuffer()
+		var p thrift.TProtocol = thrift.NewTBinaryProtocolTransport(buf)
+		if opts.Compact {
+			p = thrift.NewTCompactProtocol(buf)
+		}
+		if m.header != nil {
+			if err := m.header.Write(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.WriteMessageBegin(m.name, m.typ, int32(i))
+		if err := m.body.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		p.WriteMessageEnd()
+		p.Flush()
+		if opts.Framed {
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(buf.Len()))
+			stream = append(stream, size[:]...)
+		}
+		offsets = append(offsets, int64(len(stream)))
+		stream = append(stream, buf.Bytes()...)
+	}
+	return stream, offsets
+}
+
+func encodeHeader(compact bool) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	var p thrift.TProtocol = thrift.NewTBinaryProtocolTransport(buf)
+	if compact {
+		p = thrift.NewTCompactProtocol(buf)
+	}
+	testHeader().Write(p)
+	return buf.Bytes()
+}
+
+func decodeAll(data []byte, opts Options) ([]*Message, error) {
+	d := NewDecoder(&chunkReader{data}, opts)
+	var msgs []*Message
+	for {
+		m, err := d.Next()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// chunkReader returns at most 3 bytes a read, as a stream split into
+// segments would.
+type chunkReader struct {
+	data []byte
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if len(b) > 3 {
+		b = b[:3]
+	}
+	n := copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+var testOptions = []struct {
+	name string
+	opts Options
+}{
+	{"binary", Options{}},
+	{"compact", Options{Compact: true}},
+	{"framed binary", Options{Framed: true}},
+	{"framed compact", Options{Framed: true, Compact: true}},
+}
+
+func TestDecoder(t *testing.T) {
+	bodies := []string{
+		`{app_id: "client"}`, // tracking structs are known without an IDL
+		`{}`,
+		`{1: 1, 2: 2}`,
+		`{0: 3}`,
+		`{1: "hi"}`,
+		`{}`,
+		`{1: {1: 2, 2: "busy"}}`,
+		`{message: "Unknown function sub", type: UNKNOWN_METHOD}`,
+	}
+	for _, c := range testOptions {
+		t.Run(c.name, func(t *testing.T) {
+			data, offsets := encode(t, c.opts, testMessages)
+			msgs, err := decodeAll(data, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != len(testMessages) {
+				t.Fatalf("decoded %d messages, want %d", len(msgs), len(testMessages))
+			}
+			for i, m := range msgs {
+				want := testMessages[i]
+				if m.Offset != offsets[i] || m.Name != want.name || m.Type != want.typ || m.SeqID != int32(i) {
+					t.Errorf("message %d: got %s %s seqid %d at %d, want %s %s seqid %d at %d",
+						i, TypeName(m.Type), m.Name, m.SeqID, m.Offset,
+						TypeName(want.typ), want.name, i, offsets[i])
+				}
+				if got := Format(m.Body); got != bodies[i] {
+					t.Errorf("message %d: got body %s, want %s", i, got, bodies[i])
+				}
+				if want.header == nil && m.Header != nil {
+					t.Errorf("message %d: got header %+v, want none", i, m.Header)
+				}
+				if want.header != nil && !reflect.DeepEqual(m.Header, &Header{"req-1", "1.1", map[string]string{"k": "v"}}) {
+					t.Errorf("message %d: got header %+v", i, m.Header)
+				}
+			}
+			if msgs[0].Upgrade() != true || msgs[2].Upgrade() != false {
+				t.Error("Upgrade does not tell the upgrade call")
+			}
+		})
+	}
+}
+
+func TestDecoderIDL(t *testing.T) {
+	idl, err := LoadIDL("../example/calculator.thrift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := encode(t, Options{}, testMessages)
+	msgs, err := decodeAll(data, Options{IDL: idl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		i    int
+		want string
+	}{
+		{0, `{app_id: "client"}`},
+		{2, `{num1: 1, num2: 2}`},
+		{3, `{success: 3}`},
+		{4, `{message: "hi"}`},
+		{6, `{user_exception: {error_code: TOO_BUSY_ERROR, error_name: "busy"}}`},
+	} {
+		if got := Format(msgs[c.i].Body); got != c.want {
+			t.Errorf("message %d: got %s, want %s", c.i, got, c.want)
+		}
+	}
+	if name := msgs[2].Body.Name; name != "add_args" {
+		t.Errorf("got struct name %q, want add_args", name)
+	}
+}
+
+func TestDecoderAtMessage(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		for i, m := range testMessages {
+			data, _ := encode(t, Options{Compact: compact}, []testMessage{m})
+			d := NewDecoder(&chunkReader{data}, Options{Compact: compact})
+			isMessage, err := d.atMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isMessage != (m.header == nil) {
+				t.Errorf("compact %v, message %d: got atMessage %v with header %v", compact, i, isMessage, m.header)
+			}
+		}
+	}
+}
+
+func TestDecoderHeaderWithoutMessage(t *testing.T) {
+	for _, c := range testOptions {
+		t.Run(c.name, func(t *testing.T) {
+			// a header followed by another header
+			data, _ := encode(t, Options{Compact: c.opts.Compact}, testMessages[2:3])
+			stream := append(encodeHeader(c.opts.Compact), data...)
+			if c.opts.Framed {
+				var size [4]byte
+				binary.BigEndian.PutUint32(size[:], uint32(len(stream)))
+				stream = append(size[:], stream...)
+			}
+			_, err := decodeAll(stream, c.opts)
+			var derr *Error
+			if !errors.As(err, &derr) || derr.Err.Error() != "no message after header" {
+				t.Fatalf("got %v, want no message after header", err)
+			}
+		})
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	for _, c := range testOptions {
+		t.Run(c.name, func(t *testing.T) {
+			data, offsets := encode(t, c.opts, testMessages)
+			headerLen := int64(len(encodeHeader(c.opts.Compact)))
+			// the error is at the message, or its frame, cut short
+			start := func(i int) int64 {
+				if c.opts.Framed {
+					return offsets[i] - 4
+				}
+				return offsets[i]
+			}
+			for n := 1; n < len(data); n++ {
+				i := 0
+				for i+1 < len(offsets) && start(i+1) < int64(n) {
+					i++
+				}
+				if i+1 < len(offsets) && start(i+1) == int64(n) {
+					// cut between messages
+					continue
+				}
+				msgs, err := decodeAll(data[:n], c.opts)
+				var derr *Error
+				if !errors.As(err, &derr) {
+					t.Fatalf("cut at %d: got %v, want an *Error", n, err)
+				}
+				want := start(i)
+				if !c.opts.Framed && testMessages[i].header != nil && int64(n) == offsets[i]+headerLen {
+					// a complete header, the message is missing where it
+					// should begin
+					want = int64(n)
+				}
+				if derr.Offset != want || len(msgs) != i {
+					t.Fatalf("cut at %d: got %v after %d messages, want offset %d after %d", n, err, len(msgs), want, i)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderSticky(t *testing.T) {
+	data, _ := encode(t, Options{}, testMessages[:1])
+	d := NewDecoder(&chunkReader{data[:len(data)-1]}, Options{})
+	_, err := d.Next()
+	if _, ok := err.(*Error); !ok {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	if _, again := d.Next(); again != err {
+		t.Fatalf("got %v, then %v", err, again)
+	}
+}
+
+func TestDecoderFrameTooLarge(t *testing.T) {
+	data, _ := encode(t, Options{Framed: true}, testMessages[:1])
+	_, err := decodeAll(data, Options{Framed: true, MaxFrameSize: 4})
+	if derr, ok := err.(*Error); !ok || derr.Offset != 0 {
+		t.Fatalf("got %v, want an *Error at 0", err)
+	}
+}
+
+func TestDecoderHeaderMode(t *testing.T) {
+	for _, c := range testOptions {
+		t.Run(c.name, func(t *testing.T) {
+			// the upgrade call, then calls with headers
+			calls := []testMessage{testMessages[0], testMessages[2], testMessages[4], testMessages[5]}
+			data, offsets := encode(t, c.opts, calls)
+			d := NewDecoder(&chunkReader{data}, c.opts)
+			d.SetHeaderMode(HeaderNever)
+			for i, m := range calls {
+				if i == 1 {
+					d.SetHeaderMode(HeaderAlways)
+				}
+				want := offsets[i]
+				if c.opts.Framed {
+					want -= 4
+				}
+				if got := d.Offset(); got != want {
+					t.Fatalf("message %d: got offset %d, want %d", i, got, want)
+				}
+				got, err := d.Next()
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if got.Name != m.name || (got.Header != nil) != (m.header != nil) {
+					t.Fatalf("message %d: got %s with header %v", i, got.Name, got.Header)
+				}
+			}
+			if _, err := d.Next(); err != io.EOF {
+				t.Fatalf("got %v, want io.EOF", err)
+			}
+		})
+	}
+
+	// a header read as a message
+	data, _ := encode(t, Options{}, testMessages[2:3])
+	d := NewDecoder(&chunkReader{data}, Options{})
+	d.SetHeaderMode(HeaderNever)
+	if _, err := d.Next(); err == nil {
+		t.Fatal("decoded a header as a message")
+	}
+}
@@ -0,0 +1,218 @@
+package wire
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/eleme/thrift-tracker/thriftidl"
+)
+
+// IDL names the fields of decoded messages after the functions and structs
+// of a .thrift file and its includes.
+type IDL struct {
+	files []*idlFile // the main file first
+}
+
+type idlFile struct {
+	*thriftidl.File
+	includes map[string]*idlFile // by include prefix
+}
+
+// LoadIDL parses filename and, relative to it, its includes.
+func LoadIDL(filename string) (*IDL, error) {
+	idl := &IDL{}
+	if _, err := idl.load(filename, make(map[string]*idlFile)); err != nil {
+		return nil, err
+	}
+	return idl, nil
+}
+
+func (idl *IDL) load(filename string, loaded map[string]*idlFile) (*idlFile, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := loaded[abs]; ok {
+		return f, nil
+	}
+	parsed, err := thriftidl.ParseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &idlFile{File: parsed, includes: make(map[string]*idlFile)}
+	loaded[abs] = f
+	idl.files = append(idl.files, f)
+	for _, inc := range parsed.Includes {
+		included, err := idl.load(filepath.Join(filepath.Dir(filename), inc.Path), loaded)
+		if err != nil {
+			return nil, err
+		}
+		f.includes[strings.TrimSuffix(filepath.Base(inc.Path), ".thrift")] = included
+	}
+	return f, nil
+}
+
+// function finds the function called by a message, name may be prefixed with
+// a service name by TMultiplexedProtocol.
+func (idl *IDL) function(name string) (*idlFile, *thriftidl.Function) {
+	var service string
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		service, name = name[:i], name[i+1:]
+	}
+	for _, f := range idl.files {
+		for _, s := range f.Services {
+			if service != "" && s.Name != service {
+				continue
+			}
+			if fn := s.Function(name); fn != nil {
+				return f, fn
+			}
+		}
+	}
+	return nil, nil
+}
+
+// lookup splits an included prefix off name.
+func (f *idlFile) lookup(name string) (*idlFile, string) {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		if inc, ok := f.includes[name[:i]]; ok {
+			return inc, name[i+1:]
+		}
+	}
+	return f, name
+}
+
+// typeRef is a type in the scope of the file declaring it, the zero value is
+// an unknown type.
+type typeRef struct {
+	file *idlFile
+	typ  *thriftidl.Type
+}
+
+func (r typeRef) resolve() typeRef {
+	// bounded, typedefs may be cyclic
+	for i := 0; r.typ != nil && i < 16; i++ {
+		f, name := r.file.lookup(r.typ.Name)
+		td := f.Typedef(name)
+		if td == nil {
+			return r
+		}
+		r = typeRef{f, td.Type}
+	}
+	return r
+}
+
+func (r typeRef) key() typeRef {
+	if r = r.resolve(); r.typ == nil || r.typ.KeyType == nil {
+		return typeRef{}
+	}
+	return typeRef{r.file, r.typ.KeyType}
+}
+
+func (r typeRef) elem() typeRef {
+	if r = r.resolve(); r.typ == nil || r.typ.ValueType == nil {
+		return typeRef{}
+	}
+	return typeRef{r.file, r.typ.ValueType}
+}
+
+func (r typeRef) enum() *thriftidl.Enum {
+	if r = r.resolve(); r.typ == nil {
+		return nil
+	}
+	f, name := r.file.lookup(r.typ.Name)
+	return f.Enum(name)
+}
+
+func (r typeRef) schema() *structSchema {
+	if r = r.resolve(); r.typ == nil {
+		return nil
+	}
+	f, name := r.file.lookup(r.typ.Name)
+	s := f.Struct(name)
+	if s == nil {
+		return nil
+	}
+	return &structSchema{name: s.Name, file: f, fields: s.Fields}
+}
+
+// structSchema names the fields of a struct, or of the args or result of a
+// function.
+type structSchema struct {
+	name   string
+	file   *idlFile
+	fields []*thriftidl.Field
+}
+
+func (s *structSchema) field(id int16) (string, typeRef) {
+	if s == nil {
+		return "", typeRef{}
+	}
+	for _, f := range s.fields {
+		if f.ID == int(id) {
+			return f.Name, typeRef{s.file, f.Type}
+		}
+	}
+	return "", typeRef{}
+}
+
+func argsSchema(f *idlFile, fn *thriftidl.Function) *structSchema {
+	return &structSchema{name: fn.Name + "_args", file: f, fields: fn.Params}
+}
+
+func resultSchema(f *idlFile, fn *thriftidl.Function) *structSchema {
+	fields := fn.Throws
+	if fn.ReturnType != nil {
+		success := &thriftidl.Field{ID: 0, Name: "success", Type: fn.ReturnType}
+		fields = append([]*thriftidl.Field{success}, fields...)
+	}
+	return &structSchema{name: fn.Name + "_result", file: f, fields: fields}
+}
+
+// builtin declares what is decoded without an IDL, as in tracking.thrift.
+var builtin = mustParseBuiltin(`
+struct RequestHeader {
+    1: string request_id
+    2: string seq
+    3: map<string, string> meta
+}
+
+struct ResponseHeader {
+    1: map<string, string> meta
+}
+
+struct UpgradeArgs {
+    1: string app_id
+}
+
+struct UpgradeReply {
+}
+
+enum TApplicationExceptionType {
+    UNKNOWN_APPLICATION_EXCEPTION = 0,
+    UNKNOWN_METHOD = 1,
+    INVALID_MESSAGE_TYPE_EXCEPTION = 2,
+    WRONG_METHOD_NAME = 3,
+    BAD_SEQUENCE_ID = 4,
+    MISSING_RESULT = 5,
+    INTERNAL_ERROR = 6,
+    PROTOCOL_ERROR = 7,
+}
+
+struct TApplicationException {
+    1: string message
+    2: TApplicationExceptionType type
+}
+`)
+
+func mustParseBuiltin(src string) *idlFile {
+	f, err := thriftidl.Parse("builtin.thrift", []byte(src))
+	if err != nil {
+		panic(err)
+	}
+	return &idlFile{File: f}
+}
+
+func builtinSchema(name string) *structSchema {
+	return typeRef{builtin, &thriftidl.Type{Name: name}}.schema()
+}
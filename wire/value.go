@@ -0,0 +1,182 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decoded values are bool, int8, int16, int32, int64, float64, string, Enum,
+// []interface{} for lists, Set, Map and *Struct.
+
+// Struct is a decoded struct, Name and field names are known from an IDL
+// only.
+type Struct struct {
+	Name   string
+	Fields []*Field
+}
+
+type Field struct {
+	ID    int16
+	Name  string
+	Value interface{}
+}
+
+// Field returns the value of field id, or nil.
+func (s *Struct) Field(id int16) interface{} {
+	for _, f := range s.Fields {
+		if f.ID == id {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// Set is a decoded set, in wire order.
+type Set []interface{}
+
+// Map is a decoded map, in wire order.
+type Map []MapEntry
+
+type MapEntry struct {
+	Key, Value interface{}
+}
+
+// Enum is an i32 of an enum type known from an IDL, Name is empty for an
+// undeclared value.
+type Enum struct {
+	Name  string
+	Value int32
+}
+
+// Format formats v on one line, e.g. {num1: 1, num2: 2}, fields without a
+// name are keyed by id. Map entries and set elements are sorted, so that
+// equal values format alike whatever their order on the wire.
+func Format(v interface{}) string {
+	var buf bytes.Buffer
+	format(&buf, v)
+	return buf.String()
+}
+
+func format(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case *Struct:
+		buf.WriteByte('{')
+		for i, f := range v.Fields {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(f.key())
+			buf.WriteString(": ")
+			format(buf, f.Value)
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			format(buf, elem)
+		}
+		buf.WriteByte(']')
+	case Set:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			elems[i] = Format(elem)
+		}
+		sort.Strings(elems)
+		buf.WriteByte('[')
+		buf.WriteString(strings.Join(elems, ", "))
+		buf.WriteByte(']')
+	case Map:
+		entries := make([]string, len(v))
+		for i, e := range v {
+			entries[i] = Format(e.Key) + ": " + Format(e.Value)
+		}
+		sort.Strings(entries)
+		buf.WriteByte('{')
+		buf.WriteString(strings.Join(entries, ", "))
+		buf.WriteByte('}')
+	case Enum:
+		buf.WriteString(v.String())
+	case string:
+		buf.WriteString(strconv.Quote(v))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int8:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int16:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int32:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		buf.WriteString("null")
+	}
+}
+
+func (f *Field) key() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return strconv.Itoa(int(f.ID))
+}
+
+func (e Enum) String() string {
+	if e.Name == "" {
+		return strconv.Itoa(int(e.Value))
+	}
+	return e.Name
+}
+
+// MarshalJSON writes an object keyed by field name or id, in field order.
+func (s *Struct) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range s.Fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(f.key()))
+		buf.WriteByte(':')
+		b, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON writes an object when all keys are strings, and an array of
+// [key, value] pairs otherwise.
+func (m Map) MarshalJSON() ([]byte, error) {
+	pairs := make([][2]interface{}, 0, len(m))
+	object := make(map[string]interface{}, len(m))
+	for _, e := range m {
+		pairs = append(pairs, [2]interface{}{e.Key, e.Value})
+		if key, ok := e.Key.(string); ok && object != nil {
+			object[key] = e.Value
+		} else {
+			object = nil
+		}
+	}
+	if object != nil {
+		return json.Marshal(object)
+	}
+	return json.Marshal(pairs)
+}
+
+func (e Enum) MarshalJSON() ([]byte, error) {
+	if e.Name == "" {
+		return json.Marshal(e.Value)
+	}
+	return json.Marshal(e.Name)
+}
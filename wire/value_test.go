@@ -0,0 +1,23 @@
+package wire
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	for _, c := range []struct {
+		v    interface{}
+		want string
+	}{
+		{&Struct{Fields: []*Field{{ID: 1, Value: int32(1)}, {ID: 2, Name: "msg", Value: "hi"}}}, `{1: 1, msg: "hi"}`},
+		{[]interface{}{int64(2), int64(1)}, "[2, 1]"},
+		{Set{"b", "a", "c"}, `["a", "b", "c"]`},
+		{Map{{"b", int8(2)}, {"a", int8(1)}}, `{"a": 1, "b": 2}`},
+		{Map{{int16(10), Set{true, false}}, {int16(9), nil}}, "{10: [false, true], 9: null}"},
+		{Enum{Name: "ADD", Value: 1}, "ADD"},
+		{Enum{Value: 7}, "7"},
+		{1.5, "1.5"},
+	} {
+		if got := Format(c.v); got != c.want {
+			t.Errorf("got %s, want %s", got, c.want)
+		}
+	}
+}